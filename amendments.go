@@ -0,0 +1,643 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ====AMENDMENTS (CLI) ====================================================================
+// 修改运单 peer chaincode invoke -C myc1 -n orders -c '{"Args":["updateOrder","orderId0","{\"toAddress\":\"北京市朝阳区\",\"weightTon\":22}","地址笔误"]}'
+// 查询修改记录 peer chaincode query -C myc1 -n orders -c '{"Args":["queryOrderAmendments","orderId0","10",""]}'
+// 提议修改 peer chaincode invoke -C myc1 -n orders -c '{"Args":["proposeAmendment","orderId0","{\"transFee\":4500}","油价上涨","2019-04-02T08:00:00+08:00"]}'
+// 同意修改 peer chaincode invoke -C myc1 -n orders -c '{"Args":["approveAmendment","orderId0","proposalId"]}'
+// 拒绝修改 peer chaincode invoke -C myc1 -n orders -c '{"Args":["rejectAmendment","orderId0","proposalId","不同意"]}'
+// 查询修改提议 peer chaincode query -C myc1 -n orders -c '{"Args":["queryAmendmentProposals","orderId0"]}'
+//
+// updateOrder changes some fields of an order instead of deleting and recreating it. Each
+// field may only be changed in some states and by some party: the goods owner corrects the
+// cargo, the addresses or locations and the fee, the broker the vehicle, and admins
+// anything. A new location also replaces the address snapshot of the order. The fee
+// is fixed once the driver is on the road; the destination until the order has arrived. A
+// new fee locks more of the goods owner's credit or gives some back. Every update is kept
+// as an orderAmendment (amendment: orderId, txId) with the values before and after.
+//
+// Once a driver has accepted the order, changing the fee needs the consent of goods owner
+// and broker, changing the route that of the driver as well, and a new destination that of
+// the consignee too. Such a change is proposed with a deadline and stored pending
+// (amendmentProposal: orderId, proposalId); the proposer approves it by proposing it. Each
+// of the other parties approves or rejects it in a transaction of their own. The last
+// approval applies it, unless the order changed in the meantime. A proposal still pending
+// at its deadline has expired. Admins change orders without proposals.
+// =========================================================================================
+
+const (
+	orderAmendmentIndex    = "amendment"
+	amendmentProposalIndex = "amendmentProposal"
+)
+
+// orderFieldRules lists, per field, the states it may be changed in and the parties who
+// may change it besides admins
+var orderFieldRules = map[string]struct {
+	states []string
+	roles  []string
+}{
+	"fromAddress":    {[]string{"WAIT_DRIVER_ACCEPT", "DRIVER_ACCEPT_WAIT_ROAD"}, []string{"goodsOwner"}},
+	"toAddress":      {[]string{"WAIT_DRIVER_ACCEPT", "DRIVER_ACCEPT_WAIT_ROAD", "DRIVER_ON_ROAD"}, []string{"goodsOwner"}},
+	"fromLocationId": {[]string{"WAIT_DRIVER_ACCEPT", "DRIVER_ACCEPT_WAIT_ROAD"}, []string{"goodsOwner"}},
+	"toLocationId":   {[]string{"WAIT_DRIVER_ACCEPT", "DRIVER_ACCEPT_WAIT_ROAD", "DRIVER_ON_ROAD"}, []string{"goodsOwner"}},
+	"content":        {[]string{"WAIT_DRIVER_ACCEPT", "DRIVER_ACCEPT_WAIT_ROAD"}, []string{"goodsOwner"}},
+	"weightTon":      {[]string{"WAIT_DRIVER_ACCEPT", "DRIVER_ACCEPT_WAIT_ROAD"}, []string{"goodsOwner"}},
+	"transFee":       {[]string{"WAIT_DRIVER_ACCEPT", "DRIVER_ACCEPT_WAIT_ROAD"}, []string{"goodsOwner"}},
+	"vehiclePlate":   {[]string{"WAIT_DRIVER_ACCEPT", "DRIVER_ACCEPT_WAIT_ROAD"}, []string{"broker"}},
+}
+
+func decodeOrderPatch(arg string) (*OrderPatch, error) {
+	decoder := json.NewDecoder(bytes.NewReader([]byte(arg)))
+	decoder.DisallowUnknownFields()
+	patch := &OrderPatch{}
+	err := decoder.Decode(patch)
+	if err != nil {
+		return nil, fmt.Errorf("Patch must be a JSON object of fromAddress, toAddress, fromLocationId, toLocationId, content, weightTon, transFee or vehiclePlate: %s", err.Error())
+	}
+	if (patch.FromAddress != nil && *patch.FromAddress == "") || (patch.ToAddress != nil && *patch.ToAddress == "") ||
+		(patch.FromLocationId != nil && *patch.FromLocationId == "") || (patch.ToLocationId != nil && *patch.ToLocationId == "") ||
+		(patch.Content != nil && *patch.Content == "") {
+		return nil, fmt.Errorf("Addresses, locations and content cannot be empty")
+	}
+	if patch.WeightTon != nil && *patch.WeightTon <= 0 {
+		return nil, fmt.Errorf("weightTon must be positive")
+	}
+	if patch.TransFee != nil && *patch.TransFee < 0 {
+		return nil, fmt.Errorf("transFee must not be negative")
+	}
+	return patch, nil
+}
+
+// applyOrderPatch changes the fields of an order, returning the fields that changed
+func applyOrderPatch(order *Order, patch *OrderPatch) []FieldChange {
+	changes := []FieldChange{}
+	setString := func(field string, value *string, target *string) {
+		if value != nil && *value != *target {
+			changes = append(changes, FieldChange{field, *target, *value})
+			*target = *value
+		}
+	}
+	setFloat := func(field string, value *float64, target *float64) {
+		if value != nil && *value != *target {
+			changes = append(changes, FieldChange{field, *target, *value})
+			*target = *value
+		}
+	}
+	setString("fromAddress", patch.FromAddress, &order.FromAddress)
+	setString("toAddress", patch.ToAddress, &order.ToAddress)
+	setString("fromLocationId", patch.FromLocationId, &order.FromLocationId)
+	setString("toLocationId", patch.ToLocationId, &order.ToLocationId)
+	setString("content", patch.Content, &order.Content)
+	setFloat("weightTon", patch.WeightTon, &order.WeightTon)
+	setFloat("transFee", patch.TransFee, &order.TransFee)
+	setString("vehiclePlate", patch.VehiclePlate, &order.VehiclePlate)
+	return changes
+}
+
+// checkFieldEditable refuses a change of a field in a state that does not allow it
+func checkFieldEditable(order *Order, field string) error {
+	for _, state := range orderFieldRules[field].states {
+		if state == order.OrderState {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s cannot be changed when the order is %s", field, order.OrderState)
+}
+
+// checkOrderChange refuses a change of a field the caller may not make in the order's state
+func checkOrderChange(caller *User, order *Order, field string) error {
+	err := checkFieldEditable(order, field)
+	if err != nil {
+		return err
+	}
+	if isAdmin(caller) {
+		return nil
+	}
+	parties := orderParties(order)
+	for _, role := range orderFieldRules[field].roles {
+		for _, party := range parties {
+			if party.role == role && party.partyId == caller.UserId {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("User %s cannot change %s of order %s", caller.UserId, field, order.OrderId)
+}
+
+// amendmentApprovers lists the parties who must approve changes of an order once a driver
+// has accepted it: goods owner and broker for the fee, every party for the route. A
+// consignee only approves a change of where the goods are delivered.
+func amendmentApprovers(order *Order, changes []FieldChange) []string {
+	if order.OrderState == "WAIT_DRIVER_ACCEPT" {
+		return nil
+	}
+	roles := map[string]bool{}
+	for _, change := range changes {
+		switch change.Field {
+		case "transFee":
+			roles["goodsOwner"], roles["broker"] = true, true
+		case "fromAddress", "fromLocationId":
+			roles["goodsOwner"], roles["broker"], roles["driver"] = true, true, true
+		case "toAddress", "toLocationId":
+			roles["goodsOwner"], roles["broker"], roles["driver"], roles["consignee"] = true, true, true, true
+		}
+	}
+	var approvers []string
+	for _, party := range orderParties(order) {
+		if roles[party.role] && !containsString(approvers, party.partyId) {
+			approvers = append(approvers, party.partyId)
+		}
+	}
+	return approvers
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// amendOrder saves an order whose fields have been changed, after checking the changes are
+// allowed in its state, and records the amendment. A new location brings its address along.
+func amendOrder(stub shim.ChaincodeStubInterface, order *Order, changes []FieldChange, amendment *OrderAmendment) error {
+	addressChanges, err := locationChanges(stub, order, changes)
+	if err != nil {
+		return err
+	}
+	changes = append(changes, addressChanges...)
+	for _, change := range changes {
+		err := checkFieldEditable(order, change.Field)
+		if err != nil {
+			return err
+		}
+		switch change.Field {
+		case "weightTon", "vehiclePlate":
+			err = checkOrderVehicle(stub, order)
+		case "transFee":
+			err = adjustEscrow(stub, order.OrderId, order.TransFee)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	err = putOrder(stub, order)
+	if err != nil {
+		return err
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return err
+	}
+	amendment.ObjectType = "orderAmendment"
+	amendment.OrderId = order.OrderId
+	amendment.TxId = stub.GetTxID()
+	amendment.OrderState = order.OrderState
+	amendment.Timestamp = txTime.Format(time.RFC3339)
+	amendment.Changes = changes
+	return putOrderAmendment(stub, amendment)
+}
+
+func putOrderAmendment(stub shim.ChaincodeStubInterface, amendment *OrderAmendment) error {
+	key, err := stub.CreateCompositeKey(orderAmendmentIndex, []string{amendment.OrderId, amendment.TxId})
+	if err != nil {
+		return err
+	}
+	amendmentAsBytes, err := json.Marshal(amendment)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, amendmentAsBytes)
+}
+
+// ============================================================
+// updateOrder - change some fields of an order
+// ============================================================
+func (t *SimpleChaincode) updateOrder(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0       		1       							2
+	// "orderId0", "{\"toAddress\":\"北京市朝阳区\"}", "reason"
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	if len(args[2]) <= 0 {
+		return shim.Error("3rd argument must be a non-empty string")
+	}
+	fmt.Println("- start updateOrder")
+	orderId := args[0]
+	patch, err := decodeOrderPatch(args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	caller, err := getCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	order, err := getOrder(stub, orderId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	changes := applyOrderPatch(order, patch)
+	if len(changes) == 0 {
+		return shim.Error("The patch does not change order " + orderId)
+	}
+	for _, change := range changes {
+		err = checkOrderChange(caller, order, change.Field)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	if !isAdmin(caller) {
+		var others []string
+		for _, approver := range amendmentApprovers(order, changes) {
+			if approver != caller.UserId {
+				others = append(others, approver)
+			}
+		}
+		if len(others) > 0 {
+			return shim.Error(fmt.Sprintf("Order %s is %s, the change needs the approval of %s, propose it with proposeAmendment",
+				orderId, order.OrderState, strings.Join(others, ", ")))
+		}
+	}
+	err = amendOrder(stub, order, changes, &OrderAmendment{AmendedBy: caller.UserId, Reason: args[2]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Println("- end updateOrder (success)")
+	return shim.Success(nil)
+}
+
+// ============================================================
+// queryOrderAmendments - list the amendments of an order, oldest first
+// ============================================================
+func (t *SimpleChaincode) queryOrderAmendments(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0       		1		2
+	// "orderId0", ["10", "bookmark"]
+	pageSize, bookmark, err := parsePaginationArgs(args, 1)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	order, err := getOrder(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	caller, err := getCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !canReadOrder(caller, order) {
+		return shim.Error("User " + caller.UserId + " cannot read order " + order.OrderId)
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(orderAmendmentIndex, []string{order.OrderId})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+	var amendments []OrderAmendment
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		var amendment OrderAmendment
+		err = json.Unmarshal(queryResponse.Value, &amendment)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		amendments = append(amendments, amendment)
+	}
+	// transaction ids are not ordered in time, the amendments are
+	sort.SliceStable(amendments, func(i, j int) bool {
+		return amendments[i].Timestamp < amendments[j].Timestamp
+	})
+	var records []QueryRecord
+	for _, amendment := range amendments {
+		amendmentAsBytes, err := json.Marshal(amendment)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		records = append(records, QueryRecord{amendment.TxId, amendmentAsBytes})
+	}
+	page, err := pageOfRecords(records, pageSize, bookmark)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	pageAsBytes, err := json.Marshal(page)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(pageAsBytes)
+}
+
+func amendmentProposalKey(stub shim.ChaincodeStubInterface, orderId string, proposalId string) (string, error) {
+	return stub.CreateCompositeKey(amendmentProposalIndex, []string{orderId, proposalId})
+}
+
+func getAmendmentProposal(stub shim.ChaincodeStubInterface, orderId string, proposalId string) (*AmendmentProposal, error) {
+	key, err := amendmentProposalKey(stub, orderId, proposalId)
+	if err != nil {
+		return nil, err
+	}
+	proposalAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	} else if proposalAsBytes == nil {
+		return nil, fmt.Errorf("Proposal %s of order %s does not exist", proposalId, orderId)
+	}
+	proposal := &AmendmentProposal{}
+	err = json.Unmarshal(proposalAsBytes, proposal)
+	return proposal, err
+}
+
+func putAmendmentProposal(stub shim.ChaincodeStubInterface, proposal *AmendmentProposal) error {
+	key, err := amendmentProposalKey(stub, proposal.OrderId, proposal.ProposalId)
+	if err != nil {
+		return err
+	}
+	proposalAsBytes, err := json.Marshal(proposal)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, proposalAsBytes)
+}
+
+// proposalStatus returns the status of a proposal at a time; a pending proposal past its
+// deadline has expired
+func proposalStatus(proposal *AmendmentProposal, now time.Time) string {
+	// RFC3339 times in UTC compare as strings
+	if proposal.Status == "PENDING" && now.UTC().Format(time.RFC3339) >= proposal.ExpiresAt {
+		return "EXPIRED"
+	}
+	return proposal.Status
+}
+
+// pendingProposal reads a proposal that can still be approved or rejected
+func pendingProposal(stub shim.ChaincodeStubInterface, orderId string, proposalId string, now time.Time) (*AmendmentProposal, error) {
+	proposal, err := getAmendmentProposal(stub, orderId, proposalId)
+	if err != nil {
+		return nil, err
+	}
+	status := proposalStatus(proposal, now)
+	if status == "EXPIRED" {
+		return nil, fmt.Errorf("Proposal %s expired at %s", proposalId, proposal.ExpiresAt)
+	} else if status != "PENDING" {
+		return nil, fmt.Errorf("Proposal %s is %s", proposalId, status)
+	}
+	return proposal, nil
+}
+
+// ============================================================
+// proposeAmendment - propose a change of an accepted order to the parties it affects
+// ============================================================
+func (t *SimpleChaincode) proposeAmendment(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0       		1       				2			3
+	// "orderId0", "{\"transFee\":4500}", "reason", "2019-04-02T08:00:00+08:00"
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+	if len(args[2]) <= 0 {
+		return shim.Error("3rd argument must be a non-empty string")
+	}
+	orderId := args[0]
+	patch, err := decodeOrderPatch(args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	expiresAt, err := parseDeadline(args[3])
+	if err != nil || expiresAt == "" {
+		return shim.Error("4th argument must be an RFC3339 time")
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if expiresAt <= txTime.UTC().Format(time.RFC3339) {
+		return shim.Error("The proposal must expire after " + txTime.UTC().Format(time.RFC3339))
+	}
+	caller, err := getCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	order, err := getOrder(stub, orderId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if caller.UserId != order.GoodsOwnerId && caller.UserId != order.BrokerId && caller.UserId != order.DriverId {
+		return shim.Error("Only a party of order " + orderId + " can propose amendments")
+	}
+	changes := applyOrderPatch(order, patch)
+	if len(changes) == 0 {
+		return shim.Error("The patch does not change order " + orderId)
+	}
+	for _, change := range changes {
+		err = checkFieldEditable(order, change.Field)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	// the implied address changes are made again when the proposal is applied
+	_, err = locationChanges(stub, order, changes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	approvers := amendmentApprovers(order, changes)
+	if len(approvers) == 0 || (len(approvers) == 1 && approvers[0] == caller.UserId) {
+		return shim.Error("The change needs no approval, make it with updateOrder")
+	}
+
+	proposal := &AmendmentProposal{ObjectType: "amendmentProposal", ProposalId: stub.GetTxID(), OrderId: orderId,
+		ProposedBy: caller.UserId, Reason: args[2], Patch: *patch, Changes: changes, RequiredApprovals: approvers,
+		Approvals: []Approval{}, Status: "PENDING", ProposedAt: txTime.UTC().Format(time.RFC3339), ExpiresAt: expiresAt}
+	if containsString(approvers, caller.UserId) {
+		proposal.Approvals = append(proposal.Approvals, Approval{caller.UserId, proposal.ProposedAt})
+	}
+	err = putAmendmentProposal(stub, proposal)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	proposalAsBytes, err := json.Marshal(proposal)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(proposalAsBytes)
+}
+
+// ============================================================
+// approveAmendment - approve a proposal, applying it once every party has approved
+// ============================================================
+func (t *SimpleChaincode) approveAmendment(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0       		1
+	// "orderId0", "proposalId"
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	proposal, err := pendingProposal(stub, args[0], args[1], txTime)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	caller, err := getCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !containsString(proposal.RequiredApprovals, caller.UserId) {
+		return shim.Error("User " + caller.UserId + " is not asked to approve proposal " + proposal.ProposalId)
+	}
+	var approvedBy []string
+	for _, approval := range proposal.Approvals {
+		approvedBy = append(approvedBy, approval.UserId)
+	}
+	if containsString(approvedBy, caller.UserId) {
+		return shim.Error("User " + caller.UserId + " has already approved proposal " + proposal.ProposalId)
+	}
+	now := txTime.UTC().Format(time.RFC3339)
+	proposal.Approvals = append(proposal.Approvals, Approval{caller.UserId, now})
+	approvedBy = append(approvedBy, caller.UserId)
+
+	if len(approvedBy) == len(proposal.RequiredApprovals) {
+		order, err := getOrder(stub, proposal.OrderId)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		changes := applyOrderPatch(order, &proposal.Patch)
+		if !reflect.DeepEqual(changes, proposal.Changes) {
+			return shim.Error(fmt.Sprintf("Order %s changed since proposal %s was made, reject it and propose again",
+				proposal.OrderId, proposal.ProposalId))
+		}
+		err = amendOrder(stub, order, changes, &OrderAmendment{AmendedBy: proposal.ProposedBy, Reason: proposal.Reason,
+			ProposalId: proposal.ProposalId, ApprovedBy: approvedBy})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		proposal.Status = "APPLIED"
+		proposal.ResolvedAt = now
+	}
+	err = putAmendmentProposal(stub, proposal)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ============================================================
+// rejectAmendment - refuse a proposal, or withdraw one's own
+// ============================================================
+func (t *SimpleChaincode) rejectAmendment(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0       		1				2
+	// "orderId0", "proposalId", "reason"
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	if len(args[2]) <= 0 {
+		return shim.Error("3rd argument must be a non-empty string")
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	proposal, err := pendingProposal(stub, args[0], args[1], txTime)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	caller, err := getCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if caller.UserId != proposal.ProposedBy && !containsString(proposal.RequiredApprovals, caller.UserId) {
+		return shim.Error("User " + caller.UserId + " is not asked to approve proposal " + proposal.ProposalId)
+	}
+	proposal.Status = "REJECTED"
+	proposal.RejectedBy = caller.UserId
+	proposal.RejectReason = args[2]
+	proposal.ResolvedAt = txTime.UTC().Format(time.RFC3339)
+	err = putAmendmentProposal(stub, proposal)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ============================================================
+// queryAmendmentProposals - list the proposals of an order, oldest first
+// ============================================================
+func (t *SimpleChaincode) queryAmendmentProposals(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0       		1		2
+	// "orderId0", ["10", "bookmark"]
+	pageSize, bookmark, err := parsePaginationArgs(args, 1)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	order, err := getOrder(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	caller, err := getCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !canReadOrder(caller, order) {
+		return shim.Error("User " + caller.UserId + " cannot read order " + order.OrderId)
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(amendmentProposalIndex, []string{order.OrderId})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+	var proposals []AmendmentProposal
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		var proposal AmendmentProposal
+		err = json.Unmarshal(queryResponse.Value, &proposal)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		proposal.Status = proposalStatus(&proposal, txTime)
+		proposals = append(proposals, proposal)
+	}
+	sort.SliceStable(proposals, func(i, j int) bool {
+		return proposals[i].ProposedAt < proposals[j].ProposedAt
+	})
+	var records []QueryRecord
+	for _, proposal := range proposals {
+		proposalAsBytes, err := json.Marshal(proposal)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		records = append(records, QueryRecord{proposal.ProposalId, proposalAsBytes})
+	}
+	page, err := pageOfRecords(records, pageSize, bookmark)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	pageAsBytes, err := json.Marshal(page)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(pageAsBytes)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUpdateOrder(t *testing.T) {
+	s := newFixture(t)
+	createOrder(t, s, "order1")
+	s.caller = "owner"
+	mustInvoke(t, s, "updateOrder", "order1", `{"toAddress":"北京市朝阳区","weightTon":22,"transFee":5000}`, "地址笔误")
+
+	order := readOrder(t, s, "order1")
+	if order.ToAddress != "北京市朝阳区" || order.WeightTon != 22 || order.TransFee != 5000 || order.FromAddress != "上海" {
+		t.Errorf("unexpected updated order %+v", order)
+	}
+	var account Account
+	unmarshalPayload(t, mustInvoke(t, s, "readAccount", "owner"), &account)
+	if account.Balance != 95000 {
+		t.Errorf("the raised fee should be locked from the goods owner, balance %v", account.Balance)
+	}
+	mustInvoke(t, s, "updateOrder", "order1", `{"transFee":3000}`, "议价")
+	unmarshalPayload(t, mustInvoke(t, s, "readAccount", "owner"), &account)
+	if account.Balance != 97000 {
+		t.Errorf("the lowered fee should be given back to the goods owner, balance %v", account.Balance)
+	}
+
+	var amendments []struct {
+		Key    string
+		Record OrderAmendment
+	}
+	unmarshalPage(t, mustInvoke(t, s, "queryOrderAmendments", "order1"), &amendments)
+	if len(amendments) != 2 {
+		t.Fatalf("expected 2 amendments, got %+v", amendments)
+	}
+	first := amendments[0].Record
+	if first.AmendedBy != "owner" || first.Reason != "地址笔误" || first.OrderState != "WAIT_DRIVER_ACCEPT" ||
+		first.Timestamp != "2019-04-01T08:07:00Z" || len(first.Changes) != 3 {
+		t.Fatalf("unexpected amendment %+v", first)
+	}
+	if change := first.Changes[0]; change.Field != "toAddress" || change.From != "北京" || change.To != "北京市朝阳区" {
+		t.Errorf("unexpected change %+v", change)
+	}
+	if change := first.Changes[2]; change.Field != "transFee" || change.From != 4000.0 || change.To != 5000.0 {
+		t.Errorf("unexpected change %+v", change)
+	}
+	if amendments[1].Record.Changes[0].To != 3000.0 {
+		t.Errorf("unexpected second amendment %+v", amendments[1].Record)
+	}
+
+	s.caller = "admin"
+	deliverOrder(t, s, "order1")
+	signOrder(t, s, "order1")
+	var escrow Escrow
+	escrowKey, _ := s.CreateCompositeKey("escrow", []string{"order1"})
+	escrowAsBytes, _ := s.GetState(escrowKey)
+	unmarshalPayload(t, escrowAsBytes, &escrow)
+	if escrow.Amount != 3000 || escrow.BrokerAmount+escrow.DriverAmount != 3000 || escrow.EscrowState != "RELEASED" {
+		t.Errorf("unexpected escrow %+v", escrow)
+	}
+}
+
+func TestUpdateOrderRules(t *testing.T) {
+	s := newFixture(t)
+	createOrder(t, s, "order1")
+	s.caller = "broker"
+	expectError(t, s, "User broker cannot change toAddress of order order1", "updateOrder", "order1", `{"toAddress":"天津"}`, "x")
+	s.caller = "owner"
+	expectError(t, s, "unknown field \"brokerId\"", "updateOrder", "order1", `{"brokerId":"broker2"}`, "x")
+	expectError(t, s, "weightTon must be positive", "updateOrder", "order1", `{"weightTon":0}`, "x")
+	expectError(t, s, "The patch does not change order order1", "updateOrder", "order1", `{"toAddress":"北京"}`, "x")
+	expectError(t, s, "3rd argument must be a non-empty string", "updateOrder", "order1", `{"toAddress":"天津"}`, "")
+	expectError(t, s, "Insufficient", "updateOrder", "order1", `{"transFee":200000}`, "x")
+
+	s.caller = "admin"
+	advanceOrder(t, s, "order1", "DRIVER_ACCEPT_WAIT_ROAD", "DRIVER_ON_ROAD")
+	s.caller = "owner"
+	expectError(t, s, "transFee cannot be changed when the order is DRIVER_ON_ROAD", "updateOrder", "order1", `{"transFee":4500}`, "x")
+	expectError(t, s, "needs the approval of broker, driver", "updateOrder", "order1", `{"toAddress":"天津"}`, "改送天津")
+	s.caller = "admin"
+	mustInvoke(t, s, "updateOrder", "order1", `{"toAddress":"天津"}`, "改送天津")
+	mustInvoke(t, s, "initOrder", "order2", "上海", "北京", "煤炭", "20", "4000", "WAIT_DRIVER_ACCEPT", "owner", "broker", "nobody")
+	s.caller = "driver"
+	expectError(t, s, "User driver cannot read order order2", "queryOrderAmendments", "order2")
+}
+
+func proposeAmendment(t *testing.T, s *testStub, args ...string) AmendmentProposal {
+	t.Helper()
+	var proposal AmendmentProposal
+	unmarshalPayload(t, mustInvoke(t, s, "proposeAmendment", args...), &proposal)
+	return proposal
+}
+
+func readProposals(t *testing.T, s *testStub, orderId string) []AmendmentProposal {
+	t.Helper()
+	var records []struct {
+		Key    string
+		Record AmendmentProposal
+	}
+	unmarshalPage(t, mustInvoke(t, s, "queryAmendmentProposals", orderId), &records)
+	proposals := make([]AmendmentProposal, len(records))
+	for i, record := range records {
+		proposals[i] = record.Record
+	}
+	return proposals
+}
+
+func TestAmendmentsOfAcceptedOrdersNeedApproval(t *testing.T) {
+	s := newFixture(t)
+	createOrder(t, s, "order1")
+	advanceOrder(t, s, "order1", "DRIVER_ACCEPT_WAIT_ROAD")
+	s.caller = "owner"
+	expectError(t, s, "the change needs the approval of broker, propose it with proposeAmendment",
+		"updateOrder", "order1", `{"transFee":4500}`, "油价上涨")
+	expectError(t, s, "the change needs the approval of broker, driver",
+		"updateOrder", "order1", `{"toAddress":"天津"}`, "改送天津")
+	mustInvoke(t, s, "updateOrder", "order1", `{"content":"焦炭"}`, "货物笔误")
+	expectError(t, s, "The change needs no approval", "proposeAmendment", "order1", `{"weightTon":21}`, "x", "2019-04-02T08:00:00Z")
+
+	s.caller = "broker"
+	proposal := proposeAmendment(t, s, "order1", `{"transFee":4500}`, "油价上涨", "2019-04-02T08:00:00+08:00")
+	if proposal.Status != "PENDING" || len(proposal.RequiredApprovals) != 2 || len(proposal.Approvals) != 1 ||
+		proposal.Approvals[0].UserId != "broker" || proposal.ExpiresAt != "2019-04-02T00:00:00Z" {
+		t.Fatalf("unexpected proposal %+v", proposal)
+	}
+	expectError(t, s, "has already approved", "approveAmendment", "order1", proposal.ProposalId)
+	s.caller = "driver"
+	expectError(t, s, "User driver is not asked to approve", "approveAmendment", "order1", proposal.ProposalId)
+	s.caller = "owner"
+	mustInvoke(t, s, "approveAmendment", "order1", proposal.ProposalId)
+
+	if order := readOrder(t, s, "order1"); order.TransFee != 4500 {
+		t.Errorf("the approved fee should apply, got %v", order.TransFee)
+	}
+	proposals := readProposals(t, s, "order1")
+	if len(proposals) != 1 || proposals[0].Status != "APPLIED" || len(proposals[0].Approvals) != 2 {
+		t.Errorf("unexpected proposals %+v", proposals)
+	}
+	expectError(t, s, "is APPLIED", "approveAmendment", "order1", proposal.ProposalId)
+	var amendments []struct {
+		Key    string
+		Record OrderAmendment
+	}
+	unmarshalPage(t, mustInvoke(t, s, "queryOrderAmendments", "order1"), &amendments)
+	last := amendments[len(amendments)-1].Record
+	if last.ProposalId != proposal.ProposalId || last.AmendedBy != "broker" || len(last.ApprovedBy) != 2 {
+		t.Errorf("unexpected amendment %+v", last)
+	}
+}
+
+func TestRouteAmendmentRejectAndExpiry(t *testing.T) {
+	s := newFixture(t)
+	createOrder(t, s, "order1")
+	advanceOrder(t, s, "order1", "DRIVER_ACCEPT_WAIT_ROAD", "DRIVER_ON_ROAD")
+	s.caller = "owner"
+	expectError(t, s, "transFee cannot be changed when the order is DRIVER_ON_ROAD",
+		"proposeAmendment", "order1", `{"transFee":4500}`, "x", "2019-04-02T08:00:00Z")
+	expectError(t, s, "The proposal must expire after", "proposeAmendment", "order1", `{"toAddress":"天津"}`, "x", "2019-04-01T08:00:00Z")
+
+	rejected := proposeAmendment(t, s, "order1", `{"toAddress":"天津"}`, "改送天津", "2019-04-02T08:00:00Z")
+	s.caller = "broker"
+	mustInvoke(t, s, "approveAmendment", "order1", rejected.ProposalId)
+	s.caller = "driver"
+	mustInvoke(t, s, "rejectAmendment", "order1", rejected.ProposalId, "路线太远")
+	if order := readOrder(t, s, "order1"); order.ToAddress != "北京" {
+		t.Errorf("a rejected proposal must not apply, got %s", order.ToAddress)
+	}
+
+	s.caller = "owner"
+	expiring := proposeAmendment(t, s, "order1", `{"toAddress":"天津"}`, "改送天津", "2019-04-01T08:20:00Z")
+	s.caller = "broker"
+	mustInvoke(t, s, "approveAmendment", "order1", expiring.ProposalId)
+	s.now = s.now.Add(time.Hour)
+	s.caller = "driver"
+	expectError(t, s, "expired at 2019-04-01T08:20:00Z", "approveAmendment", "order1", expiring.ProposalId)
+
+	proposals := readProposals(t, s, "order1")
+	if len(proposals) != 2 || proposals[0].Status != "REJECTED" || proposals[0].RejectedBy != "driver" ||
+		proposals[0].RejectReason != "路线太远" || proposals[1].Status != "EXPIRED" {
+		t.Errorf("unexpected proposals %+v", proposals)
+	}
+}
+
+func TestStaleAmendmentProposal(t *testing.T) {
+	s := newFixture(t)
+	createOrder(t, s, "order1")
+	advanceOrder(t, s, "order1", "DRIVER_ACCEPT_WAIT_ROAD")
+	s.caller = "owner"
+	proposal := proposeAmendment(t, s, "order1", `{"toAddress":"天津","transFee":4500}`, "改送天津", "2019-04-02T08:00:00Z")
+	s.caller = "admin"
+	mustInvoke(t, s, "updateOrder", "order1", `{"transFee":4200}`, "管理员调整")
+	s.caller = "broker"
+	mustInvoke(t, s, "approveAmendment", "order1", proposal.ProposalId)
+	s.caller = "driver"
+	expectError(t, s, "changed since proposal", "approveAmendment", "order1", proposal.ProposalId)
+}
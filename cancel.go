@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ====CANCELLATION (CLI) ==================================================================
+// 取消运单 peer chaincode invoke -C myc1 -n orders -c '{"Args":["cancelOrder","orderId0","goodsOwnerId","货物未备齐"]}'
+//
+// Any party of an order may cancel it before it arrives. The penalty is the configured
+// cancelPenaltyRates share of the fee for the state the order is in. When the goods owner
+// cancels, the penalty is kept from the refund and paid to broker and driver; when broker or
+// driver cancel, the fee is refunded in full and the penalty is charged to their account.
+// =========================================================================================
+
+// ============================================================
+// cancelOrder - cancel an order before delivery, applying the penalty schedule
+// ============================================================
+func (t *SimpleChaincode) cancelOrder(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0       		1       		2
+	// "orderId0", "goodsOwnerId", "reason"
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	if len(args[2]) <= 0 {
+		return shim.Error("3rd argument must be a non-empty string")
+	}
+	fmt.Println("- start cancelOrder")
+	orderId := args[0]
+	cancelledBy, err := actingUserId(stub, args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	order, err := getOrder(stub, orderId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if cancelledBy != order.GoodsOwnerId && cancelledBy != order.BrokerId && cancelledBy != order.DriverId {
+		return shim.Error("Only a party of order " + orderId + " can cancel it")
+	}
+	config, err := getConfig(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	state := strings.ToUpper(order.OrderState)
+	penaltyRate, cancellable := config.CancelPenaltyRates[state]
+	if !order.Open || !cancellable {
+		return shim.Error("Order " + orderId + " cannot be cancelled in state " + order.OrderState)
+	}
+	penalty := order.TransFee * penaltyRate
+
+	// the refund and the penalty may both credit the goods owner, each account is written once
+	var changes accountChanges
+	if cancelledBy == order.GoodsOwnerId {
+		err = settleEscrow(stub, orderId, order.TransFee-penalty, &changes)
+	} else {
+		err = refundEscrow(stub, orderId, &changes)
+		if err == nil && penalty > 0 {
+			changes.add(cancelledBy, -penalty)
+			changes.add(order.GoodsOwnerId, penalty)
+			err = writeLedgerEntry(stub, "PENALTY", orderId, cancelledBy, order.GoodsOwnerId, penalty, "")
+		}
+	}
+	if err == nil {
+		err = changes.apply(stub)
+	}
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	order.Cancellation = &Cancellation{cancelledBy, args[2], order.OrderState, penaltyRate, penalty, txTime.Format(time.RFC3339)}
+	order.OrderState = "CANCELLED"
+	order.Open = false
+	res := writeToRecordsLedger(stub, *order, "CANCELLED")
+	if res.Status != shim.OK {
+		return res
+	}
+	fmt.Println("- end cancelOrder")
+	return shim.Success(nil)
+}
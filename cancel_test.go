@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCancelBeforeAcceptanceIsFree(t *testing.T) {
+	s := newFixture(t)
+	createOrder(t, s, "order1")
+	mustInvoke(t, s, "cancelOrder", "order1", "owner", "货物未备齐")
+
+	order := readOrder(t, s, "order1")
+	if order.OrderState != "CANCELLED" || order.Open || order.Cancellation == nil {
+		t.Fatalf("unexpected order %+v", order)
+	}
+	if order.Cancellation.CancelledBy != "owner" || order.Cancellation.StateAtCancel != "WAIT_DRIVER_ACCEPT" || order.Cancellation.PenaltyAmount != 0 {
+		t.Errorf("unexpected cancellation %+v", order.Cancellation)
+	}
+	if balance := balanceOf(t, s, "owner"); balance != 100000 {
+		t.Errorf("fee should be refunded in full, balance %v", balance)
+	}
+	expectError(t, s, "cannot be cancelled in state CANCELLED", "cancelOrder", "order1", "owner", "again")
+}
+
+func TestCancelOnRoadByOwner(t *testing.T) {
+	s := newFixture(t)
+	createOrder(t, s, "order1")
+	advanceOrder(t, s, "order1", "DRIVER_ACCEPT_WAIT_ROAD", "DRIVER_ON_ROAD")
+	mustInvoke(t, s, "cancelOrder", "order1", "owner", "改用铁路")
+
+	if balance := balanceOf(t, s, "owner"); balance != 98800 {
+		t.Errorf("owner should lose the 30%% penalty, balance %v", balance)
+	}
+	if balance := balanceOf(t, s, "driver"); balance != 1080 {
+		t.Errorf("driver should get 90%% of the penalty, got %v", balance)
+	}
+
+	var detail AutoGenerated
+	unmarshalPayload(t, mustInvoke(t, s, "queryOrderDetail", "order1"), &detail)
+	if detail.Order.Cancellation == nil || detail.Order.Cancellation.PenaltyAmount != 1200 || detail.Escrow.EscrowState != "SETTLED" {
+		t.Errorf("unexpected detail %+v %+v", detail.Order.Cancellation, detail.Escrow)
+	}
+}
+
+func TestCancelByDriverChargesDriver(t *testing.T) {
+	s := newFixture(t)
+	createOrder(t, s, "order1")
+	advanceOrder(t, s, "order1", "DRIVER_ACCEPT_WAIT_ROAD")
+	expectError(t, s, "Insufficient balance", "cancelOrder", "order1", "driver", "车辆故障")
+
+	mustInvoke(t, s, "deposit", "driver", "1000")
+	mustInvoke(t, s, "cancelOrder", "order1", "driver", "车辆故障")
+	if balance := balanceOf(t, s, "driver"); balance != 600 {
+		t.Errorf("driver should pay the 10%% penalty, balance %v", balance)
+	}
+	if balance := balanceOf(t, s, "owner"); balance != 100400 {
+		t.Errorf("owner should be refunded and compensated, balance %v", balance)
+	}
+}
+
+func TestCancelOrderRules(t *testing.T) {
+	s := newFixture(t)
+	createOrder(t, s, "order1")
+	expectError(t, s, "Only a party of order order1", "cancelOrder", "order1", "stranger", "x")
+	deliverOrder(t, s, "order1")
+	expectError(t, s, "cannot be cancelled in state ARRIVED_WAIT_SIGN", "cancelOrder", "order1", "owner", "x")
+	expectError(t, s, "Order does not exist", "cancelOrder", "missing", "owner", "x")
+	expectError(t, s, "3rd argument must be a non-empty string", "cancelOrder", "order1", "owner", "")
+	expectError(t, s, "Incorrect number of arguments", "cancelOrder", "order1")
+}
+
+func TestCancelByBrokerRefundsAndCompensatesOwnerOnce(t *testing.T) {
+	s := newFixture(t)
+	createOrder(t, s, "order1")
+	advanceOrder(t, s, "order1", "DRIVER_ACCEPT_WAIT_ROAD")
+	mustInvoke(t, s, "deposit", "broker", "1000")
+	mustInvoke(t, s, "cancelOrder", "order1", "broker", "找到更近的车")
+
+	// the refund and the penalty are both credited to the owner by the one transaction
+	for accountId, expected := range map[string]float64{"owner": 100400, "broker": 600, "driver": 0} {
+		if balance := balanceOf(t, s, accountId); balance != expected {
+			t.Errorf("expected %s to hold %v, got %v", accountId, expected, balance)
+		}
+	}
+	var entries []struct {
+		Key    string
+		Record LedgerEntry
+	}
+	unmarshalPage(t, mustInvoke(t, s, "queryLedgerEntries", "owner"), &entries)
+	var penalties float64
+	for _, entry := range entries {
+		if entry.Record.EntryType == "PENALTY" {
+			penalties += entry.Record.Amount
+		}
+	}
+	if penalties != 400 {
+		t.Errorf("expected a penalty of 400 in the owner's ledger, got %v in %+v", penalties, entries)
+	}
+}
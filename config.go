@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ====CONFIG (CLI) ========================================================================
+// 设置佣金比例 peer chaincode invoke -C myc1 -n orders -c '{"Args":["setConfig","brokerCommissionRate","0.1"]}'
+// 设置磅差容忍度 peer chaincode invoke -C myc1 -n orders -c '{"Args":["setConfig","weightTolerancePercent","0.5"]}'
+// 设置取消违约金 peer chaincode invoke -C myc1 -n orders -c '{"Args":["setConfig","cancelPenaltyRates","{\"WAIT_DRIVER_ACCEPT\":0,\"DRIVER_ACCEPT_WAIT_ROAD\":0.1,\"DRIVER_ON_ROAD\":0.3}"]}'
+// 查询配置 peer chaincode query -C myc1 -n orders -c '{"Args":["readConfig"]}'
+// =========================================================================================
+
+const configKey = "config"
+
+// defaultBrokerCommissionRate is the share of the fee released to the broker when no
+// rate has been configured; the rest goes to the driver.
+const defaultBrokerCommissionRate = 0.1
+
+// defaultWeightTolerancePercent is how far the unloading weight may differ from the
+// loading weight before the order is flagged.
+const defaultWeightTolerancePercent = 0.5
+
+// defaultCancelPenaltyRates is the share of the fee charged for cancelling an order in each
+// state: free before a driver accepts, a fee once the driver is on the road.
+func defaultCancelPenaltyRates() map[string]float64 {
+	return map[string]float64{
+		"WAIT_DRIVER_ACCEPT":      0,
+		"DRIVER_ACCEPT_WAIT_ROAD": 0.1,
+		"DRIVER_ON_ROAD":          0.3,
+	}
+}
+
+// getConfig returns the chaincode configuration, falling back to defaults when unset
+func getConfig(stub shim.ChaincodeStubInterface) (Config, error) {
+	config := Config{ObjectType: "config", BrokerCommissionRate: defaultBrokerCommissionRate,
+		WeightTolerancePercent: defaultWeightTolerancePercent, CancelPenaltyRates: defaultCancelPenaltyRates()}
+	configAsBytes, err := stub.GetState(configKey)
+	if err != nil {
+		return config, err
+	} else if configAsBytes == nil {
+		return config, nil
+	}
+	err = json.Unmarshal(configAsBytes, &config)
+	return config, err
+}
+
+func putConfig(stub shim.ChaincodeStubInterface, config Config) error {
+	configAsBytes, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(configKey, configAsBytes)
+}
+
+// ============================================================
+// setConfig - change a single chaincode setting
+// ============================================================
+func (t *SimpleChaincode) setConfig(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0       				1
+	// "brokerCommissionRate", "0.1"
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	err := requireAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	config, err := getConfig(stub)
+	if err != nil {
+		return shim.Error("Failed to get config: " + err.Error())
+	}
+
+	switch args[0] {
+	case "brokerCommissionRate":
+		rate, err := strconv.ParseFloat(args[1], 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return shim.Error("brokerCommissionRate must be a number between 0 and 1")
+		}
+		config.BrokerCommissionRate = rate
+	case "weightTolerancePercent":
+		tolerance, err := strconv.ParseFloat(args[1], 64)
+		if err != nil || tolerance < 0 {
+			return shim.Error("weightTolerancePercent must be a non-negative number")
+		}
+		config.WeightTolerancePercent = tolerance
+	case "cancelPenaltyRates":
+		var rates map[string]float64
+		err := json.Unmarshal([]byte(args[1]), &rates)
+		if err != nil {
+			return shim.Error("cancelPenaltyRates must be a JSON object of state to rate: " + err.Error())
+		}
+		for state, rate := range rates {
+			stage, known := orderStage[state]
+			if !known || stage > orderStage["DRIVER_ON_ROAD"] {
+				return shim.Error("Orders cannot be cancelled in state " + state)
+			}
+			if rate < 0 || rate > 1 {
+				return shim.Error("Cancel penalty rate of " + state + " must be between 0 and 1")
+			}
+		}
+		config.CancelPenaltyRates = rates
+	default:
+		return shim.Error("Unknown config setting: " + args[0])
+	}
+
+	err = putConfig(stub, config)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ============================================================
+// readConfig - read the chaincode configuration
+// ============================================================
+func (t *SimpleChaincode) readConfig(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	config, err := getConfig(stub)
+	if err != nil {
+		return shim.Error("Failed to get config: " + err.Error())
+	}
+	configAsBytes, err := json.Marshal(config)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(configAsBytes)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestReadConfigDefaults(t *testing.T) {
+	s := newTestStub()
+	var config Config
+	unmarshalPayload(t, mustInvoke(t, s, "readConfig"), &config)
+	if config.BrokerCommissionRate != defaultBrokerCommissionRate || config.WeightTolerancePercent != defaultWeightTolerancePercent {
+		t.Errorf("unexpected defaults %+v", config)
+	}
+	if config.CancelPenaltyRates["DRIVER_ON_ROAD"] != 0.3 {
+		t.Errorf("unexpected penalty rates %+v", config.CancelPenaltyRates)
+	}
+}
+
+func TestSetConfig(t *testing.T) {
+	s := newFixture(t)
+	mustInvoke(t, s, "setConfig", "brokerCommissionRate", "0.2")
+	mustInvoke(t, s, "setConfig", "weightTolerancePercent", "1")
+	mustInvoke(t, s, "setConfig", "cancelPenaltyRates", `{"WAIT_DRIVER_ACCEPT":0,"DRIVER_ON_ROAD":0.5}`)
+
+	var config Config
+	unmarshalPayload(t, mustInvoke(t, s, "readConfig"), &config)
+	if config.BrokerCommissionRate != 0.2 || config.WeightTolerancePercent != 1 || config.CancelPenaltyRates["DRIVER_ON_ROAD"] != 0.5 {
+		t.Errorf("unexpected config %+v", config)
+	}
+
+	expectError(t, s, "between 0 and 1", "setConfig", "brokerCommissionRate", "1.5")
+	expectError(t, s, "non-negative number", "setConfig", "weightTolerancePercent", "-1")
+	expectError(t, s, "cannot be cancelled in state SIGNED", "setConfig", "cancelPenaltyRates", `{"SIGNED":0.1}`)
+	expectError(t, s, "Unknown config setting", "setConfig", "color", "blue")
+	expectError(t, s, "Incorrect number of arguments", "setConfig", "brokerCommissionRate")
+	s.caller = "broker"
+	expectError(t, s, "Only an admin can call setConfig, broker is broker", "setConfig", "brokerCommissionRate", "0.5")
+}
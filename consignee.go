@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ====CONSIGNEES (CLI) ====================================================================
+// 注册收货人 peer chaincode invoke -C myc1 -n orders -c '{"Args":["initUser","consigneeId","北京仓库","consignee","13800000006","true"]}'
+// 指定收货人下单 peer chaincode invoke -C myc1 -n orders -c '{"Args":["initOrder","orderId0","上海","北京","煤炭","20","4000","WAIT_DRIVER_ACCEPT","goodsOwnerId","brokerId0","driverId","{\"consigneeId\":\"consigneeId\"}"]}'
+// 登记联系方式 peer chaincode invoke -C myc1 -n orders -c '{"Args":["setConsigneeContact","consigneeId"]}' --transient "{\"contact\":\"$(echo -n '{"contactName":"李经理","telephone":"13800000007","address":"北京市朝阳区"}' | base64 -w0)\"}"
+// 查询联系方式 peer chaincode query -C myc1 -n orders -c '{"Args":["readConsigneeContact","orderId0"]}'
+// 添加代签人 peer chaincode invoke -C myc1 -n orders -c '{"Args":["addConsigneeDelegate","consigneeId","clerkId"]}'
+// 移除代签人 peer chaincode invoke -C myc1 -n orders -c '{"Args":["removeConsigneeDelegate","consigneeId","clerkId"]}'
+// 查询代签人 peer chaincode query -C myc1 -n orders -c '{"Args":["queryConsigneeDelegates","consigneeId"]}'
+// 收货运单 peer chaincode query -C myc1 -n orders -c '{"Args":["queryInboundOrders","consigneeId","10",""]}'
+//
+// The consignee receives the goods at toAddress and signs for them. An order names one with
+// the consigneeId option, a registered user of role consignee; orders without one are
+// received by their goods owner. The consignee is a party of the order like the others: it
+// reads the order, is found by searches and approves changes of the destination.
+//
+// A consignee's contact details are kept in the consigneeContacts private collection
+// (consigneeContact: consigneeId), passed in the transient field "contact" so that they stay
+// out of the transaction; collections_config.json defines the collection for instantiation.
+// The consignee, their delegates and admins set them; the parties of an order read those of
+// its consignee. A consignee names delegates, warehouse staff for example, who may sign
+// deliveries and list inbound orders on their behalf.
+// =========================================================================================
+
+const (
+	consigneeDelegateIndex     = "consignee~delegate"
+	consigneeContactCollection = "consigneeContacts"
+)
+
+// orderConsigneeId returns the user who receives the goods of an order, the goods owner
+// unless the order names a consignee
+func orderConsigneeId(order *Order) string {
+	if order.ConsigneeId != "" {
+		return order.ConsigneeId
+	}
+	return order.GoodsOwnerId
+}
+
+// checkOrderConsignee refuses an order whose consignee is not a registered consignee
+func checkOrderConsignee(stub shim.ChaincodeStubInterface, order *Order) error {
+	if order.ConsigneeId == "" {
+		return nil
+	}
+	consignee, err := getUser(stub, order.ConsigneeId)
+	if err != nil {
+		return err
+	}
+	if consignee.Role != "consignee" {
+		return fmt.Errorf("User %s is %s and cannot be the consignee of an order", consignee.UserId, consignee.Role)
+	}
+	return nil
+}
+
+// isConsigneeDelegate reports whether a user may act for a consignee
+func isConsigneeDelegate(stub shim.ChaincodeStubInterface, consigneeId string, userId string) (bool, error) {
+	key, err := stub.CreateCompositeKey(consigneeDelegateIndex, []string{consigneeId, userId})
+	if err != nil {
+		return false, err
+	}
+	delegateAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return false, err
+	}
+	return delegateAsBytes != nil, nil
+}
+
+// canActForConsignee lets the consignee, their delegates and admins act for a consignee
+func canActForConsignee(stub shim.ChaincodeStubInterface, caller *User, consigneeId string) (bool, error) {
+	if caller.UserId == consigneeId || isAdmin(caller) {
+		return true, nil
+	}
+	return isConsigneeDelegate(stub, consigneeId, caller.UserId)
+}
+
+func consigneeContactKey(stub shim.ChaincodeStubInterface, consigneeId string) (string, error) {
+	return stub.CreateCompositeKey("consigneeContact", []string{consigneeId})
+}
+
+// changeConsigneeDelegate adds or removes a delegate of a consignee on behalf of the
+// consignee or an admin
+func changeConsigneeDelegate(stub shim.ChaincodeStubInterface, consigneeId string, delegateId string, present bool) error {
+	consigneeId, err := actingUserId(stub, consigneeId)
+	if err != nil {
+		return err
+	}
+	if delegateId == consigneeId {
+		return fmt.Errorf("User %s cannot delegate to themselves", consigneeId)
+	}
+	if present {
+		delegate, err := getUser(stub, delegateId)
+		if err != nil {
+			return err
+		}
+		if userStatus(delegate) != "ACTIVE" {
+			return fmt.Errorf("User %s is %s and cannot be a delegate", delegateId, userStatus(delegate))
+		}
+	} else {
+		delegated, err := isConsigneeDelegate(stub, consigneeId, delegateId)
+		if err != nil {
+			return err
+		} else if !delegated {
+			return fmt.Errorf("User %s is not a delegate of %s", delegateId, consigneeId)
+		}
+	}
+	return putIndexEntry(stub, consigneeDelegateIndex, []string{consigneeId, delegateId}, present)
+}
+
+// ============================================================
+// addConsigneeDelegate - let a user sign deliveries for a consignee
+// ============================================================
+func (t *SimpleChaincode) addConsigneeDelegate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0       		1
+	// "consigneeId", "clerkId"
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	err := changeConsigneeDelegate(stub, args[0], args[1], true)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ============================================================
+// removeConsigneeDelegate - stop a user from signing for a consignee
+// ============================================================
+func (t *SimpleChaincode) removeConsigneeDelegate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0       		1
+	// "consigneeId", "clerkId"
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	err := changeConsigneeDelegate(stub, args[0], args[1], false)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ============================================================
+// queryConsigneeDelegates - list the delegates of a consignee
+// ============================================================
+func (t *SimpleChaincode) queryConsigneeDelegates(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0
+	// "consigneeId"
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	caller, err := getCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	allowed, err := canActForConsignee(stub, caller, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if !allowed {
+		return shim.Error("User " + caller.UserId + " cannot read the delegates of " + args[0])
+	}
+	delegateIds, err := indexedIds(stub, consigneeDelegateIndex, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if delegateIds == nil {
+		delegateIds = []string{}
+	}
+	delegatesAsBytes, err := json.Marshal(delegateIds)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(delegatesAsBytes)
+}
+
+// ============================================================
+// setConsigneeContact - keep the contact details of a consignee in private data
+// ============================================================
+func (t *SimpleChaincode) setConsigneeContact(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0
+	// "consigneeId", transient "contact": {"contactName":"...","telephone":"...","address":"..."}
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	consigneeId := args[0]
+	caller, err := getCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	allowed, err := canActForConsignee(stub, caller, consigneeId)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if !allowed {
+		return shim.Error("User " + caller.UserId + " cannot set the contact of " + consigneeId)
+	}
+	consignee, err := getUser(stub, consigneeId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	transient, err := stub.GetTransient()
+	if err != nil {
+		return shim.Error("Failed to get transient data: " + err.Error())
+	}
+	contactAsBytes, ok := transient["contact"]
+	if !ok || len(contactAsBytes) == 0 {
+		return shim.Error("The contact must be passed in the transient field contact")
+	}
+	contact := &ConsigneeContact{}
+	decoder := json.NewDecoder(bytes.NewReader(contactAsBytes))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(contact)
+	if err != nil {
+		return shim.Error("The contact must be a JSON object: " + err.Error())
+	}
+	if contact.ContactName == "" || contact.Telephone == "" {
+		return shim.Error("The contact needs a contactName and a telephone")
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	contact.ObjectType = "consigneeContact"
+	contact.ConsigneeId = consignee.UserId
+	contact.UpdatedBy = caller.UserId
+	contact.UpdatedAt = txTime.Format(time.RFC3339)
+	key, err := consigneeContactKey(stub, consignee.UserId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	contactAsBytes, err = json.Marshal(contact)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutPrivateData(consigneeContactCollection, key, contactAsBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ============================================================
+// readConsigneeContact - read the contact details of an order's consignee
+// ============================================================
+func (t *SimpleChaincode) readConsigneeContact(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0
+	// "orderId0"
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	caller, err := getCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	order, err := getOrder(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	consigneeId := orderConsigneeId(order)
+	if !canReadOrder(caller, order) {
+		delegated, err := isConsigneeDelegate(stub, consigneeId, caller.UserId)
+		if err != nil {
+			return shim.Error(err.Error())
+		} else if !delegated {
+			return shim.Error("User " + caller.UserId + " cannot read order " + order.OrderId)
+		}
+	}
+	key, err := consigneeContactKey(stub, consigneeId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	contactAsBytes, err := stub.GetPrivateData(consigneeContactCollection, key)
+	if err != nil {
+		return shim.Error("Failed to get consignee contact: " + err.Error())
+	} else if contactAsBytes == nil {
+		return shim.Error("Consignee " + consigneeId + " has no contact")
+	}
+	return shim.Success(contactAsBytes)
+}
+
+// ============================================================
+// queryInboundOrders - list the orders delivered to a consignee
+// ============================================================
+func (t *SimpleChaincode) queryInboundOrders(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0       		1		2
+	// "consigneeId", ["10", "bookmark"]
+	pageSize, bookmark, err := parsePaginationArgs(args, 1)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	consigneeId := args[0]
+	caller, err := getCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	allowed, err := canActForConsignee(stub, caller, consigneeId)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if !allowed && caller.Role != "arbitrator" {
+		return shim.Error("User " + caller.UserId + " cannot read the inbound orders of " + consigneeId)
+	}
+	ids := map[string]bool{}
+	err = indexedOrderIds(stub, orderPartyIndex, [][]string{{consigneeId, "consignee"}}, ids)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	orderIds := make([]string, 0, len(ids))
+	for orderId := range ids {
+		orderIds = append(orderIds, orderId)
+	}
+	sort.Strings(orderIds)
+	var records []QueryRecord
+	for _, orderId := range orderIds {
+		orderAsBytes, err := stub.GetState(orderId)
+		if err != nil {
+			return shim.Error(err.Error())
+		} else if orderAsBytes != nil {
+			records = append(records, QueryRecord{orderId, orderAsBytes})
+		}
+	}
+	page, err := pageOfRecords(records, pageSize, bookmark)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	pageAsBytes, err := json.Marshal(page)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(pageAsBytes)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func newConsigneeFixture(t *testing.T) *testStub {
+	s := newFixture(t)
+	mustInvoke(t, s, "initUser", "consignee", "北京仓库", "consignee", "13800000006", "true")
+	// a consignee's telephone is optional, its contact is kept privately
+	mustInvoke(t, s, "initUser", "clerk", "仓管员", "consignee", "", "true")
+	mustInvoke(t, s, "initOrder", "order1", "上海", "北京", "煤炭", "20", "4000", "WAIT_DRIVER_ACCEPT", "owner", "broker", "driver",
+		`{"consigneeId":"consignee"}`)
+	return s
+}
+
+func TestConsigneeSignsInboundOrders(t *testing.T) {
+	s := newConsigneeFixture(t)
+	expectError(t, s, "User broker is broker and cannot be the consignee", "initOrder",
+		"order2", "上海", "北京", "煤炭", "20", "4000", "WAIT_DRIVER_ACCEPT", "owner", "broker", "driver", `{"consigneeId":"broker"}`)
+	expectError(t, s, "User does not exist: nobody", "initOrder",
+		"order2", "上海", "北京", "煤炭", "20", "4000", "WAIT_DRIVER_ACCEPT", "owner", "broker", "driver", `{"consigneeId":"nobody"}`)
+	createOrder(t, s, "order2")
+
+	s.caller = "consignee"
+	if order := readOrder(t, s, "order1"); order.ConsigneeId != "consignee" {
+		t.Errorf("unexpected consignee of %+v", order)
+	}
+	var inbound []struct {
+		Key    string
+		Record Order
+	}
+	unmarshalPage(t, mustInvoke(t, s, "queryInboundOrders", "consignee"), &inbound)
+	if len(inbound) != 1 || inbound[0].Key != "order1" {
+		t.Errorf("unexpected inbound orders %+v", inbound)
+	}
+
+	s.caller = "admin"
+	deliverOrder(t, s, "order1")
+	args := podArgs(t, s, "order1")
+	s.caller = "owner"
+	expectError(t, s, "Only consignee consignee or a delegate of theirs can sign order order1", "signDelivery", args...)
+	s.caller = "clerk"
+	expectError(t, s, "Only consignee consignee or a delegate", "signDelivery", args...)
+	expectError(t, s, "cannot read the inbound orders of consignee", "queryInboundOrders", "consignee")
+
+	s.caller = "consignee"
+	mustInvoke(t, s, "addConsigneeDelegate", "", "clerk")
+	var delegates []string
+	unmarshalPayload(t, mustInvoke(t, s, "queryConsigneeDelegates", "consignee"), &delegates)
+	if len(delegates) != 1 || delegates[0] != "clerk" {
+		t.Errorf("unexpected delegates %v", delegates)
+	}
+	s.caller = "clerk"
+	mustInvoke(t, s, "registerSigningKey", "", testPublicKeyPem(t, &testConsigneeKey.PublicKey))
+	unmarshalPage(t, mustInvoke(t, s, "queryInboundOrders", "consignee"), &inbound)
+	if len(inbound) != 1 {
+		t.Errorf("a delegate should list the inbound orders, got %+v", inbound)
+	}
+	mustInvoke(t, s, "signDelivery", args...)
+	expectError(t, s, "User clerk cannot read order order1", "readOrder", "order1")
+	s.caller = "consignee"
+	if pod := readOrder(t, s, "order1").ProofOfDelivery; pod == nil || pod.ConsigneeId != "consignee" || pod.SignedBy != "clerk" {
+		t.Errorf("unexpected proof of delivery %+v", pod)
+	}
+
+	mustInvoke(t, s, "removeConsigneeDelegate", "consignee", "clerk")
+	expectError(t, s, "User clerk is not a delegate of consignee", "removeConsigneeDelegate", "consignee", "clerk")
+	expectError(t, s, "cannot delegate to themselves", "addConsigneeDelegate", "", "consignee")
+	s.caller = "broker"
+	expectError(t, s, "User broker cannot act as consignee", "addConsigneeDelegate", "consignee", "broker")
+	expectError(t, s, "cannot read the delegates of consignee", "queryConsigneeDelegates", "consignee")
+}
+
+func TestConsigneeContact(t *testing.T) {
+	s := newConsigneeFixture(t)
+	s.caller = "consignee"
+	expectError(t, s, "The contact must be passed in the transient field contact", "setConsigneeContact", "consignee")
+	s.transient = map[string][]byte{"contact": []byte(`{"contactName":"李经理","phone":"13800000008"}`)}
+	expectError(t, s, "unknown field \"phone\"", "setConsigneeContact", "consignee")
+	s.transient = map[string][]byte{"contact": []byte(`{"contactName":"李经理"}`)}
+	expectError(t, s, "needs a contactName and a telephone", "setConsigneeContact", "consignee")
+	s.transient = map[string][]byte{"contact": []byte(`{"contactName":"李经理","telephone":"13800000008","address":"北京市朝阳区"}`)}
+	s.caller = "driver"
+	expectError(t, s, "User driver cannot set the contact of consignee", "setConsigneeContact", "consignee")
+	s.caller = "consignee"
+	mustInvoke(t, s, "setConsigneeContact", "consignee")
+	s.transient = nil
+
+	for key, value := range s.State {
+		if strings.Contains(string(value), "13800000008") {
+			t.Errorf("the contact must stay out of the world state, found under %q", key)
+		}
+	}
+	s.caller = "driver"
+	var contact ConsigneeContact
+	err := json.Unmarshal(mustInvoke(t, s, "readConsigneeContact", "order1"), &contact)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contact.ConsigneeId != "consignee" || contact.Telephone != "13800000008" || contact.UpdatedBy != "consignee" {
+		t.Errorf("unexpected contact %+v", contact)
+	}
+	s.caller = "admin"
+	createOrder(t, s, "order2")
+	s.caller = "driver"
+	expectError(t, s, "Consignee owner has no contact", "readConsigneeContact", "order2")
+	s.caller = "clerk"
+	expectError(t, s, "User clerk cannot read order order1", "readConsigneeContact", "order1")
+}
+
+func TestDestinationChangeNeedsConsigneeApproval(t *testing.T) {
+	s := newConsigneeFixture(t)
+	advanceOrder(t, s, "order1", "DRIVER_ACCEPT_WAIT_ROAD")
+	s.caller = "owner"
+	expectError(t, s, "the change needs the approval of broker, driver, consignee", "updateOrder", "order1", `{"toAddress":"天津"}`, "改送天津")
+	expectError(t, s, "the change needs the approval of broker, driver, propose", "updateOrder", "order1", `{"fromAddress":"南京"}`, "改从南京")
+}
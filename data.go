@@ -1,5 +1,7 @@
 package main
 
+import "encoding/json"
+
 type Order struct {
 	ObjectType 			string 	`json:"docType"`
 	OrderId      		string 	`json:"orderId"`
@@ -8,16 +10,214 @@ type Order struct {
 	Content      		string 	`json:"content"`
 	WeightTon      		float64 `json:"weightTon"`
 	TransFee      		float64 `json:"transFee"`
-	OrderState      	string 	`json:"orderState"` //in [WAIT_DRIVER_ACCEPT, DRIVER_ACCEPT_WAIT_ROAD, DRIVER_ON_ROAD, ARRIVED_WAIT_SIGN, SIGNED]
+	OrderState      	string 	`json:"orderState"` //in [WAIT_DRIVER_ACCEPT, DRIVER_ACCEPT_WAIT_ROAD, DRIVER_ON_ROAD, ARRIVED_WAIT_SIGN, DISPUTED, SIGNED, CANCELLED]
 	GoodsOwnerId    	string 	`json:"goodsOwnerId"`
 	BrokerId      		string 	`json:"brokerId"`
 	DriverId      		string 	`json:"driverId"`
+	ConsigneeId			string	`json:"consigneeId,omitempty"` //receives the goods and signs for them, the goods owner when empty
 	CreateDate      	string	`json:"createDate"`
+	CreatedAt			string	`json:"createdAt,omitempty"` //RFC3339 UTC, range-comparable
+	UpdatedAt			string	`json:"updatedAt,omitempty"` //RFC3339 UTC of the last write
+	StateEnteredAt		map[string]string	`json:"stateEnteredAt,omitempty"` //state to RFC3339 UTC time it was last entered
 	Open				bool	`json:"open"`
+	PromisedPickup		string	`json:"promisedPickup,omitempty"` //RFC3339
+	PromisedDelivery	string	`json:"promisedDelivery,omitempty"` //RFC3339
+	PickupStatus		string	`json:"pickupStatus,omitempty"` //in [PENDING, ON_TIME, LATE]
+	DeliveryStatus		string	`json:"deliveryStatus,omitempty"` //in [PENDING, ON_TIME, LATE]
+	SlaStatus			string	`json:"slaStatus,omitempty"` //in [ON_TIME, LATE]
+	Cancellation		*Cancellation	`json:"cancellation,omitempty"`
+	VehiclePlate		string	`json:"vehiclePlate,omitempty"`
+	ProofOfDelivery		*ProofOfDelivery	`json:"proofOfDelivery,omitempty"`
+	FeeCalculation		*FeeCalculation	`json:"feeCalculation,omitempty"` //set when a rate card applies to the order
+	GoodsCode			string	`json:"goodsCode,omitempty"` //catalog code, empty for free-text content
+	HazardClass			string	`json:"hazardClass,omitempty"` //of the goods when the order was created
+	SafetyDocument		*SafetyDocument	`json:"safetyDocument,omitempty"`
+	FromLocationId		string	`json:"fromLocationId,omitempty"` //fromAddress is then a snapshot of its address
+	ToLocationId		string	`json:"toLocationId,omitempty"` //toAddress is then a snapshot of its address
+	OriginRegion		string	`json:"originRegion,omitempty"` //region code of the from location
+	DestinationRegion	string	`json:"destinationRegion,omitempty"` //region code of the to location
   
 	ChangeStateHistory map[string]string
   }
 
+// OrderOptions holds the optional settings initOrder accepts as a JSON object after the
+// 10 positional arguments
+type OrderOptions struct {
+	PromisedPickup		string	`json:"promisedPickup"`
+	PromisedDelivery	string	`json:"promisedDelivery"`
+	VehiclePlate		string	`json:"vehiclePlate"` //the driver must be assigned to it and it must carry the weight
+	ConsigneeId			string	`json:"consigneeId"` //a registered consignee
+	FeeOverrideReason	string	`json:"feeOverrideReason"` //why transFee differs from the broker's rate card
+	GoodsCode			string	`json:"goodsCode"` //code of the goods catalog
+	FromLocationId		string	`json:"fromLocationId"` //registered location, fromAddress may then be empty
+	ToLocationId		string	`json:"toLocationId"` //registered location, toAddress may then be empty
+}
+
+// Location is an entry of the address book orders start and end at
+type Location struct {
+	ObjectType 			string  `json:"docType"`
+	LocationId			string	`json:"locationId"`
+	Name				string	`json:"name"`
+	Address				string	`json:"address"`
+	RegionCode			string	`json:"regionCode"` //administrative division code like 310115
+	Latitude			float64	`json:"latitude"`
+	Longitude			float64	`json:"longitude"`
+	OwnerId				string	`json:"ownerId"`
+	UpdatedBy			string	`json:"updatedBy"`
+	UpdatedAt			string	`json:"updatedAt"` //RFC3339 UTC
+}
+
+// Goods is an entry of the goods catalog
+type Goods struct {
+	ObjectType 			string  `json:"docType"`
+	GoodsCode			string	`json:"goodsCode"`
+	Name				string	`json:"name"`
+	Unit				string	`json:"unit"`
+	HazardClass			string	`json:"hazardClass,omitempty"` //dangerous goods class like 3 or 2.1, empty when not hazardous
+	UnNumber			string	`json:"unNumber,omitempty"`
+	UpdatedBy			string	`json:"updatedBy"`
+	UpdatedAt			string	`json:"updatedAt"` //RFC3339 UTC
+}
+
+// SafetyDocument is the hash of the safety data sheet a hazardous order travels with
+type SafetyDocument struct {
+	Sha256				string	`json:"sha256"` //hex
+	DataUrl				string	`json:"dataUrl"`
+	SubmittedBy			string	`json:"submittedBy"`
+	SubmittedAt			string	`json:"submittedAt"` //RFC3339 UTC
+}
+
+// DriverCredential is a driver's licence to carry dangerous goods. Its number is kept apart
+// as a DriverCredentialNo.
+type DriverCredential struct {
+	HazardClasses		[]string	`json:"hazardClasses,omitempty"` //classes covered, every class when empty
+	ValidTo				string		`json:"validTo"` //RFC3339 UTC, exclusive
+	RecordedBy			string		`json:"recordedBy"`
+	RecordedAt			string		`json:"recordedAt"` //RFC3339 UTC
+}
+
+// OrderPatch holds the fields updateOrder may change, nil when left as they are
+type OrderPatch struct {
+	FromAddress			*string		`json:"fromAddress"`
+	ToAddress			*string		`json:"toAddress"`
+	Content				*string		`json:"content"`
+	WeightTon			*float64	`json:"weightTon"`
+	TransFee			*float64	`json:"transFee"`
+	VehiclePlate		*string		`json:"vehiclePlate"`
+	FromLocationId		*string		`json:"fromLocationId"`
+	ToLocationId		*string		`json:"toLocationId"`
+}
+
+// OrderAmendment records one change of an order's fields
+type OrderAmendment struct {
+	ObjectType 			string  		`json:"docType"`
+	OrderId				string			`json:"orderId"`
+	TxId				string			`json:"txId"`
+	AmendedBy			string			`json:"amendedBy"`
+	Reason				string			`json:"reason"`
+	OrderState			string			`json:"orderState"` //state of the order when it was amended
+	Timestamp			string			`json:"timestamp"` //RFC3339 UTC
+	Changes				[]FieldChange	`json:"changes"`
+	ProposalId			string			`json:"proposalId,omitempty"` //proposal the amendment was approved through
+	ApprovedBy			[]string		`json:"approvedBy,omitempty"`
+}
+
+// AmendmentProposal is an amendment waiting for the approval of the parties it affects
+type AmendmentProposal struct {
+	ObjectType 			string  		`json:"docType"`
+	ProposalId			string			`json:"proposalId"` //txId of the proposal
+	OrderId				string			`json:"orderId"`
+	ProposedBy			string			`json:"proposedBy"`
+	Reason				string			`json:"reason"`
+	Patch				OrderPatch		`json:"patch"`
+	Changes				[]FieldChange	`json:"changes"` //as proposed, applying fails if the order changed since
+	RequiredApprovals	[]string		`json:"requiredApprovals"`
+	Approvals			[]Approval		`json:"approvals"`
+	Status				string			`json:"status"` //in [PENDING, APPLIED, REJECTED, EXPIRED]
+	ProposedAt			string			`json:"proposedAt"` //RFC3339 UTC
+	ExpiresAt			string			`json:"expiresAt"` //RFC3339 UTC
+	ResolvedAt			string			`json:"resolvedAt,omitempty"`
+	RejectedBy			string			`json:"rejectedBy,omitempty"`
+	RejectReason		string			`json:"rejectReason,omitempty"`
+}
+
+type Approval struct {
+	UserId				string			`json:"userId"`
+	Timestamp			string			`json:"timestamp"` //RFC3339 UTC
+}
+
+// ProofOfDelivery is the consignee's signature over the delivery, verified before SIGNED
+type ProofOfDelivery struct {
+	ConsigneeId			string	`json:"consigneeId"`
+	ReceivedWeightTon	float64	`json:"receivedWeightTon"`
+	SignedAt			string	`json:"signedAt"` //RFC3339 as signed
+	Algorithm			string	`json:"algorithm"` //in [ECDSA_P256, SM2]
+	PublicKey			string	`json:"publicKey"` //PEM of the key the signature was verified with
+	Signature			string	`json:"signature"` //base64 ASN.1 DER
+	SignedBy			string	`json:"signedBy"` //the consignee or a delegate of theirs
+}
+
+// RateCard is one version of a broker's prices, valid from validFrom until validTo
+type RateCard struct {
+	ObjectType 			string  `json:"docType"`
+	BrokerId			string	`json:"brokerId"`
+	Version				int		`json:"version"`
+	ValidFrom			string	`json:"validFrom"` //RFC3339 UTC
+	ValidTo				string	`json:"validTo,omitempty"` //RFC3339 UTC, exclusive, open-ended when empty
+	Rates				[]Rate	`json:"rates"`
+	CreatedBy			string	`json:"createdBy"`
+	CreatedAt			string	`json:"createdAt"` //RFC3339 UTC
+}
+
+// Rate prices a lane, a goods category and a weight bracket
+type Rate struct {
+	OriginRegion		string	`json:"originRegion"` //prefix of fromAddress
+	DestinationRegion	string	`json:"destinationRegion"` //prefix of toAddress
+	Category			string	`json:"category,omitempty"` //content of the order, any when empty
+	MinWeightTon		float64	`json:"minWeightTon"` //inclusive
+	MaxWeightTon		float64	`json:"maxWeightTon,omitempty"` //exclusive, unbounded when 0
+	PricePerTon			float64	`json:"pricePerTon"`
+	MinimumFee			float64	`json:"minimumFee,omitempty"`
+}
+
+// FeeCalculation records the rate an order's fee was calculated with
+type FeeCalculation struct {
+	BrokerId			string	`json:"brokerId"`
+	RateVersion			int		`json:"rateVersion"`
+	Rate				Rate	`json:"rate"`
+	CalculatedFee		float64	`json:"calculatedFee"`
+	OverrideReason		string	`json:"overrideReason,omitempty"` //set when transFee differs from calculatedFee
+}
+
+// ConsigneeContact is how to reach a consignee, kept in the consigneeContacts private collection
+type ConsigneeContact struct {
+	ObjectType 			string  `json:"docType"`
+	ConsigneeId			string	`json:"consigneeId"`
+	ContactName			string	`json:"contactName"`
+	Telephone			string	`json:"telephone"`
+	Address				string	`json:"address"`
+	UpdatedBy			string	`json:"updatedBy"`
+	UpdatedAt			string	`json:"updatedAt"` //RFC3339 UTC
+}
+
+// DriverCredentialNo is the number of a driver's dangerous goods credential, kept in private data
+type DriverCredentialNo struct {
+	ObjectType 			string  `json:"docType"`
+	DriverId			string	`json:"driverId"`
+	CredentialNo		string	`json:"credentialNo"`
+	RecordedBy			string	`json:"recordedBy"`
+	RecordedAt			string	`json:"recordedAt"` //RFC3339 UTC
+}
+
+type Cancellation struct {
+	CancelledBy			string	`json:"cancelledBy"`
+	Reason				string	`json:"reason"`
+	StateAtCancel		string	`json:"stateAtCancel"`
+	PenaltyRate			float64	`json:"penaltyRate"`
+	PenaltyAmount		float64	`json:"penaltyAmount"`
+	Timestamp			string	`json:"timestamp"`
+}
+
 type UpdatePositionHistory struct {
 	ObjectType 			string  `json:"docType"`
 	PositionId			string  `json:"positionId"`
@@ -50,6 +250,54 @@ type StringWithKey struct {
 	Record				StringHash   			`json:"Record"`
 }
 
+type PositionWithKey struct {
+	Key 				string  				`json:"Key"`
+	Record				UpdatePositionHistory	`json:"Record"`
+}
+
+// QueryRecord is one result of a range or rich query, the record left as stored
+type QueryRecord struct {
+	Key 				string  				`json:"Key"`
+	Record				json.RawMessage			`json:"Record"`
+}
+
+// PaginatedResponse is returned by every list and query function. Bookmark is passed back
+// to get the next page while HasMore is set.
+type PaginatedResponse struct {
+	Records				[]QueryRecord			`json:"records"`
+	RecordsCount		int32					`json:"recordsCount"`
+	Bookmark			string					`json:"bookmark"`
+	HasMore				bool					`json:"hasMore"`
+}
+
+// RecordFilter is the whitelist of named filters queryRecords accepts. DocType is
+// required; the party, state, date and content filters only apply to orders.
+type RecordFilter struct {
+	DocType				string					`json:"docType"`
+	OrderId				string					`json:"orderId"`
+	Party				string					`json:"party"`
+	PartyRole			string					`json:"partyRole"` //in [goodsOwner, broker, driver, consignee], any when empty
+	States				[]string				`json:"states"`
+	CreatedFrom			string					`json:"createdFrom"` //RFC3339, inclusive
+	CreatedTo			string					`json:"createdTo"` //RFC3339, exclusive
+	Content				string					`json:"content"`
+	OriginRegion		string					`json:"originRegion"` //region code of the from location
+	DestinationRegion	string					`json:"destinationRegion"` //region code of the to location
+	UpdatedFrom			string					`json:"updatedFrom"` //RFC3339, inclusive
+	UpdatedTo			string					`json:"updatedTo"` //RFC3339, exclusive
+	MinWeightTon		*float64				`json:"minWeightTon"` //inclusive
+	MaxWeightTon		*float64				`json:"maxWeightTon"` //inclusive
+	MinTransFee			*float64				`json:"minTransFee"` //inclusive
+	MaxTransFee			*float64				`json:"maxTransFee"` //inclusive
+	SortBy				string					`json:"sortBy"` //in [createdAt, updatedAt, weightTon, transFee], key order when empty
+	SortOrder			string					`json:"sortOrder"` //in [asc, desc], asc when empty
+}
+
+type OrderWithKey struct {
+	Key 				string  				`json:"Key"`
+	Record				Order   				`json:"Record"`
+}
+
 type FileWithKey struct {
 	Key 				string  				`json:"Key"`
 	Record				FileHash   			`json:"Record"`
@@ -59,9 +307,37 @@ type User struct {
 	ObjectType 			string  				`json:"docType"`
 	UserId				string					`json:"userId"`
 	UserName			string					`json:"userName"`
-	Role				string					`json:"role"`
+	Role				string					`json:"role"` //in [goodsOwner, broker, driver, consignee, admin, arbitrator]
 	Telephone			string					`json:"telephone"`
-	Valid				bool					`json:"valid"`
+	Valid				bool					`json:"valid"` //true when the status is ACTIVE
+	Status				string					`json:"status,omitempty"` //in [ACTIVE, SUSPENDED, DEACTIVATED]
+	StatusReason		string					`json:"statusReason,omitempty"`
+	StatusChangedBy		string					`json:"statusChangedBy,omitempty"`
+	StatusChangedAt		string					`json:"statusChangedAt,omitempty"` //RFC3339 UTC
+	MspId				string					`json:"mspId,omitempty"` //MSP of the bound certificate
+	CertId				string					`json:"certId,omitempty"` //subject and issuer of the bound certificate
+	CertSubject			string					`json:"certSubject,omitempty"`
+	OrgId				string					`json:"orgId,omitempty"`
+	SigningKey			string					`json:"signingKey,omitempty"` //PEM public key proofs of delivery are verified with
+	SigningAlgorithm	string					`json:"signingAlgorithm,omitempty"` //in [ECDSA_P256, SM2]
+	DangerousGoodsCredential	*DriverCredential	`json:"dangerousGoodsCredential,omitempty"`
+}
+
+type Organization struct {
+	ObjectType 			string  				`json:"docType"`
+	OrgId				string					`json:"orgId"`
+	OrgName				string					`json:"orgName"`
+	OrgType				string					`json:"orgType"` //in [carrier, broker, shipper]
+	CreatedAt			string					`json:"createdAt"` //RFC3339 UTC
+}
+
+type Vehicle struct {
+	ObjectType 			string  				`json:"docType"`
+	Plate				string					`json:"plate"`
+	CapacityTon			float64					`json:"capacityTon"`
+	VehicleType			string					`json:"vehicleType"`
+	OrgId				string					`json:"orgId"` //organization owning the vehicle
+	DriverIds			[]string				`json:"driverIds"` //drivers assigned to the vehicle
 }
 
 type UserGenerated struct {
@@ -76,4 +352,149 @@ type AutoGenerated struct {
 	String       		[]StringHash 			`json:"string"`
 	File       			[]FileHash 				`json:"file"`
 	Position       		[]UpdatePositionHistory	`json:"position"`
+	Escrow				*Escrow					`json:"escrow,omitempty"`
+	Dispute				*Dispute				`json:"dispute,omitempty"`
+	Weight				*WeightReconciliation	`json:"weight,omitempty"`
+	Ledger				[]LedgerEntry			`json:"ledger"`
+}
+
+type Account struct {
+	ObjectType 			string  				`json:"docType"`
+	AccountId			string					`json:"accountId"`
+	Balance				float64					`json:"balance"`
+}
+
+type Escrow struct {
+	ObjectType 			string 	`json:"docType"`
+	OrderId      		string 	`json:"orderId"`
+	GoodsOwnerId    	string 	`json:"goodsOwnerId"`
+	BrokerId      		string 	`json:"brokerId"`
+	DriverId      		string 	`json:"driverId"`
+	Amount				float64 `json:"amount"`
+	BrokerAmount		float64 `json:"brokerAmount"`
+	DriverAmount		float64 `json:"driverAmount"`
+	EscrowState			string 	`json:"escrowState"` //in [LOCKED, RELEASED, REFUNDED, SETTLED]
+}
+
+type LedgerEntry struct {
+	ObjectType 			string  `json:"docType"`
+	TxId				string  `json:"txId"`
+	OrderId      		string  `json:"orderId"`
+	EntryType			string  `json:"entryType"` //in [DEPOSIT, WITHDRAW, LOCK, RELEASE, REFUND, PENALTY]
+	FromAccount			string  `json:"fromAccount"`
+	ToAccount			string  `json:"toAccount"`
+	Amount				float64 `json:"amount"`
+	Timestamp			string  `json:"timestamp"`
+	Role				string  `json:"role,omitempty"` //in [goodsOwner, broker, driver] for the payouts of an escrow
+}
+
+// OrderStats sums the orders a party created on one UTC day. queryOrderStats adds up the
+// days of a window and fills in the averages.
+type OrderStats struct {
+	ObjectType 			string  			`json:"docType"`
+	PartyId				string				`json:"partyId"`
+	PartyRole			string				`json:"partyRole"` //in [goodsOwner, broker, driver, consignee]
+	Day					string				`json:"day,omitempty"` //2006-01-02, empty in a window summary
+	From				string				`json:"from,omitempty"` //first day of a window summary
+	To					string				`json:"to,omitempty"` //last day of a window summary
+	OrderCount			int					`json:"orderCount"`
+	StateCounts			map[string]int		`json:"stateCounts"`
+	WeightTon			float64				`json:"weightTon"`
+	TransFee			float64				`json:"transFee"`
+	DeliveredCount		int					`json:"deliveredCount"` //signed orders that went on the road
+	DeliverySeconds		float64				`json:"deliverySeconds"` //DRIVER_ON_ROAD to SIGNED, summed
+	SlaCount			int					`json:"slaCount"` //signed orders with promised times
+	OnTimeCount			int					`json:"onTimeCount"`
+	AverageDeliveryHours float64			`json:"averageDeliveryHours,omitempty"`
+	OnTimePercent		float64				`json:"onTimePercent,omitempty"`
+	PendingDeltas		int					`json:"pendingDeltas,omitempty"` //deltas in a window summary not compacted yet
+}
+
+// PartySummary holds the running counters of a party. The same shape is written as a
+// partySummaryDelta by each change and as the compacted partySummary the deltas fold into.
+type PartySummary struct {
+	ObjectType 			string  			`json:"docType"`
+	PartyId				string				`json:"partyId"`
+	OrdersOpen			int					`json:"ordersOpen"`
+	OrdersSigned		int					`json:"ordersSigned"`
+	TonsMoved			float64				`json:"tonsMoved"`
+	FeesEarned			float64				`json:"feesEarned"`
+	PendingDeltas		int					`json:"pendingDeltas,omitempty"` //deltas not compacted yet, when read
+	CompactedAt			string				`json:"compactedAt,omitempty"` //RFC3339 UTC
+}
+
+// OrderHistoryEntry is one version of an order as getHistoryForOrder returns it
+type OrderHistoryEntry struct {
+	TxId				string				`json:"txId"`
+	Timestamp			string				`json:"timestamp"` //RFC3339 UTC
+	IsDelete			bool				`json:"isDelete"`
+	Order				*Order				`json:"order"` //null for a delete
+	Changes				[]FieldChange		`json:"changes"` //against the previous version, empty for a created order
+}
+
+// OrderAsOf is an order as it stood at an instant, with the evidence attached to it and the
+// last position reported by then when asked for
+type OrderAsOf struct {
+	OrderId				string					`json:"orderId"`
+	AsOf				string					`json:"asOf"` //RFC3339 UTC
+	TxId				string					`json:"txId"` //transaction that wrote this version
+	Timestamp			string					`json:"timestamp"` //RFC3339 UTC of that transaction
+	Order				*Order					`json:"order"`
+	String				[]StringHash			`json:"string,omitempty"`
+	File				[]FileHash				`json:"file,omitempty"`
+	Dispute				*Dispute				`json:"dispute,omitempty"`
+	Weight				*WeightReconciliation	`json:"weight,omitempty"`
+	LastPosition		*UpdatePositionHistory	`json:"lastPosition,omitempty"`
+}
+
+type FieldChange struct {
+	Field				string				`json:"field"`
+	From				interface{}			`json:"from"`
+	To					interface{}			`json:"to"`
+}
+
+type Config struct {
+	ObjectType 			string  `json:"docType"`
+	BrokerCommissionRate float64 `json:"brokerCommissionRate"`
+	WeightTolerancePercent float64 `json:"weightTolerancePercent"`
+	CancelPenaltyRates	map[string]float64 `json:"cancelPenaltyRates"` //share of the fee charged per state, only these states can be cancelled
+}
+
+type DisputeEvidence struct {
+	PartyId				string  	`json:"partyId"`
+	Statement			string  	`json:"statement"`
+	Hashes				[]string	`json:"hashes"`
+	Timestamp			string  	`json:"timestamp"`
+}
+
+type Dispute struct {
+	ObjectType 			string  			`json:"docType"`
+	OrderId      		string  			`json:"orderId"`
+	OpenedBy			string  			`json:"openedBy"`
+	Reason				string  			`json:"reason"`
+	DisputeState		string  			`json:"disputeState"` //in [OPEN, RESOLVED]
+	Evidence			[]DisputeEvidence	`json:"evidence"`
+	ArbitratorId		string  			`json:"arbitratorId"`
+	RefundAmount		float64 			`json:"refundAmount"`
+	Resolution			string  			`json:"resolution"`
+}
+
+type WeighbridgeReading struct {
+	WeightTon      		float64 `json:"weightTon"`
+	TicketHash			string  `json:"ticketHash"`
+	RecordedBy			string  `json:"recordedBy"`
+	Timestamp			string  `json:"timestamp"`
+}
+
+type WeightReconciliation struct {
+	ObjectType 			string  			`json:"docType"`
+	OrderId      		string  			`json:"orderId"`
+	Loading				*WeighbridgeReading	`json:"loading,omitempty"`
+	Unloading			*WeighbridgeReading	`json:"unloading,omitempty"`
+	VarianceTon			float64 			`json:"varianceTon"`
+	VariancePercent		float64 			`json:"variancePercent"`
+	Flagged				bool    			`json:"flagged"`
+	Resolved			bool    			`json:"resolved"`
+	ResolvedBy			string  			`json:"resolvedBy"`
+	Resolution			string  			`json:"resolution"`
 }
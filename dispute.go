@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ====DISPUTES (CLI) ======================================================================
+// 发起争议 peer chaincode invoke -C myc1 -n orders -c '{"Args":["openDispute","orderId0","goodsOwnerId","短重2吨","[\"sha256...\"]"]}'
+// 回应争议 peer chaincode invoke -C myc1 -n orders -c '{"Args":["respondDispute","orderId0","driverId","过磅单据齐全","[\"sha256...\"]"]}'
+// 裁决争议 peer chaincode invoke -C myc1 -n orders -c '{"Args":["resolveDispute","orderId0","arbitratorId","400","按短重比例退款"]}'
+//
+// A goods owner may dispute an order in ARRIVED_WAIT_SIGN instead of signing it. Broker and
+// driver answer with their own evidence, and a user with the arbitrator role resolves the
+// dispute by deciding how much of the locked fee is refunded. The order is then SIGNED.
+// =========================================================================================
+
+func disputeKey(stub shim.ChaincodeStubInterface, orderId string) (string, error) {
+	return stub.CreateCompositeKey("dispute", []string{orderId})
+}
+
+func getDispute(stub shim.ChaincodeStubInterface, orderId string) (*Dispute, error) {
+	key, err := disputeKey(stub, orderId)
+	if err != nil {
+		return nil, err
+	}
+	disputeAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	} else if disputeAsBytes == nil {
+		return nil, nil
+	}
+	dispute := &Dispute{}
+	err = json.Unmarshal(disputeAsBytes, dispute)
+	return dispute, err
+}
+
+func putDispute(stub shim.ChaincodeStubInterface, dispute *Dispute) error {
+	key, err := disputeKey(stub, dispute.OrderId)
+	if err != nil {
+		return err
+	}
+	disputeAsBytes, err := json.Marshal(dispute)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, disputeAsBytes)
+}
+
+// parseHashes reads a JSON array of evidence hashes, an empty argument meaning none
+func parseHashes(arg string) ([]string, error) {
+	var hashes []string
+	if len(arg) == 0 {
+		return hashes, nil
+	}
+	err := json.Unmarshal([]byte(arg), &hashes)
+	if err != nil {
+		return nil, fmt.Errorf("Evidence must be a JSON array of hashes: %s", err.Error())
+	}
+	return hashes, nil
+}
+
+func newEvidence(stub shim.ChaincodeStubInterface, partyId string, statement string, hashesArg string) (DisputeEvidence, error) {
+	hashes, err := parseHashes(hashesArg)
+	if err != nil {
+		return DisputeEvidence{}, err
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return DisputeEvidence{}, err
+	}
+	return DisputeEvidence{partyId, statement, hashes, txTime.Format(time.RFC3339)}, nil
+}
+
+// ============================================================
+// openDispute - goods owner disputes an order instead of signing it
+// ============================================================
+func (t *SimpleChaincode) openDispute(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0       		1       		2     		3
+	// "orderId0", "goodsOwnerId", "reason", "[\"hash\"]"
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+	if len(args[2]) <= 0 {
+		return shim.Error("3rd argument must be a non-empty string")
+	}
+	fmt.Println("- start openDispute")
+	orderId := args[0]
+	ownerId, err := actingUserId(stub, args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	order, err := getOrder(stub, orderId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if order.GoodsOwnerId != ownerId {
+		return shim.Error("Only the goods owner of order " + orderId + " can open a dispute")
+	}
+	if order.OrderState != "ARRIVED_WAIT_SIGN" {
+		return shim.Error("Only orders in ARRIVED_WAIT_SIGN can be disputed, order " + orderId + " is " + order.OrderState)
+	}
+	dispute, err := getDispute(stub, orderId)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if dispute != nil {
+		return shim.Error("Order " + orderId + " has already been disputed")
+	}
+
+	evidence, err := newEvidence(stub, ownerId, args[2], args[3])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	dispute = &Dispute{ObjectType: "dispute", OrderId: orderId, OpenedBy: ownerId, Reason: args[2],
+		DisputeState: "OPEN", Evidence: []DisputeEvidence{evidence}}
+	err = putDispute(stub, dispute)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	order.OrderState = "DISPUTED"
+	res := writeToRecordsLedger(stub, *order, "DISPUTED")
+	if res.Status != shim.OK {
+		return res
+	}
+	fmt.Println("- end openDispute")
+	return shim.Success(nil)
+}
+
+// ============================================================
+// respondDispute - broker or driver answer a dispute with their own evidence
+// ============================================================
+func (t *SimpleChaincode) respondDispute(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0       		1       	2     		3
+	// "orderId0", "driverId", "statement", "[\"hash\"]"
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+	fmt.Println("- start respondDispute")
+	orderId := args[0]
+	partyId, err := actingUserId(stub, args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	order, err := getOrder(stub, orderId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if partyId != order.BrokerId && partyId != order.DriverId {
+		return shim.Error("Only the broker or driver of order " + orderId + " can respond to its dispute")
+	}
+	dispute, err := getDispute(stub, orderId)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if dispute == nil || dispute.DisputeState != "OPEN" {
+		return shim.Error("Order " + orderId + " has no open dispute")
+	}
+
+	evidence, err := newEvidence(stub, partyId, args[2], args[3])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	dispute.Evidence = append(dispute.Evidence, evidence)
+	err = putDispute(stub, dispute)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// number the responses of a party, a second response must not overwrite the first
+	responses := 0
+	for _, evidence := range dispute.Evidence {
+		if evidence.PartyId == partyId {
+			responses++
+		}
+	}
+	res := writeToRecordsLedger(stub, *order, "DISPUTE_RESPONSE_"+partyId+"_"+strconv.Itoa(responses))
+	if res.Status != shim.OK {
+		return res
+	}
+	fmt.Println("- end respondDispute")
+	return shim.Success(nil)
+}
+
+// ============================================================
+// resolveDispute - arbitrator decides the refund and the order is signed
+// ============================================================
+func (t *SimpleChaincode) resolveDispute(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0       		1       		2     		3
+	// "orderId0", "arbitratorId", "400", "resolution"
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+	fmt.Println("- start resolveDispute")
+	orderId := args[0]
+	arbitratorId, err := actingUserId(stub, args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	refundAmount, err := strconv.ParseFloat(args[2], 64)
+	if err != nil {
+		return shim.Error("3rd argument must be a numeric string")
+	}
+
+	arbitrator, err := getUser(stub, arbitratorId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if arbitrator.Role != "arbitrator" || !arbitrator.Valid {
+		return shim.Error("User " + arbitratorId + " is not an active arbitrator")
+	}
+	order, err := getOrder(stub, orderId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	dispute, err := getDispute(stub, orderId)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if dispute == nil || dispute.DisputeState != "OPEN" {
+		return shim.Error("Order " + orderId + " has no open dispute")
+	}
+
+	var changes accountChanges
+	err = settleEscrow(stub, orderId, refundAmount, &changes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = changes.apply(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	// the arbitration also settles any weight variance the order was flagged for
+	err = markWeightResolved(stub, orderId, arbitratorId, args[3])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	dispute.DisputeState = "RESOLVED"
+	dispute.ArbitratorId = arbitratorId
+	dispute.RefundAmount = refundAmount
+	dispute.Resolution = args[3]
+	err = putDispute(stub, dispute)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resolvedDate, err := getTxDate(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	order.ChangeStateHistory["DISPUTE_RESOLVED"] = resolvedDate
+	order.OrderState = "SIGNED"
+	order.Open = false
+	res := writeToRecordsLedger(stub, *order, "SIGNED")
+	if res.Status != shim.OK {
+		return res
+	}
+	fmt.Println("- end resolveDispute")
+	return shim.Success(nil)
+}
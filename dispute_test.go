@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDisputeWorkflow(t *testing.T) {
+	s := newFixture(t)
+	createOrder(t, s, "order1")
+	expectError(t, s, "Only orders in ARRIVED_WAIT_SIGN can be disputed", "openDispute", "order1", "owner", "短重", "")
+	deliverOrder(t, s, "order1")
+
+	expectError(t, s, "Only the goods owner", "openDispute", "order1", "broker", "短重", "")
+	expectError(t, s, "JSON array of hashes", "openDispute", "order1", "owner", "短重", "sha1")
+	mustInvoke(t, s, "openDispute", "order1", "owner", "短重2吨", `["sha1","sha2"]`)
+	expectError(t, s, "order order1 is DISPUTED", "openDispute", "order1", "owner", "短重", "")
+	expectError(t, s, "cannot change from DISPUTED to SIGNED", "changeStateOrder", "order1", "SIGNED")
+
+	expectError(t, s, "Only the broker or driver", "respondDispute", "order1", "owner", "x", "")
+	mustInvoke(t, s, "respondDispute", "order1", "driver", "过磅单据齐全", `["sha3"]`)
+	mustInvoke(t, s, "respondDispute", "order1", "driver", "补充卸货视频", `["sha4"]`)
+
+	expectError(t, s, "is not an active arbitrator", "resolveDispute", "order1", "broker", "1000", "x")
+	expectError(t, s, "Refund of order order1 must be between 0 and 4000", "resolveDispute", "order1", "arbitrator", "5000", "x")
+	mustInvoke(t, s, "resolveDispute", "order1", "arbitrator", "1000", "按短重比例退款")
+
+	order := readOrder(t, s, "order1")
+	if order.OrderState != "SIGNED" || order.Open {
+		t.Errorf("a resolved order should be signed, got %+v", order)
+	}
+	for _, step := range []string{"DISPUTED", "DISPUTE_RESPONSE_driver_1", "DISPUTE_RESPONSE_driver_2", "DISPUTE_RESOLVED", "SIGNED"} {
+		if _, ok := order.ChangeStateHistory[step]; !ok {
+			t.Errorf("%s missing from history %v", step, order.ChangeStateHistory)
+		}
+	}
+	if balance := balanceOf(t, s, "owner"); balance != 97000 {
+		t.Errorf("owner should get 1000 back, balance %v", balance)
+	}
+	if balance := balanceOf(t, s, "broker"); balance != 300 {
+		t.Errorf("broker should get 10%% of 3000, got %v", balance)
+	}
+	if balance := balanceOf(t, s, "driver"); balance != 2700 {
+		t.Errorf("driver should get 90%% of 3000, got %v", balance)
+	}
+
+	var detail AutoGenerated
+	unmarshalPayload(t, mustInvoke(t, s, "queryOrderDetail", "order1"), &detail)
+	if detail.Dispute == nil || detail.Dispute.DisputeState != "RESOLVED" || len(detail.Dispute.Evidence) != 3 {
+		t.Errorf("unexpected dispute %+v", detail.Dispute)
+	}
+	expectError(t, s, "has no open dispute", "respondDispute", "order1", "driver", "x", "")
+	expectError(t, s, "has no open dispute", "resolveDispute", "order1", "arbitrator", "0", "x")
+}
+
+func TestDisputeRefundToOwnerWhoBrokers(t *testing.T) {
+	s := newFixture(t)
+	mustInvoke(t, s, "initOrder", "order1", "上海", "北京", "煤炭", "20", "4000", "WAIT_DRIVER_ACCEPT", "owner", "owner", "driver")
+	deliverOrder(t, s, "order1")
+	mustInvoke(t, s, "openDispute", "order1", "owner", "短重2吨", `["sha1"]`)
+	mustInvoke(t, s, "resolveDispute", "order1", "arbitrator", "1000", "按短重比例退款")
+
+	// the owner is refunded 1000 and paid the 10% commission of the other 3000 at once
+	for accountId, expected := range map[string]float64{"owner": 97300, "driver": 2700, "broker": 0, "arbitrator": 0} {
+		if balance := balanceOf(t, s, accountId); balance != expected {
+			t.Errorf("expected %s to hold %v, got %v", accountId, expected, balance)
+		}
+	}
+}
+
+func TestDisputeArguments(t *testing.T) {
+	s := newFixture(t)
+	expectError(t, s, "Order does not exist", "openDispute", "missing", "owner", "短重", "")
+	expectError(t, s, "3rd argument must be a non-empty string", "openDispute", "missing", "owner", "", "")
+	expectError(t, s, "Incorrect number of arguments", "openDispute", "order1")
+	expectError(t, s, "Incorrect number of arguments", "respondDispute", "order1")
+	expectError(t, s, "3rd argument must be a numeric string", "resolveDispute", "order1", "arbitrator", "all", "x")
+	expectError(t, s, "Incorrect number of arguments", "resolveDispute", "order1")
+}
@@ -0,0 +1,455 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ====ESCROW (CLI) ========================================================================
+// 充值 peer chaincode invoke -C myc1 -n orders -c '{"Args":["deposit","goodsOwnerId","10000"]}'
+// 提现 peer chaincode invoke -C myc1 -n orders -c '{"Args":["withdraw","driverId","500"]}'
+// 查询余额 peer chaincode query -C myc1 -n orders -c '{"Args":["readAccount","goodsOwnerId"]}'
+// 查询流水 peer chaincode query -C myc1 -n orders -c '{"Args":["queryLedgerEntries","goodsOwnerId"]}'
+//
+// The fee of an order is locked from the goods owner's account when the order is created,
+// released to broker and driver when the order is SIGNED and refunded when it is removed
+// before that. A resolved dispute may split it between refund and payout. Every movement of credit is written as a ledgerEntry document.
+//
+// Credit is deposited by admins once a payment has been received off chain. Users withdraw
+// from and read their own account only; admins any account.
+// =========================================================================================
+
+func accountKey(stub shim.ChaincodeStubInterface, accountId string) (string, error) {
+	return stub.CreateCompositeKey("account", []string{accountId})
+}
+
+func escrowKey(stub shim.ChaincodeStubInterface, orderId string) (string, error) {
+	return stub.CreateCompositeKey("escrow", []string{orderId})
+}
+
+// escrowAccountId names the escrow of an order in ledger entries
+func escrowAccountId(orderId string) string {
+	return "escrow:" + orderId
+}
+
+// getAccount returns the account of a user, an empty account if none has been opened yet
+func getAccount(stub shim.ChaincodeStubInterface, accountId string) (Account, error) {
+	account := Account{ObjectType: "account", AccountId: accountId}
+	key, err := accountKey(stub, accountId)
+	if err != nil {
+		return account, err
+	}
+	accountAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return account, err
+	} else if accountAsBytes == nil {
+		return account, nil
+	}
+	err = json.Unmarshal(accountAsBytes, &account)
+	return account, err
+}
+
+func putAccount(stub shim.ChaincodeStubInterface, account Account) error {
+	key, err := accountKey(stub, account.AccountId)
+	if err != nil {
+		return err
+	}
+	accountAsBytes, err := json.Marshal(account)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, accountAsBytes)
+}
+
+func creditAccount(stub shim.ChaincodeStubInterface, accountId string, amount float64) error {
+	account, err := getAccount(stub, accountId)
+	if err != nil {
+		return err
+	}
+	account.Balance += amount
+	return putAccount(stub, account)
+}
+
+// debitAccount takes credit from an account, refusing to let the balance go negative
+func debitAccount(stub shim.ChaincodeStubInterface, accountId string, amount float64) error {
+	account, err := getAccount(stub, accountId)
+	if err != nil {
+		return err
+	}
+	if account.Balance < amount {
+		return fmt.Errorf("Insufficient balance in account %s: %v < %v", accountId, account.Balance, amount)
+	}
+	account.Balance -= amount
+	return putAccount(stub, account)
+}
+
+// accountChanges adds up what a transaction moves in and out of each account, so that every
+// account is read and written once. A peer does not return a transaction's own writes to its
+// reads, so a second read-modify-write of an account would start from the balance before the
+// transaction and undo the first.
+type accountChanges struct {
+	accountIds []string
+	amounts    map[string]float64
+}
+
+// add credits an account with amount, or debits it when amount is negative
+func (changes *accountChanges) add(accountId string, amount float64) {
+	if changes.amounts == nil {
+		changes.amounts = make(map[string]float64)
+	}
+	if _, ok := changes.amounts[accountId]; !ok {
+		changes.accountIds = append(changes.accountIds, accountId)
+	}
+	changes.amounts[accountId] += amount
+}
+
+// apply writes the net change of every account, refusing to let a balance go negative
+func (changes *accountChanges) apply(stub shim.ChaincodeStubInterface) error {
+	for _, accountId := range changes.accountIds {
+		amount := changes.amounts[accountId]
+		if amount == 0 {
+			continue
+		}
+		account, err := getAccount(stub, accountId)
+		if err != nil {
+			return err
+		}
+		if account.Balance+amount < 0 {
+			return fmt.Errorf("Insufficient balance in account %s: %v < %v", accountId, account.Balance, -amount)
+		}
+		account.Balance += amount
+		err = putAccount(stub, account)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeLedgerEntry records a single movement of credit. The key is made of the txId, the
+// movement and the role it pays, so that the payouts of one account that is both broker and
+// driver of an order are kept apart.
+func writeLedgerEntry(stub shim.ChaincodeStubInterface, entryType string, orderId string, from string, to string, amount float64, role string) error {
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return err
+	}
+	entry := &LedgerEntry{"ledgerEntry", stub.GetTxID(), orderId, entryType, from, to, amount, txTime.Format(time.RFC3339), role}
+	key, err := stub.CreateCompositeKey("ledgerEntry", []string{entry.TxId, entryType, from, to, role})
+	if err != nil {
+		return err
+	}
+	entryAsBytes, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, entryAsBytes)
+}
+
+func getEscrow(stub shim.ChaincodeStubInterface, orderId string) (*Escrow, error) {
+	key, err := escrowKey(stub, orderId)
+	if err != nil {
+		return nil, err
+	}
+	escrowAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	} else if escrowAsBytes == nil {
+		return nil, nil
+	}
+	escrow := &Escrow{}
+	err = json.Unmarshal(escrowAsBytes, escrow)
+	return escrow, err
+}
+
+func putEscrow(stub shim.ChaincodeStubInterface, escrow *Escrow) error {
+	key, err := escrowKey(stub, escrow.OrderId)
+	if err != nil {
+		return err
+	}
+	escrowAsBytes, err := json.Marshal(escrow)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, escrowAsBytes)
+}
+
+// lockEscrow moves the fee of a new order from the goods owner's account into escrow
+func lockEscrow(stub shim.ChaincodeStubInterface, order *Order) error {
+	config, err := getConfig(stub)
+	if err != nil {
+		return err
+	}
+	err = debitAccount(stub, order.GoodsOwnerId, order.TransFee)
+	if err != nil {
+		return err
+	}
+	brokerAmount := order.TransFee * config.BrokerCommissionRate
+	escrow := &Escrow{"escrow", order.OrderId, order.GoodsOwnerId, order.BrokerId, order.DriverId,
+		order.TransFee, brokerAmount, order.TransFee - brokerAmount, "LOCKED"}
+	err = putEscrow(stub, escrow)
+	if err != nil {
+		return err
+	}
+	return writeLedgerEntry(stub, "LOCK", order.OrderId, order.GoodsOwnerId, escrowAccountId(order.OrderId), order.TransFee, "")
+}
+
+// adjustEscrow locks more of the goods owner's credit, or gives some back, when the fee of
+// an order changes. The split between broker and driver keeps the proportions fixed when
+// the fee was locked. Orders without a locked escrow are left alone.
+func adjustEscrow(stub shim.ChaincodeStubInterface, orderId string, transFee float64) error {
+	escrow, err := getEscrow(stub, orderId)
+	if err != nil {
+		return err
+	} else if escrow == nil {
+		return nil
+	}
+	if escrow.EscrowState != "LOCKED" {
+		return fmt.Errorf("Escrow of order %s is already %s", orderId, escrow.EscrowState)
+	}
+	difference := transFee - escrow.Amount
+	if difference > 0 {
+		err = debitAccount(stub, escrow.GoodsOwnerId, difference)
+		if err != nil {
+			return err
+		}
+		err = writeLedgerEntry(stub, "LOCK", orderId, escrow.GoodsOwnerId, escrowAccountId(orderId), difference, "")
+	} else if difference < 0 {
+		err = creditAccount(stub, escrow.GoodsOwnerId, -difference)
+		if err != nil {
+			return err
+		}
+		err = writeLedgerEntry(stub, "REFUND", orderId, escrowAccountId(orderId), escrow.GoodsOwnerId, -difference, "")
+	}
+	if err != nil {
+		return err
+	}
+	brokerRate := 0.0
+	if escrow.Amount > 0 {
+		brokerRate = escrow.BrokerAmount / escrow.Amount
+	} else {
+		config, err := getConfig(stub)
+		if err != nil {
+			return err
+		}
+		brokerRate = config.BrokerCommissionRate
+	}
+	escrow.Amount = transFee
+	escrow.BrokerAmount = transFee * brokerRate
+	escrow.DriverAmount = transFee - escrow.BrokerAmount
+	return putEscrow(stub, escrow)
+}
+
+// releaseEscrow pays a locked fee out to broker and driver. Orders created before
+// escrow was introduced have nothing locked and are left alone.
+func releaseEscrow(stub shim.ChaincodeStubInterface, orderId string, changes *accountChanges) error {
+	return settleEscrow(stub, orderId, 0, changes)
+}
+
+// settleEscrow pays a locked fee out: refundAmount goes back to the goods owner and the
+// rest is split between broker and driver in the proportions fixed when it was locked. The
+// credits are added to changes, which the caller applies.
+func settleEscrow(stub shim.ChaincodeStubInterface, orderId string, refundAmount float64, changes *accountChanges) error {
+	escrow, err := getEscrow(stub, orderId)
+	if err != nil {
+		return err
+	} else if escrow == nil {
+		return nil
+	}
+	if escrow.EscrowState != "LOCKED" {
+		return fmt.Errorf("Escrow of order %s is already %s", orderId, escrow.EscrowState)
+	}
+	if refundAmount < 0 || refundAmount > escrow.Amount {
+		return fmt.Errorf("Refund of order %s must be between 0 and %v", orderId, escrow.Amount)
+	}
+	payoutRate := 0.0
+	if escrow.Amount > 0 {
+		payoutRate = (escrow.Amount - refundAmount) / escrow.Amount
+	}
+	payouts := []struct {
+		entryType string
+		role      string
+		accountId string
+		amount    float64
+	}{
+		{"REFUND", "goodsOwner", escrow.GoodsOwnerId, refundAmount},
+		{"RELEASE", "broker", escrow.BrokerId, escrow.BrokerAmount * payoutRate},
+		{"RELEASE", "driver", escrow.DriverId, escrow.DriverAmount * payoutRate},
+	}
+	for _, payout := range payouts {
+		if payout.amount == 0 {
+			continue
+		}
+		changes.add(payout.accountId, payout.amount)
+		err = writeLedgerEntry(stub, payout.entryType, orderId, escrowAccountId(orderId), payout.accountId, payout.amount, payout.role)
+		if err != nil {
+			return err
+		}
+		if payout.entryType == "RELEASE" {
+			err = addSummaryDelta(stub, payout.accountId, orderId+"/fee/"+payout.role, PartySummary{FeesEarned: payout.amount})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if refundAmount == 0 {
+		escrow.EscrowState = "RELEASED"
+	} else if refundAmount == escrow.Amount {
+		escrow.EscrowState = "REFUNDED"
+	} else {
+		escrow.EscrowState = "SETTLED"
+	}
+	return putEscrow(stub, escrow)
+}
+
+// refundEscrow gives a locked fee back to the goods owner, adding the credit to changes
+func refundEscrow(stub shim.ChaincodeStubInterface, orderId string, changes *accountChanges) error {
+	escrow, err := getEscrow(stub, orderId)
+	if err != nil {
+		return err
+	} else if escrow == nil || escrow.EscrowState != "LOCKED" {
+		return nil
+	}
+	changes.add(escrow.GoodsOwnerId, escrow.Amount)
+	err = writeLedgerEntry(stub, "REFUND", orderId, escrowAccountId(orderId), escrow.GoodsOwnerId, escrow.Amount, "goodsOwner")
+	if err != nil {
+		return err
+	}
+	escrow.EscrowState = "REFUNDED"
+	return putEscrow(stub, escrow)
+}
+
+// parseAmount validates a positive amount argument
+func parseAmount(arg string) (float64, error) {
+	amount, err := strconv.ParseFloat(arg, 64)
+	if err != nil || amount <= 0 {
+		return 0, fmt.Errorf("Amount must be a positive number: %s", arg)
+	}
+	return amount, nil
+}
+
+// ============================================================
+// deposit - add credit to the account of a registered user
+// ============================================================
+func (t *SimpleChaincode) deposit(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0       		1
+	// "goodsOwnerId", "10000"
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	fmt.Println("- start deposit")
+	userId := args[0]
+	amount, err := parseAmount(args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = requireAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	userAsBytes, err := stub.GetState(userId)
+	if err != nil {
+		return shim.Error("Failed to get user: " + err.Error())
+	} else if userAsBytes == nil {
+		return shim.Error("User does not exist: " + userId)
+	}
+
+	err = creditAccount(stub, userId, amount)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = writeLedgerEntry(stub, "DEPOSIT", "", "", userId, amount, "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Println("- end deposit")
+	return shim.Success(nil)
+}
+
+// ============================================================
+// withdraw - take credit out of an account
+// ============================================================
+func (t *SimpleChaincode) withdraw(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0       	1
+	// "driverId", "500"
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	fmt.Println("- start withdraw")
+	amount, err := parseAmount(args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	userId, err := actingUserId(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = debitAccount(stub, userId, amount)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = writeLedgerEntry(stub, "WITHDRAW", "", userId, "", amount, "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Println("- end withdraw")
+	return shim.Success(nil)
+}
+
+// ============================================================
+// readAccount - read the balance of a user
+// ============================================================
+func (t *SimpleChaincode) readAccount(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0
+	// "goodsOwnerId"
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	accountId, err := actingUserId(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	account, err := getAccount(stub, accountId)
+	if err != nil {
+		return shim.Error("Failed to get account: " + err.Error())
+	}
+	accountAsBytes, err := json.Marshal(account)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(accountAsBytes)
+}
+
+// ============================================================
+// queryLedgerEntries - list every movement in or out of an account
+// Only available on state databases that support rich query (e.g. CouchDB)
+// ============================================================
+func (t *SimpleChaincode) queryLedgerEntries(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0       		1		2
+	// "goodsOwnerId", ["10", "bookmark"]
+	pageSize, bookmark, err := parsePaginationArgs(args, 1)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	accountId, err := actingUserId(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	queryString, err := buildQueryString(map[string]interface{}{"docType": "ledgerEntry",
+		"$or": []interface{}{map[string]interface{}{"fromAccount": accountId}, map[string]interface{}{"toAccount": accountId}}})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	queryResults, err := getQueryResultForQueryStringWithPagination(stub, queryString, pageSize, bookmark)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(queryResults)
+}
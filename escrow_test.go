@@ -0,0 +1,140 @@
+package main
+
+import (
+	"testing"
+)
+
+func balanceOf(t *testing.T, s *testStub, accountId string) float64 {
+	t.Helper()
+	var account Account
+	unmarshalPayload(t, mustInvoke(t, s, "readAccount", accountId), &account)
+	return account.Balance
+}
+
+func TestDepositAndWithdraw(t *testing.T) {
+	s := newFixture(t)
+	if balance := balanceOf(t, s, "owner"); balance != 100000 {
+		t.Errorf("expected 100000, got %v", balance)
+	}
+	mustInvoke(t, s, "withdraw", "owner", "30000")
+	if balance := balanceOf(t, s, "owner"); balance != 70000 {
+		t.Errorf("expected 70000, got %v", balance)
+	}
+	if balance := balanceOf(t, s, "nobody"); balance != 0 {
+		t.Errorf("an unopened account should be empty, got %v", balance)
+	}
+
+	expectError(t, s, "Insufficient balance", "withdraw", "owner", "70000.01")
+	expectError(t, s, "User does not exist", "deposit", "nobody", "100")
+	expectError(t, s, "Amount must be a positive number", "deposit", "owner", "-100")
+	expectError(t, s, "Amount must be a positive number", "withdraw", "owner", "abc")
+	expectError(t, s, "Incorrect number of arguments", "deposit", "owner")
+	expectError(t, s, "Incorrect number of arguments", "readAccount")
+}
+
+func TestAccountsBelongToTheirUser(t *testing.T) {
+	s := newFixture(t)
+	s.caller = "driver"
+	expectError(t, s, "Only an admin can call deposit, driver is driver", "deposit", "driver", "100")
+	expectError(t, s, "User driver cannot act as owner", "withdraw", "owner", "100")
+	expectError(t, s, "User driver cannot act as owner", "readAccount", "owner")
+	expectError(t, s, "User driver cannot act as owner", "queryLedgerEntries", "owner")
+	if balance := balanceOf(t, s, ""); balance != 0 {
+		t.Errorf("an empty account id should read the caller's account, got %v", balance)
+	}
+	s.caller = "owner"
+	mustInvoke(t, s, "withdraw", "", "100")
+	if balance := balanceOf(t, s, "owner"); balance != 99900 {
+		t.Errorf("the owner should withdraw from their own account, balance %v", balance)
+	}
+	expectError(t, s, "User owner cannot act as owner2", "initOrder",
+		"order1", "上海", "北京", "煤炭", "20", "4000", "WAIT_DRIVER_ACCEPT", "owner2", "broker", "driver")
+	s.caller = "broker"
+	expectError(t, s, "User broker cannot act as owner", "initOrder",
+		"order1", "上海", "北京", "煤炭", "20", "4000", "WAIT_DRIVER_ACCEPT", "owner", "broker", "driver")
+	s.caller = "owner"
+	createOrder(t, s, "order1")
+	if order := readOrder(t, s, "order1"); order.GoodsOwnerId != "owner" {
+		t.Errorf("unexpected goods owner %s", order.GoodsOwnerId)
+	}
+}
+
+func TestEscrowReleasedOnSigned(t *testing.T) {
+	s := newFixture(t)
+	createOrder(t, s, "order1")
+	if balance := balanceOf(t, s, "owner"); balance != 96000 {
+		t.Errorf("fee should be locked from the owner, balance %v", balance)
+	}
+
+	deliverOrder(t, s, "order1")
+	signOrder(t, s, "order1")
+	if balance := balanceOf(t, s, "broker"); balance != 400 {
+		t.Errorf("broker should get the default 10%% commission, got %v", balance)
+	}
+	if balance := balanceOf(t, s, "driver"); balance != 3600 {
+		t.Errorf("driver should get the rest of the fee, got %v", balance)
+	}
+	escrow, err := getEscrow(s, "order1")
+	if err != nil || escrow.EscrowState != "RELEASED" {
+		t.Errorf("unexpected escrow %+v, %v", escrow, err)
+	}
+}
+
+func TestEscrowReleasedToBrokerWhoDrives(t *testing.T) {
+	s := newFixture(t)
+	mustInvoke(t, s, "initOrder", "order1", "上海", "北京", "煤炭", "20", "4000", "WAIT_DRIVER_ACCEPT", "owner", "driver", "driver")
+	deliverOrder(t, s, "order1")
+	signOrder(t, s, "order1")
+	if balance := balanceOf(t, s, "driver"); balance != 4000 {
+		t.Errorf("driver should get both the commission and the rest of the fee, got %v", balance)
+	}
+
+	var entries []struct {
+		Record LedgerEntry
+	}
+	unmarshalPage(t, mustInvoke(t, s, "queryLedgerEntries", "driver"), &entries)
+	roles := map[string]float64{}
+	for _, entry := range entries {
+		roles[entry.Record.Role] = entry.Record.Amount
+	}
+	if len(entries) != 2 || roles["broker"] != 400 || roles["driver"] != 3600 {
+		t.Errorf("expected a release for each role, got %+v", entries)
+	}
+}
+
+func TestEscrowRefundedOnDelete(t *testing.T) {
+	s := newFixture(t)
+	createOrder(t, s, "order1")
+	mustInvoke(t, s, "delete", "order1")
+	if balance := balanceOf(t, s, "owner"); balance != 100000 {
+		t.Errorf("fee should be refunded, balance %v", balance)
+	}
+}
+
+func TestQueryLedgerEntries(t *testing.T) {
+	s := newFixture(t)
+	createOrder(t, s, "order1")
+	deliverOrder(t, s, "order1")
+	signOrder(t, s, "order1")
+	mustInvoke(t, s, "withdraw", "driver", "600")
+
+	var entries []struct {
+		Record LedgerEntry
+	}
+	unmarshalPage(t, mustInvoke(t, s, "queryLedgerEntries", "driver"), &entries)
+	if len(entries) != 2 {
+		t.Fatalf("expected release and withdraw, got %+v", entries)
+	}
+	types := map[string]float64{}
+	for _, entry := range entries {
+		types[entry.Record.EntryType] = entry.Record.Amount
+	}
+	if types["RELEASE"] != 3600 || types["WITHDRAW"] != 600 {
+		t.Errorf("unexpected entries %+v", entries)
+	}
+	page := unmarshalPage(t, mustInvoke(t, s, "queryLedgerEntries", "driver", "1", ""), &entries)
+	if len(entries) != 1 || !page.HasMore {
+		t.Errorf("unexpected page %+v", page)
+	}
+	expectError(t, s, "Incorrect number of arguments", "queryLedgerEntries")
+}
@@ -0,0 +1,592 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ====ORGANIZATIONS AND FLEETS (CLI) ======================================================
+// 创建组织(仅管理员) peer chaincode invoke -C myc1 -n orders -c '{"Args":["initOrganization","orgId0","顺达物流","carrier"]}'
+// 加入组织(仅管理员) peer chaincode invoke -C myc1 -n orders -c '{"Args":["setUserOrganization","driverId","orgId0"]}'
+// 登记车辆 peer chaincode invoke -C myc1 -n orders -c '{"Args":["initVehicle","沪A12345","30","厢式货车","orgId0"]}'
+// 分配司机 peer chaincode invoke -C myc1 -n orders -c '{"Args":["assignVehicleDriver","沪A12345","driverId"]}'
+// 解除分配 peer chaincode invoke -C myc1 -n orders -c '{"Args":["unassignVehicleDriver","沪A12345","driverId"]}'
+// 查询车辆 peer chaincode query -C myc1 -n orders -c '{"Args":["readVehicle","沪A12345"]}'
+// 组织的司机 peer chaincode query -C myc1 -n orders -c '{"Args":["queryOrganizationDrivers","orgId0","10",""]}'
+// 组织的车辆 peer chaincode query -C myc1 -n orders -c '{"Args":["queryOrganizationVehicles","orgId0"]}'
+// 组织的运单 peer chaincode query -C myc1 -n orders -c '{"Args":["queryOrganizationOrders","orgId0","10",""]}'
+// 指定车辆下单 peer chaincode invoke -C myc1 -n orders -c '{"Args":["initOrder","orderId0","上海","北京","煤炭","20","4000","WAIT_DRIVER_ACCEPT","goodsOwnerId","brokerId0","driverId","{\"vehiclePlate\":\"沪A12345\"}"]}'
+//
+// Carriers, brokers and shippers are organizations. Admins create them and place users in
+// them; admins and the brokers of an organization register its vehicles and assign its
+// drivers to them. An order naming a vehicle must be driven by a driver assigned to it and
+// must not weigh more than it carries. Members of an organization, admins and arbitrators
+// list its drivers, its vehicles and the orders any of its members is a party of.
+// =========================================================================================
+
+const (
+	orgUserIndex       = "org~user"
+	orgVehicleIndex    = "org~vehicle"
+	driverVehicleIndex = "driver~vehicle"
+)
+
+var orgTypes = map[string]bool{
+	"carrier": true,
+	"broker":  true,
+	"shipper": true,
+}
+
+func organizationKey(stub shim.ChaincodeStubInterface, orgId string) (string, error) {
+	return stub.CreateCompositeKey("organization", []string{orgId})
+}
+
+func vehicleKey(stub shim.ChaincodeStubInterface, plate string) (string, error) {
+	return stub.CreateCompositeKey("vehicle", []string{plate})
+}
+
+func getOrganization(stub shim.ChaincodeStubInterface, orgId string) (*Organization, error) {
+	key, err := organizationKey(stub, orgId)
+	if err != nil {
+		return nil, err
+	}
+	orgAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get organization: %s", err.Error())
+	} else if orgAsBytes == nil {
+		return nil, fmt.Errorf("Organization does not exist: %s", orgId)
+	}
+	org := &Organization{}
+	err = json.Unmarshal(orgAsBytes, org)
+	return org, err
+}
+
+func getVehicle(stub shim.ChaincodeStubInterface, plate string) (*Vehicle, error) {
+	key, err := vehicleKey(stub, plate)
+	if err != nil {
+		return nil, err
+	}
+	vehicleAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get vehicle: %s", err.Error())
+	} else if vehicleAsBytes == nil {
+		return nil, fmt.Errorf("Vehicle does not exist: %s", plate)
+	}
+	vehicle := &Vehicle{}
+	err = json.Unmarshal(vehicleAsBytes, vehicle)
+	return vehicle, err
+}
+
+func putVehicle(stub shim.ChaincodeStubInterface, vehicle *Vehicle) error {
+	key, err := vehicleKey(stub, vehicle.Plate)
+	if err != nil {
+		return err
+	}
+	vehicleAsBytes, err := json.Marshal(vehicle)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, vehicleAsBytes)
+}
+
+// putIndexEntry writes (present) or deletes an entry of a composite-key index
+func putIndexEntry(stub shim.ChaincodeStubInterface, index string, attributes []string, present bool) error {
+	key, err := stub.CreateCompositeKey(index, attributes)
+	if err != nil {
+		return err
+	}
+	if !present {
+		return stub.DelState(key)
+	}
+	//  Only the key name is needed, passing a 'nil' value would delete the key
+	return stub.PutState(key, []byte{0x00})
+}
+
+// indexedIds lists the last attribute of the index entries under a partial key, in key order
+func indexedIds(stub shim.ChaincodeStubInterface, index string, partialKey []string) ([]string, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(index, partialKey)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+	var ids []string
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, keyParts, err := stub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, keyParts[len(keyParts)-1])
+	}
+	return ids, nil
+}
+
+// requireFleetManager refuses the transaction unless an admin or a broker of the
+// organization submitted it
+func requireFleetManager(stub shim.ChaincodeStubInterface, orgId string) error {
+	caller, err := getCaller(stub)
+	if err != nil {
+		return err
+	}
+	if !isAdmin(caller) && (caller.Role != "broker" || caller.OrgId != orgId) {
+		return fmt.Errorf("Only an admin or a broker of %s can manage its fleet, %s is %s", orgId, caller.UserId, caller.Role)
+	}
+	return nil
+}
+
+// requireOrganizationReader refuses the transaction unless an admin, an arbitrator or a
+// member of the organization submitted it
+func requireOrganizationReader(stub shim.ChaincodeStubInterface, orgId string) error {
+	caller, err := getCaller(stub)
+	if err != nil {
+		return err
+	}
+	if !isAdmin(caller) && caller.Role != "arbitrator" && caller.OrgId != orgId {
+		return fmt.Errorf("User %s cannot read organization %s", caller.UserId, orgId)
+	}
+	return nil
+}
+
+// checkOrderVehicle refuses an order whose vehicle is not driven by its driver or cannot
+// carry its weight
+func checkOrderVehicle(stub shim.ChaincodeStubInterface, order *Order) error {
+	if order.VehiclePlate == "" {
+		return nil
+	}
+	vehicle, err := getVehicle(stub, order.VehiclePlate)
+	if err != nil {
+		return err
+	}
+	assigned := false
+	for _, driverId := range vehicle.DriverIds {
+		if driverId == order.DriverId {
+			assigned = true
+		}
+	}
+	if !assigned {
+		return fmt.Errorf("Driver %s is not assigned to vehicle %s", order.DriverId, vehicle.Plate)
+	}
+	if order.WeightTon > vehicle.CapacityTon {
+		return fmt.Errorf("Vehicle %s carries %v tons, the order weighs %v", vehicle.Plate, vehicle.CapacityTon, order.WeightTon)
+	}
+	return nil
+}
+
+// ============================================================
+// initOrganization - create a carrier, broker or shipper organization
+// ============================================================
+func (t *SimpleChaincode) initOrganization(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0       		1			2
+	// "orgId0", "顺达物流", "carrier"
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	if len(args[0]) <= 0 {
+		return shim.Error("1st argument must be a non-empty string")
+	}
+	if len(args[1]) <= 0 {
+		return shim.Error("2nd argument must be a non-empty string")
+	}
+	if !orgTypes[args[2]] {
+		return shim.Error("orgType must be carrier, broker or shipper, got " + args[2])
+	}
+	caller, err := getCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !isAdmin(caller) {
+		return shim.Error(fmt.Sprintf("Only an admin can create organizations, %s is %s", caller.UserId, caller.Role))
+	}
+	key, err := organizationKey(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	orgAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return shim.Error("Failed to get organization: " + err.Error())
+	} else if orgAsBytes != nil {
+		return shim.Error("This organization already exists: " + args[0])
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	org := &Organization{ObjectType: "organization", OrgId: args[0], OrgName: args[1], OrgType: args[2],
+		CreatedAt: txTime.Format(time.RFC3339)}
+	orgAsBytes, err = json.Marshal(org)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(key, orgAsBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ============================================================
+// readOrganization - read an organization
+// ============================================================
+func (t *SimpleChaincode) readOrganization(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0
+	// "orgId0"
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	org, err := getOrganization(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	orgAsBytes, err := json.Marshal(org)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(orgAsBytes)
+}
+
+// ============================================================
+// setUserOrganization - place a user in an organization, or take them out of theirs
+// ============================================================
+func (t *SimpleChaincode) setUserOrganization(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0       		1
+	// "driverId", "orgId0"
+	// an empty orgId takes the user out of their organization
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	userId, orgId := args[0], args[1]
+	caller, err := getCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !isAdmin(caller) {
+		return shim.Error(fmt.Sprintf("Only an admin can place users in organizations, %s is %s", caller.UserId, caller.Role))
+	}
+	user, err := getUser(stub, userId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if orgId != "" {
+		_, err = getOrganization(stub, orgId)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	if user.OrgId == orgId {
+		return shim.Success(nil)
+	}
+	plates, err := indexedIds(stub, driverVehicleIndex, []string{userId})
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if len(plates) > 0 {
+		return shim.Error(fmt.Sprintf("Driver %s is assigned to vehicles %v of %s", userId, plates, user.OrgId))
+	}
+	if user.OrgId != "" {
+		err = putIndexEntry(stub, orgUserIndex, []string{user.OrgId, userId}, false)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	if orgId != "" {
+		err = putIndexEntry(stub, orgUserIndex, []string{orgId, userId}, true)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	user.OrgId = orgId
+	err = putUser(stub, user)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ============================================================
+// initVehicle - register a vehicle of an organization
+// ============================================================
+func (t *SimpleChaincode) initVehicle(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0       		1		2			3
+	// "沪A12345", "30", "厢式货车", "orgId0"
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+	if len(args[0]) <= 0 {
+		return shim.Error("1st argument must be a non-empty string")
+	}
+	capacityTon, err := strconv.ParseFloat(args[1], 64)
+	if err != nil || capacityTon <= 0 {
+		return shim.Error("2nd argument must be a positive number")
+	}
+	if len(args[2]) <= 0 {
+		return shim.Error("3rd argument must be a non-empty string")
+	}
+	plate, orgId := args[0], args[3]
+	_, err = getOrganization(stub, orgId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = requireFleetManager(stub, orgId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	key, err := vehicleKey(stub, plate)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	vehicleAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return shim.Error("Failed to get vehicle: " + err.Error())
+	} else if vehicleAsBytes != nil {
+		return shim.Error("This vehicle already exists: " + plate)
+	}
+	vehicle := &Vehicle{ObjectType: "vehicle", Plate: plate, CapacityTon: capacityTon, VehicleType: args[2],
+		OrgId: orgId, DriverIds: []string{}}
+	err = putVehicle(stub, vehicle)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = putIndexEntry(stub, orgVehicleIndex, []string{orgId, plate}, true)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ============================================================
+// readVehicle - read a vehicle and the drivers assigned to it
+// ============================================================
+func (t *SimpleChaincode) readVehicle(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0
+	// "沪A12345"
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	vehicle, err := getVehicle(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	vehicleAsBytes, err := json.Marshal(vehicle)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(vehicleAsBytes)
+}
+
+// assignDriver adds (assigned) or removes a driver of a vehicle
+func assignDriver(stub shim.ChaincodeStubInterface, plate string, driverId string, assigned bool) error {
+	vehicle, err := getVehicle(stub, plate)
+	if err != nil {
+		return err
+	}
+	err = requireFleetManager(stub, vehicle.OrgId)
+	if err != nil {
+		return err
+	}
+	var driverIds []string
+	for _, id := range vehicle.DriverIds {
+		if id != driverId {
+			driverIds = append(driverIds, id)
+		}
+	}
+	if assigned {
+		driver, err := getUser(stub, driverId)
+		if err != nil {
+			return err
+		}
+		if driver.Role != "driver" {
+			return fmt.Errorf("User %s is %s, not a driver", driverId, driver.Role)
+		}
+		if driver.OrgId != vehicle.OrgId {
+			return fmt.Errorf("Driver %s is not a member of %s", driverId, vehicle.OrgId)
+		}
+		driverIds = append(driverIds, driverId)
+	} else if len(driverIds) == len(vehicle.DriverIds) {
+		return fmt.Errorf("Driver %s is not assigned to vehicle %s", driverId, plate)
+	}
+	if driverIds == nil {
+		driverIds = []string{}
+	}
+	vehicle.DriverIds = driverIds
+	err = putVehicle(stub, vehicle)
+	if err != nil {
+		return err
+	}
+	return putIndexEntry(stub, driverVehicleIndex, []string{driverId, plate}, assigned)
+}
+
+// ============================================================
+// assignVehicleDriver - let a driver of the organization drive a vehicle
+// ============================================================
+func (t *SimpleChaincode) assignVehicleDriver(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0       		1
+	// "沪A12345", "driverId"
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	err := assignDriver(stub, args[0], args[1], true)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ============================================================
+// unassignVehicleDriver - stop a driver from driving a vehicle
+// ============================================================
+func (t *SimpleChaincode) unassignVehicleDriver(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0       		1
+	// "沪A12345", "driverId"
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	err := assignDriver(stub, args[0], args[1], false)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ============================================================
+// queryOrganizationDrivers - list the drivers of an organization
+// ============================================================
+func (t *SimpleChaincode) queryOrganizationDrivers(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0       		1		2
+	// "orgId0", ["10", "bookmark"]
+	pageSize, bookmark, err := parsePaginationArgs(args, 1)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = requireOrganizationReader(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	userIds, err := indexedIds(stub, orgUserIndex, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	var records []QueryRecord
+	for _, userId := range userIds {
+		user, err := getUser(stub, userId)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if user.Role != "driver" {
+			continue
+		}
+		userAsBytes, err := json.Marshal(user)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		records = append(records, QueryRecord{userId, userAsBytes})
+	}
+	page, err := pageOfRecords(records, pageSize, bookmark)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	pageAsBytes, err := json.Marshal(page)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(pageAsBytes)
+}
+
+// ============================================================
+// queryOrganizationVehicles - list the vehicles of an organization
+// ============================================================
+func (t *SimpleChaincode) queryOrganizationVehicles(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0       		1		2
+	// "orgId0", ["10", "bookmark"]
+	pageSize, bookmark, err := parsePaginationArgs(args, 1)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = requireOrganizationReader(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	plates, err := indexedIds(stub, orgVehicleIndex, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	var records []QueryRecord
+	for _, plate := range plates {
+		vehicle, err := getVehicle(stub, plate)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		vehicleAsBytes, err := json.Marshal(vehicle)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		records = append(records, QueryRecord{plate, vehicleAsBytes})
+	}
+	page, err := pageOfRecords(records, pageSize, bookmark)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	pageAsBytes, err := json.Marshal(page)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(pageAsBytes)
+}
+
+// ============================================================
+// queryOrganizationOrders - list the orders any member of an organization is a party of
+// ============================================================
+func (t *SimpleChaincode) queryOrganizationOrders(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0       		1		2
+	// "orgId0", ["10", "bookmark"]
+	pageSize, bookmark, err := parsePaginationArgs(args, 1)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = requireOrganizationReader(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	userIds, err := indexedIds(stub, orgUserIndex, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	var partialKeys [][]string
+	for _, userId := range userIds {
+		partialKeys = append(partialKeys, []string{userId})
+	}
+	ids := map[string]bool{}
+	err = indexedOrderIds(stub, orderPartyIndex, partialKeys, ids)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	orderIds := make([]string, 0, len(ids))
+	for orderId := range ids {
+		orderIds = append(orderIds, orderId)
+	}
+	sort.Strings(orderIds)
+	var records []QueryRecord
+	for _, orderId := range orderIds {
+		orderAsBytes, err := stub.GetState(orderId)
+		if err != nil {
+			return shim.Error(err.Error())
+		} else if orderAsBytes != nil {
+			records = append(records, QueryRecord{orderId, orderAsBytes})
+		}
+	}
+	page, err := pageOfRecords(records, pageSize, bookmark)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	pageAsBytes, err := json.Marshal(page)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(pageAsBytes)
+}
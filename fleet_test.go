@@ -0,0 +1,115 @@
+package main
+
+import "testing"
+
+// newFleetFixture places broker and driver in carrier org1 with a 25 ton truck driven by driver
+func newFleetFixture(t *testing.T) *testStub {
+	s := newFixture(t)
+	mustInvoke(t, s, "initOrganization", "org1", "顺达物流", "carrier")
+	mustInvoke(t, s, "setUserOrganization", "broker", "org1")
+	mustInvoke(t, s, "setUserOrganization", "driver", "org1")
+	s.caller = "broker"
+	mustInvoke(t, s, "initVehicle", "沪A12345", "25", "厢式货车", "org1")
+	mustInvoke(t, s, "assignVehicleDriver", "沪A12345", "driver")
+	s.caller = "admin"
+	return s
+}
+
+func TestOrganizationsAndVehicles(t *testing.T) {
+	s := newFleetFixture(t)
+	var org Organization
+	unmarshalPayload(t, mustInvoke(t, s, "readOrganization", "org1"), &org)
+	if org.OrgName != "顺达物流" || org.OrgType != "carrier" || org.CreatedAt != "2019-04-01T08:06:00Z" {
+		t.Errorf("unexpected organization %+v", org)
+	}
+	var vehicle Vehicle
+	unmarshalPayload(t, mustInvoke(t, s, "readVehicle", "沪A12345"), &vehicle)
+	if vehicle.CapacityTon != 25 || vehicle.OrgId != "org1" || len(vehicle.DriverIds) != 1 || vehicle.DriverIds[0] != "driver" {
+		t.Errorf("unexpected vehicle %+v", vehicle)
+	}
+	if user := readUser(t, s, "driver"); user.OrgId != "org1" {
+		t.Errorf("unexpected driver %+v", user)
+	}
+
+	expectError(t, s, "This organization already exists", "initOrganization", "org1", "x", "carrier")
+	expectError(t, s, "orgType must be carrier, broker or shipper", "initOrganization", "org2", "x", "fleet")
+	expectError(t, s, "This vehicle already exists", "initVehicle", "沪A12345", "25", "厢式货车", "org1")
+	expectError(t, s, "2nd argument must be a positive number", "initVehicle", "沪B1", "0", "厢式货车", "org1")
+	expectError(t, s, "Organization does not exist", "initVehicle", "沪B1", "10", "厢式货车", "org2")
+	expectError(t, s, "User owner is goodsOwner, not a driver", "assignVehicleDriver", "沪A12345", "owner")
+	mustInvoke(t, s, "initUser", "driver2", "司机", "driver", "13800000005", "true")
+	expectError(t, s, "Driver driver2 is not a member of org1", "assignVehicleDriver", "沪A12345", "driver2")
+	expectError(t, s, "Driver driver is assigned to vehicles [沪A12345] of org1", "setUserOrganization", "driver", "")
+	expectError(t, s, "Driver driver2 is not assigned to vehicle 沪A12345", "unassignVehicleDriver", "沪A12345", "driver2")
+
+	mustInvoke(t, s, "unassignVehicleDriver", "沪A12345", "driver")
+	mustInvoke(t, s, "setUserOrganization", "driver", "")
+	if user := readUser(t, s, "driver"); user.OrgId != "" {
+		t.Errorf("driver should have left org1, got %+v", user)
+	}
+}
+
+func TestFleetAccessRights(t *testing.T) {
+	s := newFleetFixture(t)
+	mustInvoke(t, s, "initOrganization", "org2", "远通运输", "carrier")
+	s.caller = "broker"
+	expectError(t, s, "Only an admin can create organizations", "initOrganization", "org3", "x", "carrier")
+	expectError(t, s, "Only an admin can place users in organizations", "setUserOrganization", "owner", "org1")
+	expectError(t, s, "Only an admin or a broker of org2 can manage its fleet", "initVehicle", "沪B1", "10", "厢式货车", "org2")
+	expectError(t, s, "User broker cannot read organization org2", "queryOrganizationVehicles", "org2")
+	s.caller = "driver"
+	expectError(t, s, "Only an admin or a broker of org1 can manage its fleet", "unassignVehicleDriver", "沪A12345", "driver")
+	mustInvoke(t, s, "queryOrganizationVehicles", "org1")
+	s.caller = "arbitrator"
+	mustInvoke(t, s, "queryOrganizationDrivers", "org2")
+}
+
+func TestOrderVehicleCapacity(t *testing.T) {
+	s := newFleetFixture(t)
+	expectError(t, s, "Vehicle 沪A12345 carries 25 tons, the order weighs 30",
+		"initOrder", "order1", "上海", "北京", "煤炭", "30", "4000", "WAIT_DRIVER_ACCEPT", "owner", "broker", "driver",
+		`{"vehiclePlate":"沪A12345"}`)
+	expectError(t, s, "Driver driver2 is not assigned to vehicle 沪A12345",
+		"initOrder", "order1", "上海", "北京", "煤炭", "20", "4000", "WAIT_DRIVER_ACCEPT", "owner", "broker", "driver2",
+		`{"vehiclePlate":"沪A12345"}`)
+	expectError(t, s, "Vehicle does not exist: 沪Z0",
+		"initOrder", "order1", "上海", "北京", "煤炭", "20", "4000", "WAIT_DRIVER_ACCEPT", "owner", "broker", "driver",
+		`{"vehiclePlate":"沪Z0"}`)
+	mustInvoke(t, s, "initOrder", "order1", "上海", "北京", "煤炭", "25", "4000", "WAIT_DRIVER_ACCEPT", "owner", "broker", "driver",
+		`{"vehiclePlate":"沪A12345"}`)
+	if order := readOrder(t, s, "order1"); order.VehiclePlate != "沪A12345" {
+		t.Errorf("unexpected order vehicle %q", order.VehiclePlate)
+	}
+}
+
+func TestOrganizationListings(t *testing.T) {
+	s := newFleetFixture(t)
+	mustInvoke(t, s, "initUser", "driver2", "司机", "driver", "13800000005", "true")
+	mustInvoke(t, s, "setUserOrganization", "driver2", "org1")
+	mustInvoke(t, s, "initVehicle", "沪A00001", "40", "平板车", "org1")
+	mustInvoke(t, s, "initUser", "broker2", "承运人", "broker", "13800000006", "true")
+	createOrder(t, s, "order1")
+	mustInvoke(t, s, "initOrder", "order2", "上海", "北京", "煤炭", "20", "4000", "WAIT_DRIVER_ACCEPT", "owner", "broker2", "driver2")
+	mustInvoke(t, s, "initOrder", "order3", "上海", "北京", "煤炭", "20", "4000", "WAIT_DRIVER_ACCEPT", "owner", "broker2", "nobody")
+
+	var drivers []QueryRecord
+	unmarshalPage(t, mustInvoke(t, s, "queryOrganizationDrivers", "org1"), &drivers)
+	if len(drivers) != 2 || drivers[0].Key != "driver" || drivers[1].Key != "driver2" {
+		t.Errorf("unexpected drivers %+v", drivers)
+	}
+	var vehicles []QueryRecord
+	unmarshalPage(t, mustInvoke(t, s, "queryOrganizationVehicles", "org1", "1", ""), &vehicles)
+	if len(vehicles) != 1 || vehicles[0].Key != "沪A00001" {
+		t.Errorf("unexpected first page of vehicles %+v", vehicles)
+	}
+	unmarshalPage(t, mustInvoke(t, s, "queryOrganizationVehicles", "org1", "1", "沪A00001"), &vehicles)
+	if len(vehicles) != 1 || vehicles[0].Key != "沪A12345" {
+		t.Errorf("unexpected second page of vehicles %+v", vehicles)
+	}
+	var orders []OrderWithKey
+	unmarshalPage(t, mustInvoke(t, s, "queryOrganizationOrders", "org1"), &orders)
+	if len(orders) != 2 || orders[0].Key != "order1" || orders[1].Key != "order2" {
+		t.Errorf("unexpected organization orders %+v", orders)
+	}
+	expectError(t, s, "Incorrect number of arguments", "queryOrganizationOrders")
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ====GOODS CATALOG (CLI) =================================================================
+// 登记货物(仅管理员) peer chaincode invoke -C myc1 -n orders -c '{"Args":["putGoods","COAL","煤炭","吨",""]}'
+// 登记危险品(仅管理员) peer chaincode invoke -C myc1 -n orders -c '{"Args":["putGoods","GASOLINE","汽油","吨","3","UN1203"]}'
+// 查询货物 peer chaincode query -C myc1 -n orders -c '{"Args":["readGoods","GASOLINE"]}'
+// 货物目录 peer chaincode query -C myc1 -n orders -c '{"Args":["queryGoods","10",""]}'
+// 按目录下单 peer chaincode invoke -C myc1 -n orders -c '{"Args":["initOrder","orderId0","上海","北京","汽油","20","4000","WAIT_DRIVER_ACCEPT","goodsOwnerId","brokerId0","driverId","{\"goodsCode\":\"GASOLINE\"}"]}'
+// 登记危险品从业资格(仅管理员) peer chaincode invoke -C myc1 -n orders -c '{"Args":["setDriverCredential","driverId","2025-12-31T00:00:00+08:00","3,8"]}' --transient "{\"credentialNo\":\"$(echo -n 310101198001010011 | base64 -w0)\"}"
+// 查询从业资格证号 peer chaincode query -C myc1 -n orders -c '{"Args":["readDriverCredentialNo","driverId"]}'
+// 上传安全技术说明书 peer chaincode invoke -C myc1 -n orders -c '{"Args":["attachSafetyDocument","orderId0","9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08","https://example.com/msds.pdf"]}'
+//
+// Admins keep a catalog of goods (goods: goodsCode) with a name, a unit and, for dangerous
+// goods, a hazard class and UN number. An order references the catalog with the goodsCode
+// option and keeps the hazard class the goods had; content stays its free-text
+// description, and orders without a goods code are as before. Rate cards match a category
+// against the goods code as well as the content.
+//
+// A hazardous order goes on the road only when its driver holds a dangerous goods
+// credential valid at that time and covering its class, a class like 2 covering 2.1, and
+// the goods owner or broker has attached the hash of the safety data sheet.
+//
+// The hazard classes and validity of a credential are kept on the driver, where the check
+// reads them. Its number, usually the driver's national ID, is kept in the driverCredentials
+// private collection (driverCredential: driverId), passed in the transient field
+// "credentialNo" so that it stays out of the transaction; admins and the driver read it.
+// =========================================================================================
+
+const (
+	goodsIndex                 = "goods"
+	driverCredentialCollection = "driverCredentials"
+)
+
+// hazardClassPattern accepts the dangerous goods classes and divisions, 1 to 9 and 1.1 to 9.6
+var hazardClassPattern = regexp.MustCompile(`^[1-9](\.[1-6])?$`)
+
+func goodsKey(stub shim.ChaincodeStubInterface, goodsCode string) (string, error) {
+	return stub.CreateCompositeKey(goodsIndex, []string{goodsCode})
+}
+
+func driverCredentialKey(stub shim.ChaincodeStubInterface, driverId string) (string, error) {
+	return stub.CreateCompositeKey("driverCredential", []string{driverId})
+}
+
+func getGoods(stub shim.ChaincodeStubInterface, goodsCode string) (*Goods, error) {
+	key, err := goodsKey(stub, goodsCode)
+	if err != nil {
+		return nil, err
+	}
+	goodsAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get goods: %s", err.Error())
+	} else if goodsAsBytes == nil {
+		return nil, fmt.Errorf("Goods do not exist: %s", goodsCode)
+	}
+	goods := &Goods{}
+	err = json.Unmarshal(goodsAsBytes, goods)
+	if err != nil {
+		return nil, err
+	}
+	return goods, nil
+}
+
+// setOrderGoods links a new order to the catalog, keeping the hazard class of its goods
+func setOrderGoods(stub shim.ChaincodeStubInterface, order *Order, goodsCode string) error {
+	if goodsCode == "" {
+		return nil
+	}
+	goods, err := getGoods(stub, goodsCode)
+	if err != nil {
+		return err
+	}
+	order.GoodsCode = goods.GoodsCode
+	order.HazardClass = goods.HazardClass
+	return nil
+}
+
+// coversHazardClass reports whether a credential covers a class; an empty list covers
+// every class and a class covers its divisions
+func coversHazardClass(credential *DriverCredential, hazardClass string) bool {
+	if len(credential.HazardClasses) == 0 {
+		return true
+	}
+	for _, covered := range credential.HazardClasses {
+		if covered == hazardClass || strings.HasPrefix(hazardClass, covered+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// checkHazardClearance refuses to put a hazardous order on the road without a safety
+// document and a driver allowed to carry its goods
+func checkHazardClearance(stub shim.ChaincodeStubInterface, order *Order) error {
+	if order.HazardClass == "" {
+		return nil
+	}
+	if order.SafetyDocument == nil {
+		return fmt.Errorf("Order %s carries class %s goods and needs a safety document first", order.OrderId, order.HazardClass)
+	}
+	driver, err := getUser(stub, order.DriverId)
+	if err != nil {
+		return err
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return err
+	}
+	credential := driver.DangerousGoodsCredential
+	if credential == nil || credential.ValidTo <= txTime.Format(time.RFC3339) {
+		return fmt.Errorf("Driver %s has no valid dangerous goods credential", driver.UserId)
+	} else if !coversHazardClass(credential, order.HazardClass) {
+		return fmt.Errorf("The credential of driver %s does not cover class %s", driver.UserId, order.HazardClass)
+	}
+	return nil
+}
+
+// ============================================================
+// putGoods - add or change an entry of the goods catalog
+// ============================================================
+func (t *SimpleChaincode) putGoods(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0       		1		2		3		4 (optional)
+	// "GASOLINE", "汽油", "吨", "3", "UN1203"
+	if len(args) != 4 && len(args) != 5 {
+		return shim.Error("Incorrect number of arguments. Expecting 4 or 5")
+	}
+	for i, arg := range args[:3] {
+		if len(arg) <= 0 {
+			return shim.Error(fmt.Sprintf("Argument %d must be a non-empty string", i+1))
+		}
+	}
+	if args[3] != "" && !hazardClassPattern.MatchString(args[3]) {
+		return shim.Error("4th argument must be empty or a hazard class like 3 or 2.1")
+	}
+	goods := &Goods{ObjectType: "goods", GoodsCode: args[0], Name: args[1], Unit: args[2], HazardClass: args[3]}
+	if len(args) == 5 {
+		if goods.HazardClass == "" && args[4] != "" {
+			return shim.Error("Only hazardous goods have a UN number")
+		}
+		goods.UnNumber = args[4]
+	}
+	caller, err := getCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !isAdmin(caller) {
+		return shim.Error("Only an admin can change the goods catalog, " + caller.UserId + " is " + caller.Role)
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	goods.UpdatedBy = caller.UserId
+	goods.UpdatedAt = txTime.Format(time.RFC3339)
+	key, err := goodsKey(stub, goods.GoodsCode)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	goodsAsBytes, err := json.Marshal(goods)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(key, goodsAsBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ============================================================
+// readGoods - read an entry of the goods catalog
+// ============================================================
+func (t *SimpleChaincode) readGoods(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0
+	// "GASOLINE"
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	_, err := getCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	goods, err := getGoods(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	goodsAsBytes, err := json.Marshal(goods)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(goodsAsBytes)
+}
+
+// ============================================================
+// queryGoods - list the goods catalog by code
+// ============================================================
+func (t *SimpleChaincode) queryGoods(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0		1
+	// ["10", "bookmark"]
+	pageSize, bookmark, err := parsePaginationArgs(args, 0)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	_, err = getCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(goodsIndex, []string{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+	var records []QueryRecord
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		records = append(records, QueryRecord{queryResponse.Key, queryResponse.Value})
+	}
+	page, err := pageOfRecords(records, pageSize, bookmark)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	pageAsBytes, err := json.Marshal(page)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(pageAsBytes)
+}
+
+// ============================================================
+// setDriverCredential - record a driver's dangerous goods credential
+// ============================================================
+func (t *SimpleChaincode) setDriverCredential(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0       		1							2 (optional)
+	// "driverId", "2025-12-31T00:00:00+08:00", "3,8", transient "credentialNo": "310101198001010011"
+	if len(args) != 2 && len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 2 or 3")
+	}
+	validTo, err := time.Parse(time.RFC3339, args[1])
+	if err != nil {
+		return shim.Error("2nd argument must be an RFC3339 time")
+	}
+	credential := &DriverCredential{ValidTo: validTo.UTC().Format(time.RFC3339)}
+	if len(args) == 3 && args[2] != "" {
+		for _, hazardClass := range strings.Split(args[2], ",") {
+			hazardClass = strings.TrimSpace(hazardClass)
+			if !hazardClassPattern.MatchString(hazardClass) {
+				return shim.Error("3rd argument must list hazard classes like 3,8 or 2.1, got " + hazardClass)
+			}
+			credential.HazardClasses = append(credential.HazardClasses, hazardClass)
+		}
+	}
+	caller, err := getCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !isAdmin(caller) {
+		return shim.Error("Only an admin can record credentials, " + caller.UserId + " is " + caller.Role)
+	}
+	driver, err := getUser(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if driver.Role != "driver" {
+		return shim.Error("User " + driver.UserId + " is " + driver.Role + ", not a driver")
+	}
+	transient, err := stub.GetTransient()
+	if err != nil {
+		return shim.Error("Failed to get transient data: " + err.Error())
+	}
+	credentialNo := string(transient["credentialNo"])
+	if credentialNo == "" {
+		return shim.Error("The credential number must be passed in the transient field credentialNo")
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	credential.RecordedBy = caller.UserId
+	credential.RecordedAt = txTime.Format(time.RFC3339)
+	driver.DangerousGoodsCredential = credential
+	err = putUser(stub, driver)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	number := &DriverCredentialNo{ObjectType: "driverCredentialNo", DriverId: driver.UserId, CredentialNo: credentialNo,
+		RecordedBy: credential.RecordedBy, RecordedAt: credential.RecordedAt}
+	numberAsBytes, err := json.Marshal(number)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	key, err := driverCredentialKey(stub, driver.UserId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutPrivateData(driverCredentialCollection, key, numberAsBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ============================================================
+// readDriverCredentialNo - read the number of a driver's dangerous goods credential
+// ============================================================
+func (t *SimpleChaincode) readDriverCredentialNo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0
+	// "driverId"
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	driverId, err := actingUserId(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	key, err := driverCredentialKey(stub, driverId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	numberAsBytes, err := stub.GetPrivateData(driverCredentialCollection, key)
+	if err != nil {
+		return shim.Error("Failed to get credential number: " + err.Error())
+	} else if numberAsBytes == nil {
+		return shim.Error("Driver " + driverId + " has no credential number")
+	}
+	return shim.Success(numberAsBytes)
+}
+
+// ============================================================
+// attachSafetyDocument - attach the hash of the safety data sheet to an order
+// ============================================================
+func (t *SimpleChaincode) attachSafetyDocument(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0       		1					2
+	// "orderId0", "sha256 hex", "https://example.com/msds.pdf"
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	digest, err := hex.DecodeString(args[1])
+	if err != nil || len(digest) != 32 {
+		return shim.Error("2nd argument must be a hex SHA-256 hash")
+	}
+	caller, err := getCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	order, err := getOrder(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !isAdmin(caller) && caller.UserId != order.GoodsOwnerId && caller.UserId != order.BrokerId {
+		return shim.Error("Only the goods owner or broker of order " + order.OrderId + " can attach its safety document")
+	}
+	if orderStage[order.OrderState] >= orderStage["DRIVER_ON_ROAD"] || !order.Open {
+		return shim.Error("Order " + order.OrderId + " is " + order.OrderState + ", the safety document can no longer change")
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	order.SafetyDocument = &SafetyDocument{Sha256: strings.ToLower(args[1]), DataUrl: args[2],
+		SubmittedBy: caller.UserId, SubmittedAt: txTime.Format(time.RFC3339)}
+	err = putOrder(stub, order)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
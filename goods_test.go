@@ -0,0 +1,128 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const testSafetyHash = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+
+func newGoodsFixture(t *testing.T) *testStub {
+	s := newFixture(t)
+	mustInvoke(t, s, "putGoods", "GASOLINE", "汽油", "吨", "3", "UN1203")
+	mustInvoke(t, s, "putGoods", "COAL", "煤炭", "吨", "")
+	return s
+}
+
+func TestHazardousOrderNeedsCredentialAndSafetyDocument(t *testing.T) {
+	s := newGoodsFixture(t)
+	mustInvoke(t, s, "initOrder", "order1", "上海", "北京", "汽油", "20", "4000", "WAIT_DRIVER_ACCEPT", "owner", "broker", "driver",
+		`{"goodsCode":"GASOLINE"}`)
+	if order := readOrder(t, s, "order1"); order.GoodsCode != "GASOLINE" || order.HazardClass != "3" || order.Content != "汽油" {
+		t.Errorf("unexpected goods of %+v", order)
+	}
+	advanceOrder(t, s, "order1", "DRIVER_ACCEPT_WAIT_ROAD")
+	expectError(t, s, "Order order1 carries class 3 goods and needs a safety document first", "changeStateOrder", "order1", "DRIVER_ON_ROAD")
+
+	s.caller = "driver"
+	expectError(t, s, "Only the goods owner or broker of order order1", "attachSafetyDocument", "order1", testSafetyHash, "https://example.com/msds.pdf")
+	s.caller = "owner"
+	expectError(t, s, "2nd argument must be a hex SHA-256 hash", "attachSafetyDocument", "order1", "9f86d0", "https://example.com/msds.pdf")
+	mustInvoke(t, s, "attachSafetyDocument", "order1", testSafetyHash, "https://example.com/msds.pdf")
+	if document := readOrder(t, s, "order1").SafetyDocument; document == nil || document.Sha256 != testSafetyHash || document.SubmittedBy != "owner" {
+		t.Errorf("unexpected safety document %+v", document)
+	}
+
+	s.caller = "admin"
+	expectError(t, s, "Driver driver has no valid dangerous goods credential", "changeStateOrder", "order1", "DRIVER_ON_ROAD")
+	expectError(t, s, "The credential number must be passed in the transient field credentialNo",
+		"setDriverCredential", "driver", "2020-04-01T00:00:00+08:00")
+	s.transient = map[string][]byte{"credentialNo": []byte("310101198001010011")}
+	mustInvoke(t, s, "setDriverCredential", "driver", "2019-04-01T08:00:00Z")
+	expectError(t, s, "Driver driver has no valid dangerous goods credential", "changeStateOrder", "order1", "DRIVER_ON_ROAD")
+	mustInvoke(t, s, "setDriverCredential", "driver", "2020-04-01T00:00:00+08:00", "8")
+	expectError(t, s, "The credential of driver driver does not cover class 3", "changeStateOrder", "order1", "DRIVER_ON_ROAD")
+	mustInvoke(t, s, "setDriverCredential", "driver", "2020-04-01T00:00:00+08:00", "3, 8")
+	s.transient = nil
+	if credential := readUser(t, s, "driver").DangerousGoodsCredential; credential == nil || len(credential.HazardClasses) != 2 ||
+		credential.ValidTo != "2020-03-31T16:00:00Z" || credential.RecordedBy != "admin" {
+		t.Errorf("unexpected credential %+v", credential)
+	}
+	for key, value := range s.State {
+		if strings.Contains(string(value), "310101198001010011") {
+			t.Errorf("the credential number must stay out of the world state, found under %q", key)
+		}
+	}
+	s.caller = "broker"
+	expectError(t, s, "User broker cannot act as driver", "readDriverCredentialNo", "driver")
+	s.caller = "driver"
+	var number DriverCredentialNo
+	unmarshalPayload(t, mustInvoke(t, s, "readDriverCredentialNo", "driver"), &number)
+	if number.CredentialNo != "310101198001010011" || number.RecordedBy != "admin" {
+		t.Errorf("unexpected credential number %+v", number)
+	}
+	s.caller = "admin"
+	expectError(t, s, "Driver broker has no credential number", "readDriverCredentialNo", "broker")
+	advanceOrder(t, s, "order1", "DRIVER_ON_ROAD")
+	expectError(t, s, "the safety document can no longer change", "attachSafetyDocument", "order1", testSafetyHash, "https://example.com/msds.pdf")
+
+	expectError(t, s, "needs a safety document first", "initOrder",
+		"order2", "上海", "北京", "汽油", "20", "4000", "DRIVER_ON_ROAD", "owner", "broker", "driver", `{"goodsCode":"GASOLINE"}`)
+	expectError(t, s, "Goods do not exist: DIESEL", "initOrder",
+		"order2", "上海", "北京", "柴油", "20", "4000", "WAIT_DRIVER_ACCEPT", "owner", "broker", "driver", `{"goodsCode":"DIESEL"}`)
+	mustInvoke(t, s, "initOrder", "order2", "上海", "北京", "煤炭", "20", "4000", "WAIT_DRIVER_ACCEPT", "owner", "broker", "driver",
+		`{"goodsCode":"COAL"}`)
+	mustInvoke(t, s, "initUser", "driver2", "司机2", "driver", "13800000005", "true")
+	mustInvoke(t, s, "initOrder", "order3", "上海", "北京", "煤炭", "20", "4000", "WAIT_DRIVER_ACCEPT", "owner", "broker", "driver2")
+	advanceOrder(t, s, "order2", "DRIVER_ACCEPT_WAIT_ROAD", "DRIVER_ON_ROAD")
+	advanceOrder(t, s, "order3", "DRIVER_ACCEPT_WAIT_ROAD", "DRIVER_ON_ROAD")
+}
+
+func TestGoodsCatalog(t *testing.T) {
+	s := newGoodsFixture(t)
+	mustInvoke(t, s, "putGoods", "COAL", "动力煤", "吨", "")
+	var goods Goods
+	unmarshalPayload(t, mustInvoke(t, s, "readGoods", "COAL"), &goods)
+	if goods.Name != "动力煤" || goods.HazardClass != "" || goods.UpdatedBy != "admin" {
+		t.Errorf("unexpected goods %+v", goods)
+	}
+	var catalog []struct {
+		Key    string
+		Record Goods
+	}
+	unmarshalPage(t, mustInvoke(t, s, "queryGoods"), &catalog)
+	if len(catalog) != 2 || catalog[0].Record.GoodsCode != "COAL" || catalog[1].Record.UnNumber != "UN1203" {
+		t.Errorf("unexpected catalog %+v", catalog)
+	}
+
+	expectError(t, s, "4th argument must be empty or a hazard class", "putGoods", "X", "x", "吨", "10")
+	expectError(t, s, "Only hazardous goods have a UN number", "putGoods", "X", "x", "吨", "", "UN1203")
+	expectError(t, s, "Argument 3 must be a non-empty string", "putGoods", "X", "x", "", "")
+	expectError(t, s, "User owner is goodsOwner, not a driver", "setDriverCredential", "owner", "2020-01-01T00:00:00Z")
+	expectError(t, s, "3rd argument must list hazard classes", "setDriverCredential", "driver", "2020-01-01T00:00:00Z", "3,x")
+	expectError(t, s, "2nd argument must be an RFC3339 time", "setDriverCredential", "driver", "2020-01-01")
+	s.caller = "broker"
+	expectError(t, s, "Only an admin can change the goods catalog", "putGoods", "X", "x", "吨", "")
+	expectError(t, s, "Only an admin can record credentials", "setDriverCredential", "driver", "2020-01-01T00:00:00Z")
+
+	credential := &DriverCredential{HazardClasses: []string{"2", "6.1"}}
+	for hazardClass, covered := range map[string]bool{"2": true, "2.1": true, "6.1": true, "6.2": false, "3": false} {
+		if coversHazardClass(credential, hazardClass) != covered {
+			t.Errorf("coverage of class %s should be %v", hazardClass, covered)
+		}
+	}
+}
+
+func TestRateCardMatchesGoodsCode(t *testing.T) {
+	s := newGoodsFixture(t)
+	s.caller = "broker"
+	mustInvoke(t, s, "putRateCard", "", `{"validFrom":"2019-04-01T00:00:00Z","rates":[
+		{"originRegion":"上海","destinationRegion":"北京","pricePerTon":200},
+		{"originRegion":"上海","destinationRegion":"北京","category":"GASOLINE","pricePerTon":300}]}`)
+	s.caller = "admin"
+	mustInvoke(t, s, "initOrder", "order1", "上海", "北京", "92号汽油", "20", "auto", "WAIT_DRIVER_ACCEPT", "owner", "broker", "driver",
+		`{"goodsCode":"GASOLINE"}`)
+	if order := readOrder(t, s, "order1"); order.TransFee != 6000 {
+		t.Errorf("the goods code rate should apply, got %v", order.TransFee)
+	}
+}
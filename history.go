@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ====HISTORY (CLI) =======================================================================
+// 查询某时刻的运单 peer chaincode query -C myc1 -n orders -c '{"Args":["getOrderAsOf","order1","2019-04-01T12:00:00+08:00"]}'
+// 连同证据和位置 peer chaincode query -C myc1 -n orders -c '{"Args":["getOrderAsOf","order1","2019-04-01T12:00:00+08:00","evidence,position"]}'
+//
+// The history of an order is read with GetHistoryForKey, which returns the versions of a key
+// oldest first on Fabric 1.4. Each version is decoded and compared with the one before it,
+// field by field as stored in JSON. getOrderAsOf picks the last version written at or before
+// an instant, and does the same for the hashes, files, dispute, weighbridge readings and
+// positions of the order. Those are found through rich queries by orderId, so they are only
+// available on CouchDB.
+// =========================================================================================
+
+// diffOrderFields lists the top-level fields that differ between two decoded versions
+func diffOrderFields(previous map[string]interface{}, current map[string]interface{}) []FieldChange {
+	fields := make([]string, 0, len(current))
+	for field := range current {
+		fields = append(fields, field)
+	}
+	for field := range previous {
+		if _, ok := current[field]; !ok {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+
+	changes := []FieldChange{}
+	for _, field := range fields {
+		if !reflect.DeepEqual(previous[field], current[field]) {
+			changes = append(changes, FieldChange{field, previous[field], current[field]})
+		}
+	}
+	return changes
+}
+
+// readOrderHistory returns every version of an order, oldest first
+func readOrderHistory(stub shim.ChaincodeStubInterface, orderId string) ([]OrderHistoryEntry, error) {
+	resultsIterator, err := stub.GetHistoryForKey(orderId)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	history := []OrderHistoryEntry{}
+	var previous map[string]interface{}
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		entry := OrderHistoryEntry{TxId: response.TxId, IsDelete: response.IsDelete, Changes: []FieldChange{}}
+		if response.Timestamp != nil {
+			entry.Timestamp = historyTimestamp(response).Format(time.RFC3339Nano)
+		}
+		if response.IsDelete {
+			previous = nil
+			history = append(history, entry)
+			continue
+		}
+		entry.Order = &Order{}
+		err = json.Unmarshal(response.Value, entry.Order)
+		if err != nil {
+			return nil, err
+		}
+		var current map[string]interface{}
+		err = json.Unmarshal(response.Value, &current)
+		if err != nil {
+			return nil, err
+		}
+		if previous != nil {
+			entry.Changes = diffOrderFields(previous, current)
+		}
+		previous = current
+		history = append(history, entry)
+	}
+	return history, nil
+}
+
+func historyTimestamp(modification *queryresult.KeyModification) time.Time {
+	if modification.Timestamp == nil {
+		return time.Time{}
+	}
+	return time.Unix(modification.Timestamp.Seconds, int64(modification.Timestamp.Nanos)).UTC()
+}
+
+// versionAsOf returns the last version of a key written at or before an instant, nil if the
+// key did not exist or had been deleted by then
+func versionAsOf(stub shim.ChaincodeStubInterface, key string, at time.Time) (*queryresult.KeyModification, error) {
+	resultsIterator, err := stub.GetHistoryForKey(key)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var version *queryresult.KeyModification
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		if historyTimestamp(response).After(at) {
+			break
+		}
+		version = response
+	}
+	if version != nil && version.IsDelete {
+		return nil, nil
+	}
+	return version, nil
+}
+
+// recordsAsOf finds the records of a docType attached to an order and decodes each as it
+// stood at an instant into newRecord(), skipping those that did not exist yet. It returns
+// the write time of each record kept.
+func recordsAsOf(stub shim.ChaincodeStubInterface, docType string, orderId string, at time.Time, newRecord func() interface{}) ([]time.Time, error) {
+	queryResults, err := getQueryResultForSelector(stub, map[string]interface{}{"docType": docType, "orderId": orderId})
+	if err != nil {
+		return nil, err
+	}
+	var records []QueryRecord
+	err = json.Unmarshal(queryResults, &records)
+	if err != nil {
+		return nil, err
+	}
+	var writtenAt []time.Time
+	for _, record := range records {
+		version, err := versionAsOf(stub, record.Key, at)
+		if err != nil {
+			return nil, err
+		} else if version == nil {
+			continue
+		}
+		err = json.Unmarshal(version.Value, newRecord())
+		if err != nil {
+			return nil, err
+		}
+		writtenAt = append(writtenAt, historyTimestamp(version))
+	}
+	return writtenAt, nil
+}
+
+// evidenceAsOf fills in the hashes, files, dispute and weighbridge readings of an order
+func evidenceAsOf(stub shim.ChaincodeStubInterface, asOf *OrderAsOf, at time.Time) error {
+	_, err := recordsAsOf(stub, "stringHash", asOf.OrderId, at, func() interface{} {
+		asOf.String = append(asOf.String, StringHash{})
+		return &asOf.String[len(asOf.String)-1]
+	})
+	if err != nil {
+		return err
+	}
+	_, err = recordsAsOf(stub, "fileHashForOrder", asOf.OrderId, at, func() interface{} {
+		asOf.File = append(asOf.File, FileHash{})
+		return &asOf.File[len(asOf.File)-1]
+	})
+	if err != nil {
+		return err
+	}
+
+	key, err := disputeKey(stub, asOf.OrderId)
+	if err != nil {
+		return err
+	}
+	version, err := versionAsOf(stub, key, at)
+	if err != nil {
+		return err
+	} else if version != nil {
+		asOf.Dispute = &Dispute{}
+		err = json.Unmarshal(version.Value, asOf.Dispute)
+		if err != nil {
+			return err
+		}
+	}
+	key, err = weightKey(stub, asOf.OrderId)
+	if err != nil {
+		return err
+	}
+	version, err = versionAsOf(stub, key, at)
+	if err != nil {
+		return err
+	} else if version != nil {
+		asOf.Weight = &WeightReconciliation{}
+		return json.Unmarshal(version.Value, asOf.Weight)
+	}
+	return nil
+}
+
+// lastPositionAsOf fills in the position of an order reported last at or before an instant
+func lastPositionAsOf(stub shim.ChaincodeStubInterface, asOf *OrderAsOf, at time.Time) error {
+	var positions []UpdatePositionHistory
+	writtenAt, err := recordsAsOf(stub, "position", asOf.OrderId, at, func() interface{} {
+		positions = append(positions, UpdatePositionHistory{})
+		return &positions[len(positions)-1]
+	})
+	if err != nil {
+		return err
+	}
+	var latest time.Time
+	for i := range positions {
+		if asOf.LastPosition == nil || !writtenAt[i].Before(latest) {
+			asOf.LastPosition = &positions[i]
+			latest = writtenAt[i]
+		}
+	}
+	return nil
+}
+
+// ============================================================
+// getOrderAsOf - read an order as it stood at an instant, optionally with its evidence and
+// last known position
+// ============================================================
+func (t *SimpleChaincode) getOrderAsOf(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0			1							2
+	// "order1", "2019-04-01T12:00:00+08:00", ["evidence,position"]
+	if len(args) != 2 && len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 2 or 3")
+	}
+	orderId := args[0]
+	at, err := time.Parse(time.RFC3339, args[1])
+	if err != nil {
+		return shim.Error("Dates must be RFC3339, e.g. 2019-04-01T08:00:00+08:00: " + args[1])
+	}
+	include := map[string]bool{}
+	if len(args) == 3 && args[2] != "" {
+		for _, part := range strings.Split(args[2], ",") {
+			if part != "evidence" && part != "position" {
+				return shim.Error("Can only include evidence and position, got " + part)
+			}
+			include[part] = true
+		}
+	}
+
+	version, err := versionAsOf(stub, orderId, at)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if version == nil {
+		return shim.Error(fmt.Sprintf("Order %s did not exist at %s", orderId, args[1]))
+	}
+	asOf := &OrderAsOf{OrderId: orderId, AsOf: at.UTC().Format(time.RFC3339), TxId: version.TxId,
+		Timestamp: historyTimestamp(version).Format(time.RFC3339Nano), Order: &Order{}}
+	err = json.Unmarshal(version.Value, asOf.Order)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	caller, err := getCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !canReadOrder(caller, asOf.Order) {
+		return shim.Error("User " + caller.UserId + " cannot read order " + orderId)
+	}
+	if include["evidence"] {
+		err = evidenceAsOf(stub, asOf, at)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	if include["position"] {
+		err = lastPositionAsOf(stub, asOf, at)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	asOfAsBytes, err := json.Marshal(asOf)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(asOfAsBytes)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func readOrderAsOf(t *testing.T, s *testStub, args ...string) OrderAsOf {
+	t.Helper()
+	var asOf OrderAsOf
+	err := json.Unmarshal(mustInvoke(t, s, "getOrderAsOf", args...), &asOf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return asOf
+}
+
+func TestGetOrderAsOf(t *testing.T) {
+	s := newFixture(t)
+	createOrder(t, s, "order1")                                                                                // 08:06
+	mustInvoke(t, s, "initStringHash", "data1", "order1", "http://example.com/data1", "sha1", "装货照片")          // 08:07
+	mustInvoke(t, s, "updatePositionOrder", "position1", "order1", "1", "Mon, 01 Apr 2019 08:08:00 UTC", "上海") // 08:08
+	advanceOrder(t, s, "order1", "DRIVER_ACCEPT_WAIT_ROAD")                                                    // 08:09
+	mustInvoke(t, s, "updatePositionOrder", "position2", "order1", "2", "Mon, 01 Apr 2019 08:10:00 UTC", "南京") // 08:10
+	mustInvoke(t, s, "initStringHash", "data2", "order1", "http://example.com/data2", "sha2", "卸货照片")          // 08:11
+
+	var history []struct {
+		Record OrderHistoryEntry
+	}
+	unmarshalPage(t, mustInvoke(t, s, "getHistoryForOrder", "order1"), &history)
+
+	asOf := readOrderAsOf(t, s, "order1", "2019-04-01T16:08:30+08:00", "evidence,position")
+	if asOf.Order.OrderState != "WAIT_DRIVER_ACCEPT" || asOf.TxId != history[0].Record.TxId || asOf.AsOf != "2019-04-01T08:08:30Z" {
+		t.Errorf("unexpected version %+v", asOf)
+	}
+	if len(asOf.String) != 1 || asOf.String[0].DataId != "data1" || asOf.Dispute != nil {
+		t.Errorf("unexpected evidence %+v", asOf)
+	}
+	if asOf.LastPosition == nil || asOf.LastPosition.PositionId != "position1" {
+		t.Errorf("unexpected last position %+v", asOf.LastPosition)
+	}
+
+	asOf = readOrderAsOf(t, s, "order1", "2019-04-01T08:10:00Z", "position")
+	if asOf.Order.OrderState != "DRIVER_ACCEPT_WAIT_ROAD" || asOf.TxId != history[1].Record.TxId ||
+		asOf.LastPosition == nil || asOf.LastPosition.PositionId != "position2" || asOf.String != nil {
+		t.Errorf("unexpected version %+v", asOf)
+	}
+	if asOf := readOrderAsOf(t, s, "order1", "2019-04-01T08:10:00Z"); asOf.LastPosition != nil || asOf.String != nil {
+		t.Errorf("nothing should be included unless asked for, got %+v", asOf)
+	}
+
+	mustInvoke(t, s, "delete", "order1")
+	expectError(t, s, "did not exist at", "getOrderAsOf", "order1", "2019-04-01T10:00:00Z")
+	expectError(t, s, "did not exist at", "getOrderAsOf", "order1", "2019-04-01T08:05:00Z")
+	if asOf := readOrderAsOf(t, s, "order1", "2019-04-01T08:10:00Z"); asOf.Order.OrderState != "DRIVER_ACCEPT_WAIT_ROAD" {
+		t.Errorf("a deleted order should still be readable as of before the delete, got %+v", asOf)
+	}
+	expectError(t, s, "Can only include evidence and position", "getOrderAsOf", "order1", "2019-04-01T08:10:00Z", "files")
+	expectError(t, s, "RFC3339", "getOrderAsOf", "order1", "1554106200")
+	expectError(t, s, "Incorrect number of arguments", "getOrderAsOf", "order1")
+}
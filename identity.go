@@ -0,0 +1,254 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ====IDENTITIES (CLI) ====================================================================
+// 查询当前用户 peer chaincode query -C myc1 -n orders -c '{"Args":["whoAmI"]}'
+// 更换用户证书(仅管理员) peer chaincode invoke -C myc1 -n orders -c '{"Args":["rotateUserCertificate","driverId","Org1MSP","-----BEGIN CERTIFICATE-----\n...\n-----END CERTIFICATE-----"]}'
+//
+// A user is bound to the X.509 identity that registered it: the MSP ID and the certificate ID,
+// which like cid.GetID is made of the subject and issuer, so a renewed certificate keeps it.
+// The identity~user index (mspId, certId) resolves the creator of a transaction to its user,
+// so handlers take the acting user from the creator instead of from their arguments.
+//
+// Someone not yet registered registers themselves and is bound to their certificate; the
+// first admin registers this way too. Once an admin exists, admins register everyone else
+// and only admins may register admins or arbitrators. Users an admin registered, and users
+// registered before identities were bound, are identified by the userId attribute of their
+// certificate until an admin binds a certificate to them with rotateUserCertificate.
+// =========================================================================================
+
+const userIdentityIndex = "identity~user"
+
+// adminRegisteredKey is set once the first admin has registered
+const adminRegisteredKey = "adminRegistered"
+
+// certificateId identifies a certificate by its subject and issuer
+func certificateId(cert *x509.Certificate) string {
+	id := fmt.Sprintf("x509::%s::%s", cert.Subject.String(), cert.Issuer.String())
+	return base64.StdEncoding.EncodeToString([]byte(id))
+}
+
+func identityKey(stub shim.ChaincodeStubInterface, mspId string, certId string) (string, error) {
+	return stub.CreateCompositeKey(userIdentityIndex, []string{mspId, certId})
+}
+
+// creatorIdentity reads the MSP ID and certificate of the transaction creator
+func creatorIdentity(stub shim.ChaincodeStubInterface) (string, *x509.Certificate, error) {
+	mspId, err := cid.GetMSPID(stub)
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to read caller identity: %s", err.Error())
+	}
+	cert, err := cid.GetX509Certificate(stub)
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to read caller identity: %s", err.Error())
+	}
+	return mspId, cert, nil
+}
+
+// bindIdentity binds a user to a certificate, releasing the certificate it was bound to
+func bindIdentity(stub shim.ChaincodeStubInterface, user *User, mspId string, cert *x509.Certificate) error {
+	certId := certificateId(cert)
+	key, err := identityKey(stub, mspId, certId)
+	if err != nil {
+		return err
+	}
+	boundAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return err
+	} else if boundAsBytes != nil && string(boundAsBytes) != user.UserId {
+		return fmt.Errorf("Certificate %s of %s is already bound to user %s", cert.Subject.String(), mspId, string(boundAsBytes))
+	}
+	if user.CertId != "" {
+		previousKey, err := identityKey(stub, user.MspId, user.CertId)
+		if err != nil {
+			return err
+		}
+		err = stub.DelState(previousKey)
+		if err != nil {
+			return err
+		}
+	}
+	user.MspId = mspId
+	user.CertId = certId
+	user.CertSubject = cert.Subject.String()
+	return stub.PutState(key, []byte(user.UserId))
+}
+
+// registeredCaller returns the user that submitted the transaction, nil when the creator is
+// not bound to a user and names no registered user in its userId attribute
+func registeredCaller(stub shim.ChaincodeStubInterface) (*User, error) {
+	mspId, cert, err := creatorIdentity(stub)
+	if err != nil {
+		return nil, err
+	}
+	key, err := identityKey(stub, mspId, certificateId(cert))
+	if err != nil {
+		return nil, err
+	}
+	userIdAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	} else if userIdAsBytes != nil {
+		return getUser(stub, string(userIdAsBytes))
+	}
+
+	userId, found, err := cid.GetAttributeValue(stub, "userId")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read caller identity: %s", err.Error())
+	} else if !found {
+		return nil, nil
+	}
+	userAsBytes, err := stub.GetState(userId)
+	if err != nil {
+		return nil, err
+	} else if userAsBytes == nil {
+		return nil, nil
+	}
+	user := &User{}
+	err = json.Unmarshal(userAsBytes, user)
+	if err != nil {
+		return nil, err
+	}
+	if user.CertId != "" {
+		return nil, fmt.Errorf("User %s is bound to another certificate", userId)
+	}
+	return user, nil
+}
+
+// checkRegistrar decides whether the creator may register a user with a role. It returns
+// whether the new user registers themselves and should be bound to the creator.
+func checkRegistrar(stub shim.ChaincodeStubInterface, role string) (bool, error) {
+	registrar, err := registeredCaller(stub)
+	if err != nil {
+		return false, err
+	}
+	if registrar != nil {
+		if !isAdmin(registrar) || !registrar.Valid {
+			return false, fmt.Errorf("Only an admin can register other users, %s is %s", registrar.UserId, registrar.Role)
+		}
+		return false, nil
+	}
+	if role == "arbitrator" {
+		return false, fmt.Errorf("Only an admin can register arbitrators")
+	}
+	if role == "admin" {
+		adminRegistered, err := stub.GetState(adminRegisteredKey)
+		if err != nil {
+			return false, err
+		} else if adminRegistered != nil {
+			return false, fmt.Errorf("Only an admin can register admins")
+		}
+	}
+	return true, nil
+}
+
+// registerUser binds a self-registering user to the creator and records the first admin
+func registerUser(stub shim.ChaincodeStubInterface, user *User, selfRegistered bool) error {
+	if selfRegistered {
+		mspId, cert, err := creatorIdentity(stub)
+		if err != nil {
+			return err
+		}
+		err = bindIdentity(stub, user, mspId, cert)
+		if err != nil {
+			return err
+		}
+	}
+	if user.Role == "admin" {
+		return stub.PutState(adminRegisteredKey, []byte(user.UserId))
+	}
+	return nil
+}
+
+// ============================================================
+// whoAmI - read the user the submitting certificate belongs to
+// ============================================================
+func (t *SimpleChaincode) whoAmI(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 0 {
+		return shim.Error("Incorrect number of arguments. Expecting 0")
+	}
+	user, err := registeredCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if user == nil {
+		return shim.Error("Caller is not a registered user")
+	}
+	userAsBytes, err := json.Marshal(user)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(userAsBytes)
+}
+
+// ============================================================
+// rotateUserCertificate - bind a user to a new certificate
+// ============================================================
+func (t *SimpleChaincode) rotateUserCertificate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0       		1			2
+	// "driverId", "Org1MSP", "-----BEGIN CERTIFICATE-----..."
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	if len(args[1]) <= 0 {
+		return shim.Error("2nd argument must be a non-empty string")
+	}
+	caller, err := getCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !isAdmin(caller) {
+		return shim.Error(fmt.Sprintf("Only an admin can rotate certificates, %s is %s", caller.UserId, caller.Role))
+	}
+	block, _ := pem.Decode([]byte(args[2]))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return shim.Error("3rd argument must be a PEM encoded certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return shim.Error("Invalid certificate: " + err.Error())
+	}
+	user, err := getUser(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if userStatus(user) == "DEACTIVATED" {
+		return shim.Error("User " + user.UserId + " is DEACTIVATED")
+	}
+	err = bindIdentity(stub, user, args[1], cert)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = putUser(stub, user)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// actingUserId returns the user a handler acts for. Handlers that take the acting user as
+// an argument (a goods owner, a party, a recorder) treat an empty one as the caller, and
+// only let admins name another user.
+func actingUserId(stub shim.ChaincodeStubInterface, claimed string) (string, error) {
+	caller, err := getCaller(stub)
+	if err != nil {
+		return "", err
+	}
+	if claimed == "" || claimed == caller.UserId {
+		return caller.UserId, nil
+	}
+	if !isAdmin(caller) {
+		return "", fmt.Errorf("User %s cannot act as %s", caller.UserId, claimed)
+	}
+	return claimed, nil
+}
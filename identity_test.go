@@ -0,0 +1,107 @@
+package main
+
+import "testing"
+
+func whoAmI(t *testing.T, s *testStub) User {
+	t.Helper()
+	var user User
+	unmarshalPayload(t, mustInvoke(t, s, "whoAmI"), &user)
+	return user
+}
+
+func TestSelfRegistrationBindsTheCertificate(t *testing.T) {
+	s := newTestStub()
+	mustInvoke(t, s, "initUser", "admin", "管理员", "admin", "13800000000", "true")
+	if user := whoAmI(t, s); user.UserId != "admin" || user.MspId != "Org1MSP" ||
+		user.CertSubject != "CN=admin,O=org1.example.com" || user.CertId == "" {
+		t.Errorf("unexpected admin %+v", user)
+	}
+
+	// a certificate bound to a user identifies it whatever its userId attribute says
+	s.commonName = "admin"
+	s.caller = "owner"
+	if user := whoAmI(t, s); user.UserId != "admin" {
+		t.Errorf("the bound certificate should identify admin, got %+v", user)
+	}
+
+	s.commonName = "driver"
+	s.caller = "driver"
+	expectError(t, s, "Caller is not a registered user", "whoAmI")
+	expectError(t, s, "Only an admin can register admins", "initUser", "admin2", "管理员", "admin", "1", "true")
+	expectError(t, s, "Only an admin can register arbitrators", "initUser", "arb", "仲裁员", "arbitrator", "1", "true")
+	mustInvoke(t, s, "initUser", "driver", "司机", "driver", "13800000003", "true")
+	expectError(t, s, "Only an admin can register other users, driver is driver",
+		"initUser", "driver2", "司机", "driver", "13800000003", "true")
+	if user := whoAmI(t, s); user.UserId != "driver" || user.CertSubject != "CN=driver,O=org1.example.com" {
+		t.Errorf("unexpected driver %+v", user)
+	}
+
+	// the certificate of another user does not pass for a bound one, whatever it claims
+	s.commonName = "impostor"
+	expectError(t, s, "User driver is bound to another certificate", "queryRecords", `{"docType":"order"}`)
+}
+
+func TestAdminRegisteredUsersUseTheirAttribute(t *testing.T) {
+	s := newFixture(t)
+	s.caller = "broker"
+	if user := whoAmI(t, s); user.UserId != "broker" || user.CertId != "" {
+		t.Errorf("unexpected broker %+v", user)
+	}
+	mustInvoke(t, s, "updateUser", "broker", "李师傅", "broker", "13900000002")
+	expectError(t, s, "Only an admin can change the role of a user", "updateUser", "broker", "李师傅", "admin", "13900000002")
+	expectError(t, s, "User broker cannot update driver", "updateUser", "driver", "司机", "driver", "1")
+}
+
+func TestRotateUserCertificate(t *testing.T) {
+	s := newFixture(t)
+	newCert, err := certificateFor("driver-2020", "driver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.caller = "broker"
+	expectError(t, s, "Only an admin can rotate certificates", "rotateUserCertificate", "driver", "Org1MSP", string(newCert))
+	s.caller = "admin"
+	expectError(t, s, "3rd argument must be a PEM encoded certificate", "rotateUserCertificate", "driver", "Org1MSP", "x")
+	expectError(t, s, "User does not exist", "rotateUserCertificate", "missing", "Org1MSP", string(newCert))
+	mustInvoke(t, s, "rotateUserCertificate", "driver", "Org1MSP", string(newCert))
+	if user := readUser(t, s, "driver"); user.CertSubject != "CN=driver-2020,O=org1.example.com" || user.MspId != "Org1MSP" {
+		t.Errorf("unexpected rotated user %+v", user)
+	}
+	expectError(t, s, "is already bound to user driver", "rotateUserCertificate", "broker", "Org1MSP", string(newCert))
+
+	s.caller = "driver"
+	expectError(t, s, "User driver is bound to another certificate", "whoAmI")
+	s.commonName = "driver-2020"
+	if user := whoAmI(t, s); user.UserId != "driver" {
+		t.Errorf("the new certificate should identify driver, got %+v", user)
+	}
+
+	// rotating again releases the previous certificate
+	s.commonName, s.caller = "", "admin"
+	newerCert, err := certificateFor("driver-2021", "driver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustInvoke(t, s, "rotateUserCertificate", "driver", "Org1MSP", string(newerCert))
+	s.commonName, s.caller = "driver-2020", "driver"
+	expectError(t, s, "User driver is bound to another certificate", "whoAmI")
+}
+
+func TestHandlersActAsTheCaller(t *testing.T) {
+	s := newFixture(t)
+	createOrder(t, s, "order1")
+	s.caller = "broker"
+	expectError(t, s, "User broker cannot act as owner", "cancelOrder", "order1", "owner", "no longer needed")
+	s.caller = "owner"
+	mustInvoke(t, s, "cancelOrder", "order1", "", "no longer needed")
+	if order := readOrder(t, s, "order1"); order.Cancellation == nil || order.Cancellation.CancelledBy != "owner" {
+		t.Errorf("the caller should have cancelled the order, got %+v", order.Cancellation)
+	}
+
+	createOrder(t, s, "order2")
+	s.caller = "driver"
+	expectError(t, s, "User driver cannot act as arbitrator", "resolveDispute", "order2", "arbitrator", "0", "x")
+	expectError(t, s, "User driver cannot act as owner", "openDispute", "order2", "owner", "短重", "")
+	expectError(t, s, "User driver cannot act as owner", "resolveWeightFlag", "order2", "owner", "ok")
+	expectError(t, s, "User driver cannot act as broker", "respondDispute", "order2", "broker", "x", "")
+}
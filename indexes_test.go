@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const indexDir = "META-INF/statedb/couchdb/indexes"
+
+type couchIndex struct {
+	Index struct {
+		Fields []interface{} `json:"fields"`
+	} `json:"index"`
+	Ddoc string `json:"ddoc"`
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// fields lists the fields of an index, given as "field" or {"field":"desc"}
+func (index *couchIndex) fields() []string {
+	var fields []string
+	for _, field := range index.Index.Fields {
+		switch field := field.(type) {
+		case string:
+			fields = append(fields, field)
+		case map[string]interface{}:
+			for name := range field {
+				fields = append(fields, name)
+			}
+		}
+	}
+	return fields
+}
+
+func loadIndexes() ([]*couchIndex, error) {
+	files, err := filepath.Glob(filepath.Join(indexDir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var indexes []*couchIndex
+	for _, file := range files {
+		indexAsBytes, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		index := &couchIndex{}
+		err = json.Unmarshal(indexAsBytes, index)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", file, err.Error())
+		}
+		if index.Type != "json" || index.Name == "" || index.Ddoc == "" || len(index.fields()) == 0 {
+			return nil, fmt.Errorf("%s is not a complete json index definition", file)
+		}
+		if strings.TrimSuffix(filepath.Base(file), ".json") != index.Name {
+			return nil, fmt.Errorf("%s should be named after its index %s", file, index.Name)
+		}
+		indexes = append(indexes, index)
+	}
+	return indexes, nil
+}
+
+// selectorFields lists the fields a CouchDB index can be used for: the fields of the
+// selector and of its $and clauses. Fields under $or, $nor and $not cannot use an index.
+func selectorFields(selector map[string]interface{}, fields map[string]bool) {
+	for field, condition := range selector {
+		if field == "$and" {
+			clauses, _ := condition.([]interface{})
+			for _, clause := range clauses {
+				if sub, ok := clause.(map[string]interface{}); ok {
+					selectorFields(sub, fields)
+				}
+			}
+		} else if !strings.HasPrefix(field, "$") {
+			fields[field] = true
+		}
+	}
+}
+
+// uncoveredReason says why no index serves a query, or returns "" when one does. CouchDB
+// only uses an index when all of its fields are in the selector and the sort is part of
+// it. The docType index only counts when the selector has nothing more specific.
+func uncoveredReason(query string, indexes []*couchIndex) string {
+	parsed, err := parseMangoQuery(query)
+	if err != nil {
+		return err.Error()
+	}
+	sortFields, err := parsed.sortFields()
+	if err != nil {
+		return err.Error()
+	}
+	fields := make(map[string]bool)
+	selectorFields(parsed.Selector, fields)
+	for _, index := range indexes {
+		indexFields := index.fields()
+		covered := len(indexFields) > 1 || len(fields) == 1
+		for _, field := range indexFields {
+			covered = covered && fields[field]
+		}
+		for _, sortField := range sortFields {
+			found := false
+			for _, field := range indexFields {
+				found = found || field == sortField.field
+			}
+			covered = covered && found
+		}
+		if covered {
+			return ""
+		}
+	}
+	names := make([]string, 0, len(fields))
+	for field := range fields {
+		names = append(names, field)
+	}
+	sort.Strings(names)
+	return "no index on " + strings.Join(names, ", ")
+}
+
+func TestIndexDefinitions(t *testing.T) {
+	indexes, err := loadIndexes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(indexes) == 0 {
+		t.Fatalf("no indexes packaged in %s", indexDir)
+	}
+	if reason := uncoveredReason(`{"selector":{"docType":"order","owner":"tom"}}`, indexes); reason == "" {
+		t.Errorf("a selector on an unindexed field should not be covered")
+	}
+	if reason := uncoveredReason(`{"selector":{"docType":"order","brokerId":"b"},"sort":["weightTon"]}`, indexes); reason == "" {
+		t.Errorf("a sort on an unindexed field should not be covered")
+	}
+}
+
+// TestMain runs the suite and then checks that every rich query it issued is served by
+// one of the packaged indexes.
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if code == 0 && len(issuedQueries) > 0 {
+		indexes, err := loadIndexes()
+		if err != nil {
+			fmt.Println("FAIL: cannot load indexes:", err)
+			os.Exit(1)
+		}
+		for query := range issuedQueries {
+			if reason := uncoveredReason(query, indexes); reason != "" {
+				fmt.Printf("FAIL: query %s is not covered by an index: %s\n", query, reason)
+				code = 1
+			}
+		}
+	}
+	os.Exit(code)
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ====LOCATIONS (CLI) =====================================================================
+// 登记地点 peer chaincode invoke -C myc1 -n orders -c '{"Args":["putLocation","SH-PD-01","浦东仓","上海市浦东新区张江路1号","310115","31.2035","121.5900"]}'
+// 查询地点 peer chaincode query -C myc1 -n orders -c '{"Args":["readLocation","SH-PD-01"]}'
+// 地区的地点 peer chaincode query -C myc1 -n orders -c '{"Args":["queryLocations","310115","10",""]}'
+// 按地点下单 peer chaincode invoke -C myc1 -n orders -c '{"Args":["initOrder","orderId0","","","煤炭","20","4000","WAIT_DRIVER_ACCEPT","goodsOwnerId","brokerId0","driverId","{\"fromLocationId\":\"SH-PD-01\",\"toLocationId\":\"BJ-CY-01\"}"]}'
+// 改送地点 peer chaincode invoke -C myc1 -n orders -c '{"Args":["updateOrder","orderId0","{\"toLocationId\":\"TJ-BH-01\"}","改送天津"]}'
+// 按线路查询 peer chaincode query -C myc1 -n orders -c '{"Args":["searchOrders","{\"originRegion\":\"310115\",\"destinationRegion\":\"110105\"}","10",""]}'
+//
+// The address book (location: locationId) names the depots and sites orders run between,
+// with an address, the code of their administrative region and coordinates. A location
+// belongs to the user who registered it, or whom an admin registered it for; its owner and
+// admins change it. An order references locations with the fromLocationId and toLocationId
+// options. fromAddress and toAddress then keep the address of the location as it was when
+// the order was created, and may be given empty; the region codes are kept as originRegion
+// and destinationRegion, which searches filter on and rate cards match as lanes. Orders with
+// textual addresses only are as before. Changing a location later does not change the
+// orders that reference it.
+// =========================================================================================
+
+const regionLocationIndex = "region~location"
+
+func locationKey(stub shim.ChaincodeStubInterface, locationId string) (string, error) {
+	return stub.CreateCompositeKey("location", []string{locationId})
+}
+
+func getLocation(stub shim.ChaincodeStubInterface, locationId string) (*Location, error) {
+	key, err := locationKey(stub, locationId)
+	if err != nil {
+		return nil, err
+	}
+	locationAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get location: %s", err.Error())
+	} else if locationAsBytes == nil {
+		return nil, fmt.Errorf("Location does not exist: %s", locationId)
+	}
+	location := &Location{}
+	err = json.Unmarshal(locationAsBytes, location)
+	if err != nil {
+		return nil, err
+	}
+	return location, nil
+}
+
+// snapshotLocation points one end of an order at a location, keeping its address and
+// region. An address already given must be the location's.
+func snapshotLocation(stub shim.ChaincodeStubInterface, locationId string, address *string, region *string) error {
+	location, err := getLocation(stub, locationId)
+	if err != nil {
+		return err
+	}
+	if *address != "" && *address != location.Address {
+		return fmt.Errorf("Address %s is not the address %s of location %s", *address, location.Address, locationId)
+	}
+	*address = location.Address
+	*region = location.RegionCode
+	return nil
+}
+
+// setOrderLocations links a new order to the locations of its options
+func setOrderLocations(stub shim.ChaincodeStubInterface, order *Order, fromLocationId string, toLocationId string) error {
+	if fromLocationId != "" {
+		err := snapshotLocation(stub, fromLocationId, &order.FromAddress, &order.OriginRegion)
+		if err != nil {
+			return err
+		}
+		order.FromLocationId = fromLocationId
+	}
+	if toLocationId != "" {
+		err := snapshotLocation(stub, toLocationId, &order.ToAddress, &order.DestinationRegion)
+		if err != nil {
+			return err
+		}
+		order.ToLocationId = toLocationId
+	}
+	return nil
+}
+
+// relocateOrder takes the address and region of a changed location of an order, returning
+// the address change it implies
+func relocateOrder(stub shim.ChaincodeStubInterface, order *Order, field string) ([]FieldChange, error) {
+	locationId, address, region, addressField := order.FromLocationId, &order.FromAddress, &order.OriginRegion, "fromAddress"
+	if field == "toLocationId" {
+		locationId, address, region, addressField = order.ToLocationId, &order.ToAddress, &order.DestinationRegion, "toAddress"
+	}
+	previous := *address
+	*address = ""
+	err := snapshotLocation(stub, locationId, address, region)
+	if err != nil {
+		return nil, err
+	}
+	if *address == previous {
+		return nil, nil
+	}
+	return []FieldChange{{addressField, previous, *address}}, nil
+}
+
+// locationChanges follows the location changes of an amended order, returning the address
+// changes they imply. The address of an end bound to a location only changes with it.
+func locationChanges(stub shim.ChaincodeStubInterface, order *Order, changes []FieldChange) ([]FieldChange, error) {
+	var implied []FieldChange
+	for _, change := range changes {
+		switch change.Field {
+		case "fromAddress", "toAddress":
+			locationField, locationId := "fromLocationId", order.FromLocationId
+			if change.Field == "toAddress" {
+				locationField, locationId = "toLocationId", order.ToLocationId
+			}
+			if locationId != "" {
+				return nil, fmt.Errorf("%s of order %s is the address of location %s, change %s instead",
+					change.Field, order.OrderId, locationId, locationField)
+			}
+		case "fromLocationId", "toLocationId":
+			addressChanges, err := relocateOrder(stub, order, change.Field)
+			if err != nil {
+				return nil, err
+			}
+			implied = append(implied, addressChanges...)
+		}
+	}
+	return implied, nil
+}
+
+// ============================================================
+// putLocation - register a location or change one
+// ============================================================
+func (t *SimpleChaincode) putLocation(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0       		1		2							3			4			5			6 (optional)
+	// "SH-PD-01", "浦东仓", "上海市浦东新区张江路1号", "310115", "31.2035", "121.5900", "ownerId"
+	if len(args) != 6 && len(args) != 7 {
+		return shim.Error("Incorrect number of arguments. Expecting 6 or 7")
+	}
+	for i, arg := range args[:4] {
+		if len(arg) <= 0 {
+			return shim.Error(fmt.Sprintf("Argument %d must be a non-empty string", i+1))
+		}
+	}
+	latitude, err := strconv.ParseFloat(args[4], 64)
+	if err != nil || latitude < -90 || latitude > 90 {
+		return shim.Error("5th argument must be a latitude between -90 and 90")
+	}
+	longitude, err := strconv.ParseFloat(args[5], 64)
+	if err != nil || longitude < -180 || longitude > 180 {
+		return shim.Error("6th argument must be a longitude between -180 and 180")
+	}
+	claimedOwner := ""
+	if len(args) == 7 {
+		claimedOwner = args[6]
+	}
+	caller, err := getCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	key, err := locationKey(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	previousAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return shim.Error("Failed to get location: " + err.Error())
+	}
+	ownerId := ""
+	if previousAsBytes != nil {
+		previous := &Location{}
+		err = json.Unmarshal(previousAsBytes, previous)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if !isAdmin(caller) && caller.UserId != previous.OwnerId {
+			return shim.Error("Location " + previous.LocationId + " belongs to " + previous.OwnerId)
+		}
+		err = putIndexEntry(stub, regionLocationIndex, []string{previous.RegionCode, previous.LocationId}, false)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		ownerId = previous.OwnerId
+	}
+	if claimedOwner != "" || ownerId == "" {
+		ownerId, err = actingUserId(stub, claimedOwner)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		_, err = getUser(stub, ownerId)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	location := &Location{ObjectType: "location", LocationId: args[0], Name: args[1], Address: args[2],
+		RegionCode: args[3], Latitude: latitude, Longitude: longitude, OwnerId: ownerId,
+		UpdatedBy: caller.UserId, UpdatedAt: txTime.Format(time.RFC3339)}
+	locationAsBytes, err := json.Marshal(location)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(key, locationAsBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = putIndexEntry(stub, regionLocationIndex, []string{location.RegionCode, location.LocationId}, true)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ============================================================
+// readLocation - read a location of the address book
+// ============================================================
+func (t *SimpleChaincode) readLocation(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0
+	// "SH-PD-01"
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	_, err := getCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	location, err := getLocation(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	locationAsBytes, err := json.Marshal(location)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(locationAsBytes)
+}
+
+// ============================================================
+// queryLocations - list the locations of a region, or every location
+// ============================================================
+func (t *SimpleChaincode) queryLocations(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0       		1		2
+	// "310115", ["10", "bookmark"]
+	pageSize, bookmark, err := parsePaginationArgs(args, 1)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	_, err = getCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	partialKey := []string{}
+	if args[0] != "" {
+		partialKey = append(partialKey, args[0])
+	}
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(regionLocationIndex, partialKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+	var records []QueryRecord
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		_, keyParts, err := stub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		locationId := keyParts[len(keyParts)-1]
+		location, err := getLocation(stub, locationId)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		locationAsBytes, err := json.Marshal(location)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		records = append(records, QueryRecord{locationId, locationAsBytes})
+	}
+	page, err := pageOfRecords(records, pageSize, bookmark)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	pageAsBytes, err := json.Marshal(page)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(pageAsBytes)
+}
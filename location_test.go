@@ -0,0 +1,166 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func newLocationFixture(t *testing.T) *testStub {
+	s := newFixture(t)
+	s.caller = "owner"
+	mustInvoke(t, s, "putLocation", "SH-PD-01", "浦东仓", "上海市浦东新区张江路1号", "310115", "31.2035", "121.5900")
+	mustInvoke(t, s, "putLocation", "BJ-CY-01", "朝阳仓", "北京市朝阳区建国路1号", "110105", "39.9087", "116.4605")
+	mustInvoke(t, s, "putLocation", "TJ-BH-01", "滨海仓", "天津市滨海新区港口路1号", "120116", "39.0032", "117.7104")
+	s.caller = "admin"
+	return s
+}
+
+func initLocationOrder(t *testing.T, s *testStub, orderId string, fromLocationId string, toLocationId string) Order {
+	t.Helper()
+	mustInvoke(t, s, "initOrder", orderId, "", "", "煤炭", "20", "4000", "WAIT_DRIVER_ACCEPT", "owner", "broker", "driver",
+		`{"fromLocationId":"`+fromLocationId+`","toLocationId":"`+toLocationId+`"}`)
+	return readOrder(t, s, orderId)
+}
+
+func TestLocationRegistry(t *testing.T) {
+	s := newLocationFixture(t)
+	var location Location
+	unmarshalPayload(t, mustInvoke(t, s, "readLocation", "SH-PD-01"), &location)
+	if location.OwnerId != "owner" || location.RegionCode != "310115" || location.Latitude != 31.2035 || location.UpdatedBy != "owner" {
+		t.Errorf("unexpected location %+v", location)
+	}
+
+	s.caller = "broker"
+	expectError(t, s, "Location SH-PD-01 belongs to owner", "putLocation", "SH-PD-01", "浦东仓", "上海市浦东新区张江路2号", "310115", "31.2", "121.6")
+	expectError(t, s, "User broker cannot act as owner", "putLocation", "BR-01", "承运人仓", "上海市", "310101", "31.2", "121.5", "owner")
+	expectError(t, s, "5th argument must be a latitude", "putLocation", "BR-01", "承运人仓", "上海市", "310101", "91", "121.5")
+	expectError(t, s, "6th argument must be a longitude", "putLocation", "BR-01", "承运人仓", "上海市", "310101", "31.2", "east")
+	expectError(t, s, "Argument 4 must be a non-empty string", "putLocation", "BR-01", "承运人仓", "上海市", "", "31.2", "121.5")
+	s.caller = "admin"
+	mustInvoke(t, s, "putLocation", "BR-01", "承运人仓", "上海市黄浦区", "310101", "31.2", "121.5", "broker")
+	mustInvoke(t, s, "putLocation", "SH-PD-01", "浦东仓", "上海市浦东新区张江路2号", "310101", "31.2", "121.6")
+	unmarshalPayload(t, mustInvoke(t, s, "readLocation", "SH-PD-01"), &location)
+	if location.OwnerId != "owner" || location.UpdatedBy != "admin" || location.Address != "上海市浦东新区张江路2号" {
+		t.Errorf("an admin change should keep the owner, got %+v", location)
+	}
+
+	var locations []struct {
+		Key    string
+		Record Location
+	}
+	unmarshalPage(t, mustInvoke(t, s, "queryLocations", "310101"), &locations)
+	if len(locations) != 2 || locations[0].Key != "BR-01" || locations[0].Record.OwnerId != "broker" || locations[1].Key != "SH-PD-01" {
+		t.Errorf("unexpected locations of 310101 %+v", locations)
+	}
+	unmarshalPage(t, mustInvoke(t, s, "queryLocations", "310115"), &locations)
+	if len(locations) != 0 {
+		t.Errorf("a location should leave the region it moved out of, got %+v", locations)
+	}
+	unmarshalPage(t, mustInvoke(t, s, "queryLocations", ""), &locations)
+	if len(locations) != 4 {
+		t.Errorf("expected all 4 locations, got %+v", locations)
+	}
+	expectError(t, s, "Location does not exist: XX", "readLocation", "XX")
+}
+
+func TestOrderSnapshotsLocations(t *testing.T) {
+	s := newLocationFixture(t)
+	order := initLocationOrder(t, s, "order1", "SH-PD-01", "BJ-CY-01")
+	if order.FromAddress != "上海市浦东新区张江路1号" || order.ToAddress != "北京市朝阳区建国路1号" ||
+		order.OriginRegion != "310115" || order.DestinationRegion != "110105" || order.FromLocationId != "SH-PD-01" {
+		t.Errorf("unexpected locations of %+v", order)
+	}
+	mustInvoke(t, s, "initOrder", "order2", "上海市浦东新区张江路1号", "北京", "煤炭", "20", "4000", "WAIT_DRIVER_ACCEPT",
+		"owner", "broker", "driver", `{"fromLocationId":"SH-PD-01"}`)
+	if order := readOrder(t, s, "order2"); order.ToLocationId != "" || order.DestinationRegion != "" || order.ToAddress != "北京" {
+		t.Errorf("a textual address should stay as given, got %+v", order)
+	}
+
+	s.caller = "owner"
+	mustInvoke(t, s, "putLocation", "SH-PD-01", "浦东仓", "上海市浦东新区张江路2号", "310115", "31.2", "121.6")
+	if order := readOrder(t, s, "order1"); order.FromAddress != "上海市浦东新区张江路1号" {
+		t.Errorf("a changed location should not change the orders referencing it, got %s", order.FromAddress)
+	}
+	s.caller = "admin"
+
+	expectError(t, s, "Address 上海 is not the address 上海市浦东新区张江路2号 of location SH-PD-01", "initOrder",
+		"order3", "上海", "北京", "煤炭", "20", "4000", "WAIT_DRIVER_ACCEPT", "owner", "broker", "driver", `{"fromLocationId":"SH-PD-01"}`)
+	expectError(t, s, "Location does not exist: XX", "initOrder",
+		"order3", "", "北京", "煤炭", "20", "4000", "WAIT_DRIVER_ACCEPT", "owner", "broker", "driver", `{"fromLocationId":"XX"}`)
+	expectError(t, s, "3rd argument must be a non-empty string, or the toLocationId option given", "initOrder",
+		"order3", "", "", "煤炭", "20", "4000", "WAIT_DRIVER_ACCEPT", "owner", "broker", "driver", `{"fromLocationId":"SH-PD-01"}`)
+}
+
+func TestSearchOrdersByRegion(t *testing.T) {
+	for _, stateDatabase := range []string{"couchdb", "leveldb"} {
+		s := newLocationFixture(t)
+		s.leveldb = stateDatabase == "leveldb"
+		initLocationOrder(t, s, "order1", "SH-PD-01", "BJ-CY-01")
+		initLocationOrder(t, s, "order2", "SH-PD-01", "TJ-BH-01")
+		initLocationOrder(t, s, "order3", "TJ-BH-01", "BJ-CY-01")
+		createOrder(t, s, "order4")
+		for filter, want := range map[string]string{
+			`{"originRegion":"310115"}`:                                  "order1,order2",
+			`{"destinationRegion":"110105"}`:                             "order1,order3",
+			`{"originRegion":"310115","destinationRegion":"120116"}`:     "order2",
+			`{"party":"owner","destinationRegion":"110105"}`:             "order1,order3",
+			`{"destinationRegion":"110105","states":["DRIVER_ON_ROAD"]}`: "",
+		} {
+			if got := strings.Join(queryOrderKeys(t, s, "searchOrders", filter), ","); got != want {
+				t.Errorf("%s %s: expected %s, got %s", stateDatabase, filter, want, got)
+			}
+		}
+		mustInvoke(t, s, "updateOrder", "order2", `{"toLocationId":"BJ-CY-01"}`, "改送北京")
+		if got := strings.Join(queryOrderKeys(t, s, "searchOrders", `{"destinationRegion":"120116"}`), ","); got != "" {
+			t.Errorf("%s: order2 should have left region 120116, got %s", stateDatabase, got)
+		}
+	}
+	s := newLocationFixture(t)
+	expectError(t, s, "regions, ranges and sorting only apply to orders", "queryRecords", `{"docType":"user","originRegion":"310115"}`)
+}
+
+func TestAmendOrderLocation(t *testing.T) {
+	s := newLocationFixture(t)
+	initLocationOrder(t, s, "order1", "SH-PD-01", "BJ-CY-01")
+	s.caller = "owner"
+	expectError(t, s, "toAddress of order order1 is the address of location BJ-CY-01, change toLocationId instead",
+		"updateOrder", "order1", `{"toAddress":"天津"}`, "改送天津")
+	expectError(t, s, "Location does not exist: XX", "updateOrder", "order1", `{"toLocationId":"XX"}`, "改送")
+	expectError(t, s, "Addresses, locations and content cannot be empty", "updateOrder", "order1", `{"toLocationId":""}`, "改送")
+	mustInvoke(t, s, "updateOrder", "order1", `{"toLocationId":"TJ-BH-01"}`, "改送天津")
+	if order := readOrder(t, s, "order1"); order.ToAddress != "天津市滨海新区港口路1号" || order.DestinationRegion != "120116" {
+		t.Errorf("the new location should replace the address snapshot, got %+v", order)
+	}
+	var amendments []struct {
+		Key    string
+		Record OrderAmendment
+	}
+	unmarshalPage(t, mustInvoke(t, s, "queryOrderAmendments", "order1"), &amendments)
+	if len(amendments) != 1 || len(amendments[0].Record.Changes) != 2 ||
+		amendments[0].Record.Changes[0].Field != "toLocationId" || amendments[0].Record.Changes[1].Field != "toAddress" ||
+		amendments[0].Record.Changes[1].From != "北京市朝阳区建国路1号" {
+		t.Errorf("unexpected amendments %+v", amendments)
+	}
+
+	s.caller = "driver"
+	advanceOrder(t, s, "order1", "DRIVER_ACCEPT_WAIT_ROAD")
+	s.caller = "owner"
+	expectError(t, s, "the change needs the approval of broker, driver", "updateOrder", "order1", `{"fromLocationId":"BJ-CY-01"}`, "改装货地")
+	expectError(t, s, "Location does not exist: XX", "proposeAmendment", "order1", `{"fromLocationId":"XX"}`, "改装货地",
+		"2019-04-02T08:00:00Z")
+}
+
+func TestRateCardMatchesRegionCode(t *testing.T) {
+	s := newLocationFixture(t)
+	s.caller = "broker"
+	mustInvoke(t, s, "putRateCard", "", `{"validFrom":"2019-04-01T00:00:00Z","rates":[
+		{"originRegion":"3101","destinationRegion":"1101","pricePerTon":180}]}`)
+	s.caller = "admin"
+	mustInvoke(t, s, "initOrder", "order1", "", "", "煤炭", "20", "auto", "WAIT_DRIVER_ACCEPT", "owner", "broker", "driver",
+		`{"fromLocationId":"SH-PD-01","toLocationId":"BJ-CY-01"}`)
+	if order := readOrder(t, s, "order1"); order.TransFee != 3600 || order.FeeCalculation.Rate.OriginRegion != "3101" {
+		t.Errorf("the region code rate should apply, got %v", order.TransFee)
+	}
+	expectError(t, s, "No rate card of broker prices 上海 to 北京", "initOrder",
+		"order2", "上海", "北京", "煤炭", "20", "auto", "WAIT_DRIVER_ACCEPT", "owner", "broker", "driver")
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+)
+
+// ====MANGO EVALUATOR =====================================================================
+// The rich queries of this chaincode are CouchDB Mango queries, which the shim's MockStub
+// and a LevelDB peer cannot run. executeMangoQuery evaluates a query over an in-memory
+// world state so that they can be tested and run locally without a CouchDB container.
+//
+// Supported: field equality, $eq $ne $gt $gte $lt $lte $in $nin $exists $regex, $and $or
+// $nor $not, nested fields ("a.b" or {"a":{"b":...}}), sort, fields, skip, limit and
+// bookmark. As in CouchDB, a missing field only matches {"$exists":false} and values are
+// compared with its collation: null < false < true < numbers < strings < arrays < objects.
+// The bookmark is the key of the last record returned.
+//
+// The evaluator stays in package main although it has no chaincode logic of its own. The
+// peer builds the chaincode from its GOPATH install path, which is set where the chaincode
+// is installed and not recorded in this tree, so a subpackage would have to be imported by
+// a guessed path that the peer build may not resolve.
+// =========================================================================================
+
+type mangoQuery struct {
+	Selector map[string]interface{} `json:"selector"`
+	Sort     []interface{}          `json:"sort"`
+	Fields   []string               `json:"fields"`
+	Skip     int                    `json:"skip"`
+	Limit    int                    `json:"limit"`
+	Bookmark string                 `json:"bookmark"`
+	UseIndex interface{}            `json:"use_index"`
+}
+
+type mangoSortField struct {
+	field string
+	desc  bool
+}
+
+type mangoDoc struct {
+	key   string
+	value map[string]interface{}
+}
+
+func parseMangoQuery(query string) (*mangoQuery, error) {
+	parsed := &mangoQuery{}
+	err := json.Unmarshal([]byte(query), parsed)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query %s: %s", query, err.Error())
+	}
+	if parsed.Selector == nil {
+		return nil, fmt.Errorf("invalid query %s: selector is required", query)
+	}
+	return parsed, nil
+}
+
+// sortFields reads the sort of a query, either ["field"] or [{"field":"desc"}]
+func (q *mangoQuery) sortFields() ([]mangoSortField, error) {
+	var fields []mangoSortField
+	for _, entry := range q.Sort {
+		switch entry := entry.(type) {
+		case string:
+			fields = append(fields, mangoSortField{entry, false})
+		case map[string]interface{}:
+			if len(entry) != 1 {
+				return nil, fmt.Errorf("invalid sort %v: expecting one field per entry", entry)
+			}
+			for field, direction := range entry {
+				switch direction {
+				case "asc":
+					fields = append(fields, mangoSortField{field, false})
+				case "desc":
+					fields = append(fields, mangoSortField{field, true})
+				default:
+					return nil, fmt.Errorf("invalid sort direction %v of %s", direction, field)
+				}
+			}
+		default:
+			return nil, fmt.Errorf("invalid sort %v", entry)
+		}
+	}
+	return fields, nil
+}
+
+// executeMangoQuery runs a query over every JSON document in state. pageSize and bookmark
+// override the limit and bookmark of the query when set, as GetQueryResultWithPagination does.
+func executeMangoQuery(state map[string][]byte, query string, pageSize int32, bookmark string) ([]*queryresult.KV, string, error) {
+	parsed, err := parseMangoQuery(query)
+	if err != nil {
+		return nil, "", err
+	}
+	sortFields, err := parsed.sortFields()
+	if err != nil {
+		return nil, "", err
+	}
+	if pageSize > 0 {
+		parsed.Limit = int(pageSize)
+	}
+	if bookmark != "" {
+		parsed.Bookmark = bookmark
+	}
+
+	keys := make([]string, 0, len(state))
+	for key := range state {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var docs []mangoDoc
+	for _, key := range keys {
+		var value map[string]interface{}
+		if json.Unmarshal(state[key], &value) != nil {
+			continue
+		}
+		matched, err := matchMangoSelector(value, parsed.Selector)
+		if err != nil {
+			return nil, "", err
+		}
+		// like a CouchDB index, a sort only covers the documents that have every sort field
+		for _, sortField := range sortFields {
+			if _, ok := lookupMangoField(value, sortField.field); !ok {
+				matched = false
+			}
+		}
+		if matched {
+			docs = append(docs, mangoDoc{key, value})
+		}
+	}
+	sort.SliceStable(docs, func(i, j int) bool {
+		for _, sortField := range sortFields {
+			a, _ := lookupMangoField(docs[i].value, sortField.field)
+			b, _ := lookupMangoField(docs[j].value, sortField.field)
+			c := compareMangoValues(a, b)
+			if c == 0 {
+				continue
+			}
+			return c < 0 != sortField.desc
+		}
+		return false
+	})
+
+	if parsed.Bookmark != "" {
+		start := len(docs)
+		for i, doc := range docs {
+			if doc.key == parsed.Bookmark {
+				start = i + 1
+				break
+			} else if len(sortFields) == 0 && doc.key > parsed.Bookmark {
+				start = i
+				break
+			}
+		}
+		docs = docs[start:]
+	}
+	if parsed.Skip > 0 {
+		if parsed.Skip > len(docs) {
+			parsed.Skip = len(docs)
+		}
+		docs = docs[parsed.Skip:]
+	}
+	if parsed.Limit > 0 && parsed.Limit < len(docs) {
+		docs = docs[:parsed.Limit]
+	}
+
+	next := parsed.Bookmark
+	kvs := make([]*queryresult.KV, 0, len(docs))
+	for _, doc := range docs {
+		value := state[doc.key]
+		if len(parsed.Fields) > 0 {
+			value, err = json.Marshal(projectMangoFields(doc.value, parsed.Fields))
+			if err != nil {
+				return nil, "", err
+			}
+		}
+		kvs = append(kvs, &queryresult.KV{Key: doc.key, Value: value})
+		next = doc.key
+	}
+	return kvs, next, nil
+}
+
+// lookupMangoField follows a dotted field path into a document
+func lookupMangoField(doc map[string]interface{}, field string) (interface{}, bool) {
+	var current interface{} = doc
+	for _, part := range strings.Split(field, ".") {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		current, ok = object[part]
+		if !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+func projectMangoFields(doc map[string]interface{}, fields []string) map[string]interface{} {
+	projected := make(map[string]interface{})
+	for _, field := range fields {
+		value, ok := lookupMangoField(doc, field)
+		if !ok {
+			continue
+		}
+		parts := strings.Split(field, ".")
+		target := projected
+		for _, part := range parts[:len(parts)-1] {
+			next, ok := target[part].(map[string]interface{})
+			if !ok {
+				next = make(map[string]interface{})
+				target[part] = next
+			}
+			target = next
+		}
+		target[parts[len(parts)-1]] = value
+	}
+	return projected
+}
+
+// matchMangoSelector reports whether a document satisfies every clause of a selector
+func matchMangoSelector(doc map[string]interface{}, selector map[string]interface{}) (bool, error) {
+	for field, condition := range selector {
+		var matched bool
+		var err error
+		switch field {
+		case "$and", "$or", "$nor":
+			matched, err = matchMangoCombination(doc, field, condition)
+		case "$not":
+			sub, ok := condition.(map[string]interface{})
+			if !ok {
+				return false, fmt.Errorf("$not expects a selector, got %v", condition)
+			}
+			matched, err = matchMangoSelector(doc, sub)
+			matched = !matched
+		default:
+			if strings.HasPrefix(field, "$") {
+				return false, fmt.Errorf("unsupported operator %s", field)
+			}
+			value, exists := lookupMangoField(doc, field)
+			matched, err = matchMangoCondition(value, exists, condition)
+		}
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchMangoCombination(doc map[string]interface{}, operator string, condition interface{}) (bool, error) {
+	clauses, ok := condition.([]interface{})
+	if !ok {
+		return false, fmt.Errorf("%s expects an array of selectors, got %v", operator, condition)
+	}
+	matched := 0
+	for _, clause := range clauses {
+		sub, ok := clause.(map[string]interface{})
+		if !ok {
+			return false, fmt.Errorf("%s expects an array of selectors, got %v", operator, clause)
+		}
+		ok, err := matchMangoSelector(doc, sub)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			matched++
+		}
+	}
+	switch operator {
+	case "$and":
+		return matched == len(clauses), nil
+	case "$or":
+		return matched > 0, nil
+	default:
+		return matched == 0, nil
+	}
+}
+
+// matchMangoCondition applies the condition of one field. A plain value means $eq, an
+// object of operators applies each of them and any other object is a nested selector.
+func matchMangoCondition(value interface{}, exists bool, condition interface{}) (bool, error) {
+	operators, ok := condition.(map[string]interface{})
+	if !ok {
+		return exists && compareMangoValues(value, condition) == 0, nil
+	}
+	isOperator := false
+	for operator := range operators {
+		if strings.HasPrefix(operator, "$") {
+			isOperator = true
+		}
+	}
+	if !isOperator {
+		nested, ok := value.(map[string]interface{})
+		if !ok {
+			return false, nil
+		}
+		return matchMangoSelector(nested, operators)
+	}
+
+	for operator, operand := range operators {
+		var matched bool
+		switch operator {
+		case "$eq":
+			matched = exists && compareMangoValues(value, operand) == 0
+		case "$ne":
+			matched = exists && compareMangoValues(value, operand) != 0
+		case "$gt":
+			matched = exists && compareMangoValues(value, operand) > 0
+		case "$gte":
+			matched = exists && compareMangoValues(value, operand) >= 0
+		case "$lt":
+			matched = exists && compareMangoValues(value, operand) < 0
+		case "$lte":
+			matched = exists && compareMangoValues(value, operand) <= 0
+		case "$in", "$nin":
+			candidates, ok := operand.([]interface{})
+			if !ok {
+				return false, fmt.Errorf("%s expects an array, got %v", operator, operand)
+			}
+			found := false
+			for _, candidate := range candidates {
+				if exists && compareMangoValues(value, candidate) == 0 {
+					found = true
+				}
+			}
+			matched = exists && found == (operator == "$in")
+		case "$exists":
+			want, ok := operand.(bool)
+			if !ok {
+				return false, fmt.Errorf("$exists expects a boolean, got %v", operand)
+			}
+			matched = exists == want
+		case "$regex":
+			pattern, ok := operand.(string)
+			if !ok {
+				return false, fmt.Errorf("$regex expects a string, got %v", operand)
+			}
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return false, fmt.Errorf("invalid $regex %s: %s", pattern, err.Error())
+			}
+			text, isString := value.(string)
+			matched = isString && re.MatchString(text)
+		case "$not":
+			ok, err := matchMangoCondition(value, exists, operand)
+			if err != nil {
+				return false, err
+			}
+			matched = !ok
+		default:
+			return false, fmt.Errorf("unsupported operator %s", operator)
+		}
+		if !matched {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func mangoTypeRank(value interface{}) int {
+	switch value := value.(type) {
+	case nil:
+		return 0
+	case bool:
+		if value {
+			return 2
+		}
+		return 1
+	case float64:
+		return 3
+	case string:
+		return 4
+	case []interface{}:
+		return 5
+	default:
+		return 6
+	}
+}
+
+// compareMangoValues orders two JSON values the way CouchDB collates them
+func compareMangoValues(a interface{}, b interface{}) int {
+	rankA, rankB := mangoTypeRank(a), mangoTypeRank(b)
+	if rankA != rankB {
+		if rankA < rankB {
+			return -1
+		}
+		return 1
+	}
+	switch a := a.(type) {
+	case float64:
+		b := b.(float64)
+		if a < b {
+			return -1
+		} else if a > b {
+			return 1
+		}
+		return 0
+	case string:
+		return strings.Compare(a, b.(string))
+	case []interface{}:
+		b := b.([]interface{})
+		for i := 0; i < len(a) && i < len(b); i++ {
+			if c := compareMangoValues(a[i], b[i]); c != 0 {
+				return c
+			}
+		}
+		return len(a) - len(b)
+	case map[string]interface{}:
+		if reflect.DeepEqual(a, b) {
+			return 0
+		}
+		aAsBytes, _ := json.Marshal(a)
+		bAsBytes, _ := json.Marshal(b)
+		return strings.Compare(string(aAsBytes), string(bAsBytes))
+	}
+	return 0
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func mangoState() map[string][]byte {
+	return map[string][]byte{
+		"order1": []byte(`{"docType":"order","orderId":"order1","brokerId":"broker1","orderState":"SIGNED","weight":20,"transFee":4000,"open":false}`),
+		"order2": []byte(`{"docType":"order","orderId":"order2","brokerId":"broker1","orderState":"DRIVER_ON_ROAD","weight":35,"transFee":6000,"open":true}`),
+		"order3": []byte(`{"docType":"order","orderId":"order3","brokerId":"broker2","orderState":"DRIVER_ON_ROAD","weight":10,"transFee":1500,"open":true}`),
+		"user1":  []byte(`{"docType":"user","userId":"user1","userName":"张三","role":"driver","address":{"city":"上海"}}`),
+		"blob":   []byte(`not json`),
+	}
+}
+
+func mangoKeys(t *testing.T, query string) []string {
+	t.Helper()
+	kvs, _, err := executeMangoQuery(mangoState(), query, 0, "")
+	if err != nil {
+		t.Fatalf("%s failed: %s", query, err)
+	}
+	keys := make([]string, 0, len(kvs))
+	for _, kv := range kvs {
+		keys = append(keys, kv.Key)
+	}
+	return keys
+}
+
+func TestMangoSelectors(t *testing.T) {
+	cases := map[string]string{
+		`{"selector":{"docType":"order"}}`:                                                "order1,order2,order3",
+		`{"selector":{"docType":{"$eq":"order"},"brokerId":"broker1"}}`:                   "order1,order2",
+		`{"selector":{"orderState":{"$ne":"SIGNED"}}}`:                                    "order2,order3",
+		`{"selector":{"weight":{"$gt":10,"$lte":35}}}`:                                    "order1,order2",
+		`{"selector":{"transFee":{"$gte":4000}}}`:                                         "order1,order2",
+		`{"selector":{"transFee":{"$lt":4000}}}`:                                          "order3",
+		`{"selector":{"orderState":{"$in":["SIGNED","ARRIVED_WAIT_SIGN"]}}}`:              "order1",
+		`{"selector":{"docType":"order","orderState":{"$nin":["SIGNED"]}}}`:               "order2,order3",
+		`{"selector":{"$and":[{"docType":"order"},{"open":true}]}}`:                       "order2,order3",
+		`{"selector":{"$or":[{"brokerId":"broker2"},{"role":"driver"}]}}`:                 "order3,user1",
+		`{"selector":{"docType":"order","$nor":[{"brokerId":"broker2"},{"open":false}]}}`: "order2",
+		`{"selector":{"docType":"order","$not":{"brokerId":"broker1"}}}`:                  "order3",
+		`{"selector":{"orderId":{"$regex":"^order[12]$"}}}`:                               "order1,order2",
+		`{"selector":{"userName":{"$exists":true}}}`:                                      "user1",
+		`{"selector":{"address.city":"上海"}}`:                                              "user1",
+		`{"selector":{"address":{"city":{"$regex":"海"}}}}`:                                "user1",
+		`{"selector":{"docType":"order","weight":{"$not":{"$gt":15}}}}`:                   "order3",
+	}
+	for query, want := range cases {
+		if got := strings.Join(mangoKeys(t, query), ","); got != want {
+			t.Errorf("%s: expected %s, got %s", query, want, got)
+		}
+	}
+}
+
+func TestMangoSortFieldsAndLimit(t *testing.T) {
+	keys := mangoKeys(t, `{"selector":{"docType":"order"},"sort":[{"weight":"desc"}]}`)
+	if strings.Join(keys, ",") != "order2,order1,order3" {
+		t.Errorf("unexpected descending sort %v", keys)
+	}
+	keys = mangoKeys(t, `{"selector":{"docType":"order"},"sort":["brokerId",{"transFee":"desc"}],"limit":2}`)
+	if strings.Join(keys, ",") != "order2,order1" {
+		t.Errorf("unexpected sort with limit %v", keys)
+	}
+	keys = mangoKeys(t, `{"selector":{"docType":"order"},"skip":1,"limit":1}`)
+	if strings.Join(keys, ",") != "order2" {
+		t.Errorf("unexpected skip %v", keys)
+	}
+
+	kvs, _, err := executeMangoQuery(mangoState(), `{"selector":{"docType":"user"},"fields":["userId","address.city"]}`, 0, "")
+	if err != nil || len(kvs) != 1 {
+		t.Fatalf("unexpected result %v, %v", kvs, err)
+	}
+	var projected map[string]interface{}
+	json.Unmarshal(kvs[0].Value, &projected)
+	if len(projected) != 2 || projected["userId"] != "user1" || projected["address"].(map[string]interface{})["city"] != "上海" {
+		t.Errorf("unexpected projection %v", projected)
+	}
+}
+
+func TestMangoBookmark(t *testing.T) {
+	query := `{"selector":{"docType":"order"},"sort":[{"weight":"desc"}]}`
+	kvs, bookmark, err := executeMangoQuery(mangoState(), query, 2, "")
+	if err != nil || len(kvs) != 2 || bookmark != "order1" {
+		t.Fatalf("unexpected first page %v, %s, %v", kvs, bookmark, err)
+	}
+	kvs, bookmark, err = executeMangoQuery(mangoState(), query, 2, bookmark)
+	if err != nil || len(kvs) != 1 || kvs[0].Key != "order3" || bookmark != "order3" {
+		t.Fatalf("unexpected second page %v, %s, %v", kvs, bookmark, err)
+	}
+	kvs, _, _ = executeMangoQuery(mangoState(), `{"selector":{"docType":"order"},"bookmark":"order2"}`, 0, "")
+	if len(kvs) != 1 || kvs[0].Key != "order3" {
+		t.Errorf("the bookmark of a query should be honoured, got %v", kvs)
+	}
+}
+
+func TestMangoErrors(t *testing.T) {
+	for _, query := range []string{
+		`{`,
+		`{"sort":["weight"]}`,
+		`{"selector":{"weight":{"$near":1}}}`,
+		`{"selector":{"$where":"1"}}`,
+		`{"selector":{"$or":{"a":1}}}`,
+		`{"selector":{"a":{"$in":"x"}}}`,
+		`{"selector":{"a":{"$regex":"("}}}`,
+		`{"selector":{"docType":"order"},"sort":[{"weight":"up"}]}`,
+	} {
+		if _, _, err := executeMangoQuery(mangoState(), query, 0, ""); err == nil {
+			t.Errorf("%s should fail", query)
+		}
+	}
+}
@@ -8,20 +8,34 @@
 // ==== Invoke orders ====
 // 创建运单 peer chaincode invoke -C myc1 -n orders -c '{"Args":["initOrder","orderId0", "fromAddress", "toAddress", "煤炭", "20", "4000","WAIT_DRIVER_ACCEPT","goodsOwnerId","brokerId0","driverId"]}'
 // 更改状态 peer chaincode invoke -C myc1 -n orders -c '{"Args":["changeStateOrder","orderId0","DRIVER_ACCEPT_WAIT_ROAD", "1553678282"]}'
-// 更新位置 peer chaincode invoke -C myc1 -n orders -c '{"Args":["updatePositionOrder", "orderId0", "InterLocation1", "1553678182"]}'
+// 更新位置 peer chaincode invoke -C myc1 -n orders -c '{"Args":["updatePositionOrder", "positionId0", "orderId0", "1", "Mon, 02 Jan 2006 15:04:05 MST", "InterLocation1"]}'
+// 创建带时限的运单 peer chaincode invoke -C myc1 -n orders -c '{"Args":["initOrder","orderId1", "fromAddress", "toAddress", "煤炭", "20", "4000","WAIT_DRIVER_ACCEPT","goodsOwnerId","brokerId0","driverId","{\"promisedPickup\":\"2019-04-01T08:00:00+08:00\",\"promisedDelivery\":\"2019-04-02T18:00:00+08:00\"}"]}'
+// 设置时限 peer chaincode invoke -C myc1 -n orders -c '{"Args":["setOrderDeadlines","orderId0","2019-04-01T08:00:00+08:00","2019-04-02T18:00:00+08:00"]}'
+// 取消运单 peer chaincode invoke -C myc1 -n orders -c '{"Args":["cancelOrder","orderId0","goodsOwnerId","reason"]}'
 // 删除运单 peer chaincode invoke -C myc1 -n orders -c '{"Args":["delete","orderId2"]}'
 
 // ==== Query orders ====
 // 从运单号查询运单 peer chaincode query -C myc1 -n orders -c '{"Args":["readOrder","orderId0"]}'
-// 从运单号查询运单的轨迹 peer chaincode query -C myc1 -n orders -c '{"Args":["queryAssets","{\"selector\":{\"brokerId\":\"brokerId1\", \"docType\":\"position\"}}"]}'
+// 从运单号查询运单的轨迹 peer chaincode query -C myc1 -n orders -c '{"Args":["queryRecords","{\"docType\":\"position\",\"orderId\":\"orderId0\"}"]}'
 // 查询该承运人时间戳范围内的运单 peer chaincode query -C myc1 -n orders -c '{"Args":["getOrdersByRange","",""]}'
+// 查询超时运单 peer chaincode query -C myc1 -n orders -c '{"Args":["queryOverdueOrders","broker","brokerId0"]}'
+// 查询承运人统计 peer chaincode query -C myc1 -n orders -c '{"Args":["queryOrderStats","broker","brokerId0","2019-04-01","2019-04-07"]}'
+// 查询承运人汇总 peer chaincode query -C myc1 -n orders -c '{"Args":["readPartySummary","brokerId0"]}'
 // 从运单号查询运单的修改历史 peer chaincode query -C myc1 -n orders -c '{"Args":["getHistoryForOrder","order1"]}'
+// 查询某时刻的运单 peer chaincode query -C myc1 -n orders -c '{"Args":["getOrderAsOf","order1","2019-04-01T12:00:00+08:00","evidence,position"]}'
+// 查询时间段内的修改历史 peer chaincode query -C myc1 -n orders -c '{"Args":["getHistoryForOrder","order1","2019-04-01T00:00:00+08:00","2019-04-02T00:00:00+08:00","10",""]}'
 
 // Rich Query (Only supported if CouchDB is used as state database):
 // 从承运人查询运单列表 peer chaincode query -C myc1 -n orders -c '{"Args":["queryOrdersByBroker","brokerId1"]}'
-// 从键值对查询运单列表 peer chaincode query -C myc1 -n orders -c '{"Args":["queryAssets","{\"selector\":{\"brokerId\":\"brokerId1\"}}"]}'
+// 从货主查询运单列表 peer chaincode query -C myc1 -n orders -c '{"Args":["queryOrdersByGoodsOwner","goodsOwnerId","10",""]}'
+// 从司机查询运单列表 peer chaincode query -C myc1 -n orders -c '{"Args":["queryOrdersByDriver","driverId","10",""]}'
+// 从状态查询运单列表 peer chaincode query -C myc1 -n orders -c '{"Args":["queryOrdersByState","DRIVER_ON_ROAD","10",""]}'
+// 搜索运单(CouchDB或LevelDB) peer chaincode query -C myc1 -n orders -c '{"Args":["searchOrders","{\"party\":\"brokerId1\",\"states\":[\"DRIVER_ON_ROAD\"],\"sortBy\":\"createdAt\",\"sortOrder\":\"desc\"}","10",""]}'
+// 从键值对查询运单列表(仅管理员) peer chaincode query -C myc1 -n orders -c '{"Args":["queryAssets","{\"selector\":{\"brokerId\":\"brokerId1\"}}"]}'
+// 按条件查询 peer chaincode query -C myc1 -n orders -c '{"Args":["queryRecords","{\"docType\":\"order\",\"party\":\"brokerId1\",\"partyRole\":\"broker\"}"]}'
 
 // Rich Query with Pagination (Only supported if CouchDB is used as state database):
+// 列表和查询都返回 {"records":[...],"recordsCount":3,"bookmark":"...","hasMore":true}，把 bookmark 传回去取下一页
 // 查询分页的运单列表 peer chaincode query -C myc1 -n orders -c '{"Args":["queryOrdersWithPagination","{\"selector\":{\"docType\":\"order\"}}","3",""]}'
 
 // INDEXES TO SUPPORT COUCHDB RICH QUERIES
@@ -33,8 +47,8 @@
 // CouchDB index JSON syntax as documented at:
 // http://docs.couchdb.org/en/2.1.1/api/database/find.html#db-index
 //
-// This orders02 example chaincode demonstrates a packaged
-// index which you can find in META-INF/statedb/couchdb/indexes/indexOwner.json.
+// The indexes of this chaincode are packaged in META-INF/statedb/couchdb/indexes, one per
+// selector shape the chaincode issues; a test checks that every selector is covered.
 // For deployment of chaincode to production environments, it is recommended
 // to define any indexes alongside chaincode so that the chaincode and supporting indexes
 // are deployed automatically as a unit, once the chaincode has been installed on a peer and
@@ -47,10 +61,22 @@
 // chaincode in the META-INF/statedb/couchdb/indexes directory, for packaging and deployment
 // to managed environments.
 //
-// In the examples below you can find index definitions that support orders02
-// chaincode queries, along with the syntax that you can use in development environments
-// to create the indexes in the CouchDB Fauxton interface or a curl command line utility.
+//   indexDocType          docType                           queries narrowed by $or only, e.g. ledger entries of an account
+//   indexOrderId          docType, orderId                  hashes, files, positions and ledger entries of an order
+//   indexBroker           docType, brokerId                 orders of a broker
+//   indexDriver           docType, driverId                 orders of a driver
+//   indexGoodsOwner       docType, goodsOwnerId             orders of a goods owner
+//   indexGoodsOwnerState  docType, goodsOwnerId, orderState orders of a goods owner in some states
+//   indexOrderState       docType, orderState               orders in some states
+//   indexCreatedAt        docType, createdAt                orders created in a window, sorted by creation time
+//   indexUpdatedAt        docType, updatedAt                orders changed in a window, sorted by last change
+//   indexWeightTon        docType, weightTon                orders in a weight range, sorted by weight
+//   indexTransFee         docType, transFee                 orders in a fee range, sorted by fee
+//   indexContent          docType, content                  orders of a kind of goods
+//   indexUserId           docType, userId                   user records
 //
+// createDate is an RFC1123 string that neither sorts nor compares by time, so windows and
+// sorting use the RFC3339 createdAt instead.
 
 //Example hostname:port configurations to access CouchDB.
 //
@@ -60,22 +86,22 @@
 //Inside couchdb docker container
 // http://127.0.0.1:5984/
 
-// Index for docType, owner.
+// Index for docType, brokerId.
 //
 // Example curl command line to define index in the CouchDB channel_chaincode database
-// curl -i -X POST -H "Content-Type: application/json" -d "{\"index\":{\"fields\":[\"docType\",\"owner\"]},\"name\":\"indexOwner\",\"ddoc\":\"indexOwnerDoc\",\"type\":\"json\"}" http://hostname:port/myc1_orders/_index
+// curl -i -X POST -H "Content-Type: application/json" -d "{\"index\":{\"fields\":[\"docType\",\"brokerId\"]},\"name\":\"indexBroker\",\"ddoc\":\"indexBrokerDoc\",\"type\":\"json\"}" http://hostname:port/myc1_orders/_index
 //
 
-// Index for docType, owner, size (descending order).
+// Index for docType, createdAt.
 //
 // Example curl command line to define index in the CouchDB channel_chaincode database
-// curl -i -X POST -H "Content-Type: application/json" -d "{\"index\":{\"fields\":[{\"size\":\"desc\"},{\"docType\":\"desc\"},{\"owner\":\"desc\"}]},\"ddoc\":\"indexSizeSortDoc\", \"name\":\"indexSizeSortDesc\",\"type\":\"json\"}" http://hostname:port/myc1_orders/_index
+// curl -i -X POST -H "Content-Type: application/json" -d "{\"index\":{\"fields\":[\"docType\",\"createdAt\"]},\"ddoc\":\"indexCreatedAtDoc\", \"name\":\"indexCreatedAt\",\"type\":\"json\"}" http://hostname:port/myc1_orders/_index
 
-// Rich Query with index design doc and index name specified (Only supported if CouchDB is used as state database):
-//   peer chaincode query -C myc1 -n orders -c '{"Args":["queryOrders","{\"selector\":{\"docType\":\"order\",\"owner\":\"tom\"}, \"use_index\":[\"_design/indexOwnerDoc\", \"indexOwner\"]}"]}'
+// Rich Query with index design doc and index name specified (Only supported if CouchDB is used as state database, admins only):
+//   peer chaincode query -C myc1 -n orders -c '{"Args":["queryAssets","{\"selector\":{\"docType\":\"order\",\"brokerId\":\"brokerId1\"}, \"use_index\":[\"_design/indexBrokerDoc\", \"indexBroker\"]}"]}'
 
-// Rich Query with index design doc specified only (Only supported if CouchDB is used as state database):
-//   peer chaincode query -C myc1 -n orders -c '{"Args":["queryOrders","{\"selector\":{\"docType\":{\"$eq\":\"order\"},\"owner\":{\"$eq\":\"tom\"},\"size\":{\"$gt\":0}},\"fields\":[\"docType\",\"owner\",\"size\"],\"sort\":[{\"size\":\"desc\"}],\"use_index\":\"_design/indexSizeSortDoc\"}"]}'
+// Rich Query with index design doc specified only (Only supported if CouchDB is used as state database, admins only):
+//   peer chaincode query -C myc1 -n orders -c '{"Args":["queryAssets","{\"selector\":{\"docType\":{\"$eq\":\"order\"},\"createdAt\":{\"$gt\":\"2019-04-01T00:00:00Z\"}},\"fields\":[\"orderId\",\"createdAt\"],\"sort\":[{\"docType\":\"desc\"},{\"createdAt\":\"desc\"}],\"use_index\":\"_design/indexCreatedAtDoc\"}"]}'
 
 package main
 
@@ -127,6 +153,90 @@ func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 		return t.initUser(stub, args)
 	} else if function == "updateUser" { //change owner of a specific order
 		return t.updateUser(stub, args)
+	} else if function == "activateUser" {
+		return t.activateUser(stub, args)
+	} else if function == "suspendUser" {
+		return t.suspendUser(stub, args)
+	} else if function == "deactivateUser" { //retire a user, replaces deleteUser
+		return t.deactivateUser(stub, args)
+	} else if function == "whoAmI" { //read the user bound to the submitting certificate
+		return t.whoAmI(stub, args)
+	} else if function == "rotateUserCertificate" { //bind a user to a new certificate
+		return t.rotateUserCertificate(stub, args)
+	} else if function == "initOrganization" { //create a carrier, broker or shipper organization
+		return t.initOrganization(stub, args)
+	} else if function == "readOrganization" { //read an organization
+		return t.readOrganization(stub, args)
+	} else if function == "setUserOrganization" { //place a user in an organization
+		return t.setUserOrganization(stub, args)
+	} else if function == "initVehicle" { //register a vehicle of an organization
+		return t.initVehicle(stub, args)
+	} else if function == "readVehicle" { //read a vehicle
+		return t.readVehicle(stub, args)
+	} else if function == "assignVehicleDriver" { //let a driver drive a vehicle
+		return t.assignVehicleDriver(stub, args)
+	} else if function == "unassignVehicleDriver" { //stop a driver from driving a vehicle
+		return t.unassignVehicleDriver(stub, args)
+	} else if function == "queryOrganizationDrivers" { //list the drivers of an organization
+		return t.queryOrganizationDrivers(stub, args)
+	} else if function == "queryOrganizationVehicles" { //list the vehicles of an organization
+		return t.queryOrganizationVehicles(stub, args)
+	} else if function == "queryOrganizationOrders" { //list the orders of the members of an organization
+		return t.queryOrganizationOrders(stub, args)
+	} else if function == "updateOrder" { //change some fields of an order
+		return t.updateOrder(stub, args)
+	} else if function == "queryOrderAmendments" { //list the amendments of an order
+		return t.queryOrderAmendments(stub, args)
+	} else if function == "proposeAmendment" { //propose a change of an accepted order
+		return t.proposeAmendment(stub, args)
+	} else if function == "approveAmendment" { //approve a proposed change
+		return t.approveAmendment(stub, args)
+	} else if function == "rejectAmendment" { //refuse a proposed change
+		return t.rejectAmendment(stub, args)
+	} else if function == "queryAmendmentProposals" { //list the proposed changes of an order
+		return t.queryAmendmentProposals(stub, args)
+	} else if function == "registerSigningKey" { //register the key proofs of delivery are signed with
+		return t.registerSigningKey(stub, args)
+	} else if function == "signDelivery" { //sign an arrived order with a proof of delivery
+		return t.signDelivery(stub, args)
+	} else if function == "addConsigneeDelegate" { //let a user sign deliveries for a consignee
+		return t.addConsigneeDelegate(stub, args)
+	} else if function == "removeConsigneeDelegate" { //stop a user from signing for a consignee
+		return t.removeConsigneeDelegate(stub, args)
+	} else if function == "queryConsigneeDelegates" { //list the delegates of a consignee
+		return t.queryConsigneeDelegates(stub, args)
+	} else if function == "setConsigneeContact" { //keep a consignee's contact in private data
+		return t.setConsigneeContact(stub, args)
+	} else if function == "readConsigneeContact" { //read the contact of an order's consignee
+		return t.readConsigneeContact(stub, args)
+	} else if function == "queryInboundOrders" { //list the orders delivered to a consignee
+		return t.queryInboundOrders(stub, args)
+	} else if function == "putRateCard" { //publish a new version of a broker's rate card
+		return t.putRateCard(stub, args)
+	} else if function == "readRateCard" { //read a version of a broker's rate card
+		return t.readRateCard(stub, args)
+	} else if function == "queryRateCards" { //list the versions of a broker's rate card
+		return t.queryRateCards(stub, args)
+	} else if function == "quoteFee" { //calculate a fee from a broker's rate card
+		return t.quoteFee(stub, args)
+	} else if function == "putGoods" { //add or change an entry of the goods catalog
+		return t.putGoods(stub, args)
+	} else if function == "readGoods" { //read an entry of the goods catalog
+		return t.readGoods(stub, args)
+	} else if function == "queryGoods" { //list the goods catalog
+		return t.queryGoods(stub, args)
+	} else if function == "setDriverCredential" { //record a driver's dangerous goods credential
+		return t.setDriverCredential(stub, args)
+	} else if function == "readDriverCredentialNo" { //read the number of a driver's credential, kept privately
+		return t.readDriverCredentialNo(stub, args)
+	} else if function == "attachSafetyDocument" { //attach the safety data sheet hash of an order
+		return t.attachSafetyDocument(stub, args)
+	} else if function == "putLocation" { //register or change a location of the address book
+		return t.putLocation(stub, args)
+	} else if function == "readLocation" { //read a location of the address book
+		return t.readLocation(stub, args)
+	} else if function == "queryLocations" { //list the locations of a region
+		return t.queryLocations(stub, args)
 	} else if function == "readUser" { //change owner of a specific order
 		return t.readUser(stub, args)
 	} else if function == "deleteUser" { //change owner of a specific order
@@ -139,12 +249,32 @@ func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 		return t.readOrder(stub, args)
 	} else if function == "queryOrdersByBroker" { //find orders for owner X using rich query
 		return t.queryOrdersByBroker(stub, args)
+	} else if function == "queryOrdersByGoodsOwner" {
+		return t.queryOrdersByGoodsOwner(stub, args)
+	} else if function == "queryOrdersByDriver" {
+		return t.queryOrdersByDriver(stub, args)
+	} else if function == "queryOrdersByState" {
+		return t.queryOrdersByState(stub, args)
+	} else if function == "queryRecords" { //find records through whitelisted filters
+		return t.queryRecords(stub, args)
+	} else if function == "searchOrders" { //search orders on CouchDB or LevelDB
+		return t.searchOrders(stub, args)
+	} else if function == "queryOrderStats" { //order statistics of a party over a window of days
+		return t.queryOrderStats(stub, args)
+	} else if function == "compactOrderStats" { //fold the pending statistics deltas into their days
+		return t.compactOrderStats(stub, args)
+	} else if function == "readPartySummary" { //running counters of a party
+		return t.readPartySummary(stub, args)
+	} else if function == "compactPartySummaries" {
+		return t.compactPartySummaries(stub, args)
 	} else if function == "queryAssets" { //find orders based on an ad hoc rich query
 		return t.queryAssets(stub, args)
 	} else if function == "updatePositionOrder" { //find orders based on an ad hoc rich query
 		return t.updatePositionOrder(stub, args)
 	} else if function == "getHistoryForOrder" { //get history of values for a order
 		return t.getHistoryForOrder(stub, args)
+	} else if function == "getOrderAsOf" { //an order as it stood at an instant
+		return t.getOrderAsOf(stub, args)
 	} else if function == "getOrdersByRange" { //get orders based on range query
 		return t.getOrdersByRange(stub, args)
 	} else if function == "getOrdersByRangeWithPagination" {
@@ -153,17 +283,57 @@ func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 		return t.queryOrderDetail(stub, args)
 	} else if function == "queryOrdersWithPagination" {
 		return t.queryOrdersWithPagination(stub, args)
+	} else if function == "deposit" { //add credit to an account
+		return t.deposit(stub, args)
+	} else if function == "withdraw" { //take credit out of an account
+		return t.withdraw(stub, args)
+	} else if function == "readAccount" {
+		return t.readAccount(stub, args)
+	} else if function == "queryLedgerEntries" {
+		return t.queryLedgerEntries(stub, args)
+	} else if function == "openDispute" { //goods owner disputes a delivered order
+		return t.openDispute(stub, args)
+	} else if function == "respondDispute" {
+		return t.respondDispute(stub, args)
+	} else if function == "resolveDispute" {
+		return t.resolveDispute(stub, args)
+	} else if function == "recordWeighbridge" { //loading or unloading weight of an order
+		return t.recordWeighbridge(stub, args)
+	} else if function == "resolveWeightFlag" {
+		return t.resolveWeightFlag(stub, args)
+	} else if function == "cancelOrder" { //cancel a order before delivery
+		return t.cancelOrder(stub, args)
+	} else if function == "setOrderDeadlines" {
+		return t.setOrderDeadlines(stub, args)
+	} else if function == "queryOverdueOrders" {
+		return t.queryOverdueOrders(stub, args)
+	} else if function == "setConfig" {
+		return t.setConfig(stub, args)
+	} else if function == "readConfig" {
+		return t.readConfig(stub, args)
 	}
 
 	fmt.Println("invoke did not find func: " + function) //error
 	return shim.Error("Received unknown function invocation")
 }
 
-func getTimeNow() string {
-	var formatedTime string
-	t := time.Now()
-	formatedTime = t.Format(time.RFC1123)
-	return formatedTime
+// getTxDate returns the transaction time in the RFC1123 format of createDate and
+// changeStateHistory. Unlike the clock of the peer, it is the same on every endorser.
+func getTxDate(stub shim.ChaincodeStubInterface) (string, error) {
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return "", err
+	}
+	return txTime.Format(time.RFC1123), nil
+}
+
+// getTxTime returns the timestamp of the transaction proposal, which is the same on every endorser
+func getTxTime(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	ts, err := stub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(ts.Seconds, int64(ts.Nanos)).UTC(), nil
 }
 
 // write to different ledgers- records, books and lending
@@ -172,42 +342,115 @@ func writeToRecordsLedger(stub shim.ChaincodeStubInterface, re Order, txnType st
 		//add TransactionHistory, first check if map has been initialized
 		_, ok := re.ChangeStateHistory["createOrder"]
 		if ok {
-			re.ChangeStateHistory[txnType] = getTimeNow()
+			txDate, err := getTxDate(stub)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			re.ChangeStateHistory[txnType] = txDate
 		} else {
 			return shim.Error("......Records Transaction history is not initialized")
 		}
 	}
-	// Encode JSON data
-	reAsBytes, err := json.Marshal(re)
-
 	// Store in the Blockchain
-	err = stub.PutState(re.OrderId, reAsBytes)
+	err := putOrder(stub, &re)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 	return shim.Success(nil)
 }
 
+// getOrder reads an order from chaincode state
+func getOrder(stub shim.ChaincodeStubInterface, orderId string) (*Order, error) {
+	orderAsBytes, err := stub.GetState(orderId)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get order: %s", err.Error())
+	} else if orderAsBytes == nil {
+		return nil, fmt.Errorf("Order does not exist: %s", orderId)
+	}
+	order := &Order{}
+	err = json.Unmarshal(orderAsBytes, order)
+	if err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// putOrder writes an order to chaincode state without touching its history. It stamps
+// updatedAt and the time a new state is entered, and keeps the composite-key search
+// indexes, the party statistics and the party summaries in step with the order.
+func putOrder(stub shim.ChaincodeStubInterface, order *Order) error {
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return err
+	}
+	order.UpdatedAt = txTime.Format(time.RFC3339)
+	var previous *Order
+	previousAsBytes, err := stub.GetState(order.OrderId)
+	if err != nil {
+		return err
+	} else if previousAsBytes != nil {
+		previous = &Order{}
+		err = json.Unmarshal(previousAsBytes, previous)
+		if err != nil {
+			return err
+		}
+	}
+	if previous == nil || previous.OrderState != order.OrderState {
+		if order.StateEnteredAt == nil {
+			order.StateEnteredAt = make(map[string]string)
+		}
+		order.StateEnteredAt[order.OrderState] = order.UpdatedAt
+	}
+	orderAsBytes, err := json.Marshal(order)
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(order.OrderId, orderAsBytes)
+	if err != nil {
+		return err
+	}
+	err = reindexOrder(stub, previous, order)
+	if err != nil {
+		return err
+	}
+	err = updateOrderStats(stub, previous, order)
+	if err != nil {
+		return err
+	}
+	return updatePartySummaries(stub, previous, order)
+}
+
+// getUser reads a user from chaincode state
+func getUser(stub shim.ChaincodeStubInterface, userId string) (*User, error) {
+	userAsBytes, err := stub.GetState(userId)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get user: %s", err.Error())
+	} else if userAsBytes == nil {
+		return nil, fmt.Errorf("User does not exist: %s", userId)
+	}
+	user := &User{}
+	err = json.Unmarshal(userAsBytes, user)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
 // ============================================================
 // initOrder - create a new order, store into chaincode state
 // ============================================================
 func (t *SimpleChaincode) initOrder(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	//   0       		1       		2     	3		4      5 		6     					7     		8			9 
-	// "orderId0", "fromAddress", "toAddress", "煤炭", "20", "4000","WAIT_DRIVER_ACCEPT","goodsOwnerId","brokerId0","driverId"
-	if len(args) != 10 {
-		return shim.Error("Incorrect number of arguments. Expecting 10")
+	//   0       		1       		2     	3		4      5 		6     					7     		8			9			10 (optional)
+	// "orderId0", "fromAddress", "toAddress", "煤炭", "20", "4000","WAIT_DRIVER_ACCEPT","goodsOwnerId","brokerId0","driverId", "{\"promisedPickup\":\"...\"}"
+	// fromAddress and toAddress may be empty when the fromLocationId and toLocationId options are given
+	if len(args) != 10 && len(args) != 11 {
+		return shim.Error("Incorrect number of arguments. Expecting 10 or 11")
 	}
 	// ==== Input sanitation ====
 	fmt.Println("- start init order")
 	if len(args[0]) <= 0 {
 		return shim.Error("1st argument must be a non-empty string")
 	}
-	if len(args[1]) <= 0 {
-		return shim.Error("2nd argument must be a non-empty string")
-	}
-	if len(args[2]) <= 0 {
-		return shim.Error("3rd argument must be a non-empty string")
-	}
 	if len(args[3]) <= 0 {
 		return shim.Error("4th argument must be a non-empty string")
 	}
@@ -234,16 +477,46 @@ func (t *SimpleChaincode) initOrder(stub shim.ChaincodeStubInterface, args []str
 	toAddress := args[2]
 	content := args[3]
 	orderState := args[6]
-	goodsOwnerId := args[7]
 	brokerId := args[8]
 	driverId := args[9]
+	// only states before SIGNED have a way on, and out of escrow
+	if stage, known := orderStage[orderState]; !known || stage >= orderStage["SIGNED"] || orderState == "DISPUTED" {
+		return shim.Error("7th argument must be a state before SIGNED other than DISPUTED, got " + orderState)
+	}
 	weightTon, err := strconv.ParseFloat(args[4], 64)
  	if err != nil {
 		return shim.Error("5th argument must be a numeric string")
 	}
-	 transFee, err := strconv.ParseFloat(args[5], 64)
+	// "auto" prices the order from the broker's rate card
+	transFee := 0.0
+	if args[5] != autoFee {
+		transFee, err = strconv.ParseFloat(args[5], 64)
+		if err != nil {
+			return shim.Error("6th argument must be a numeric string or auto")
+		}
+	}
+	if transFee < 0 {
+		return shim.Error("6th argument must not be negative")
+	}
+	options := OrderOptions{}
+	if len(args) == 11 && len(args[10]) > 0 {
+		err = json.Unmarshal([]byte(args[10]), &options)
+		if err != nil {
+			return shim.Error("11th argument must be a JSON object of order options: " + err.Error())
+		}
+	}
+	// the addresses may be left to the locations the options reference
+	if len(fromAddress) <= 0 && len(options.FromLocationId) <= 0 {
+		return shim.Error("2nd argument must be a non-empty string, or the fromLocationId option given")
+	}
+	if len(toAddress) <= 0 && len(options.ToLocationId) <= 0 {
+		return shim.Error("3rd argument must be a non-empty string, or the toLocationId option given")
+	}
+
+	// the fee is locked from the goods owner's account, so only they or an admin order for them
+	goodsOwnerId, err := actingUserId(stub, args[7])
 	if err != nil {
-		return shim.Error("6th argument must be a numeric string")
+		return shim.Error(err.Error())
 	}
 
 	// ==== Check if order already exists ====
@@ -256,23 +529,70 @@ func (t *SimpleChaincode) initOrder(stub shim.ChaincodeStubInterface, args []str
 	}
 
 	// ==== Create order object and marshal to JSON ====
+	createDate, err := getTxDate(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 	ChangeStateHistory := make(map[string]string)
-	ChangeStateHistory["createOrder"] = getTimeNow()
+	ChangeStateHistory["createOrder"] = createDate
 	
-	// order := &Order{"order","orderId0", "fromAddress", "toAddress", "coal", 20, 4000,"WAIT_DRIVER_ACCEPT","goodsOwnerId","brokerId0","driverId",getTimeNow(), true, ChangeStateHistory}
-
-	order := &Order{"order", orderId, fromAddress, toAddress, content, weightTon, transFee, orderState, goodsOwnerId, brokerId, driverId, getTimeNow(), true, ChangeStateHistory}
+	// order := &Order{"order","orderId0", "fromAddress", "toAddress", "coal", 20, 4000,"WAIT_DRIVER_ACCEPT","goodsOwnerId","brokerId0","driverId",createDate, true, ChangeStateHistory}
+
+	order := &Order{ObjectType: "order", OrderId: orderId, FromAddress: fromAddress, ToAddress: toAddress,
+		Content: content, WeightTon: weightTon, TransFee: transFee, OrderState: orderState,
+		GoodsOwnerId: goodsOwnerId, BrokerId: brokerId, DriverId: driverId, CreateDate: createDate,
+		Open: true, ChangeStateHistory: ChangeStateHistory, VehiclePlate: options.VehiclePlate,
+		ConsigneeId: options.ConsigneeId}
+	err = checkPartiesActive(stub, order)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkOrderConsignee(stub, order)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = setOrderLocations(stub, order, options.FromLocationId, options.ToLocationId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = setOrderGoods(stub, order, options.GoodsCode)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if orderStage[orderState] >= orderStage["DRIVER_ON_ROAD"] {
+		err = checkHazardClearance(stub, order)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	err = checkOrderVehicle(stub, order)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = setDeadlines(order, options.PromisedPickup, options.PromisedDelivery)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	order.CreatedAt = txTime.Format(time.RFC3339)
+	evaluateDeadlines(order, txTime)
+	err = priceOrder(stub, order, args[5] == autoFee, options.FeeOverrideReason)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 	// writeToRecordsLedger(stub, order, "createOrder")
-	orderJSONasBytes, err := json.Marshal(order)
+
+	// ==== Lock the fee in escrow, paid out when the order is signed ====
+	err = lockEscrow(stub, order)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	//Alternatively, build the order json string manually if you don't want to use struct marshalling
-	//orderJSONasString := `{"docType":"Order",  "name": "` + orderId + `", "color": "` + color + `", "size": ` + strconv.Itoa(size) + `, "owner": "` + owner + `"}`
-	//orderJSONasBytes := []byte(str)
 
-	// === Save order to state ===
-	err = stub.PutState(orderId, orderJSONasBytes)
+	// === Save order to state, indexed for searchOrders ===
+	err = putOrder(stub, order)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
@@ -459,7 +779,8 @@ func (t *SimpleChaincode) initUser(stub shim.ChaincodeStubInterface, args []stri
 	if len(args[2]) <= 0 {
 		return shim.Error("3rd argument must be a non-empty string")
 	}
-	if len(args[3]) <= 0 {
+	// consignees may leave it empty, their contact is kept in the consigneeContacts collection
+	if len(args[3]) <= 0 && args[2] != "consignee" {
 		return shim.Error("4th argument must be a non-empty string")
 	}
 	if len(args[4]) <= 0 {
@@ -471,10 +792,17 @@ func (t *SimpleChaincode) initUser(stub shim.ChaincodeStubInterface, args []stri
 	role := args[2]
 	telephone := args[3]
 
+	err = checkUserRole(role)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 	valid, err := strconv.ParseBool(args[4])
-	if err == nil {
-	   /** displaying the string variable into the console */
-	   fmt.Println("Value:", args[4])
+	if err != nil {
+		return shim.Error("5th argument must be true or false")
+	}
+	status := "ACTIVE"
+	if !valid {
+		status = "SUSPENDED"
 	}
 
 	// ==== Check if order already exists ====
@@ -495,16 +823,23 @@ func (t *SimpleChaincode) initUser(stub shim.ChaincodeStubInterface, args []stri
 		return shim.Error("This user already exists: " + userId)
 	}
 
+	// ==== Users register themselves unless an admin registers them ====
+	selfRegistered, err := checkRegistrar(stub, role)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
 	// ==== Create marble object and marshal to JSON ====
 	ObjectType := "user"
-	user := &User{ObjectType, userId, userName, role, telephone, valid}
-	userJSONasBytes, err := json.Marshal(user)
+	user := &User{ObjectType: ObjectType, UserId: userId, UserName: userName, Role: role, Telephone: telephone,
+		Valid: valid, Status: status}
+	err = registerUser(stub, user, selfRegistered)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 
 	// === Save marble to state ===
-	err = stub.PutState(userId, userJSONasBytes)
+	err = putUser(stub, user)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
@@ -515,53 +850,60 @@ func (t *SimpleChaincode) initUser(stub shim.ChaincodeStubInterface, args []stri
 }
 
 // ===========================================================
-// change the state of a order by setting a new state on the order
+// updateUser - change the name, role and telephone of a user
 // ===========================================================
 func (t *SimpleChaincode) updateUser(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 
-	//   0       		1      	2     	3		4  
-	// "userId", "userName", "role", "telephone", "valid"
-	if len(args) < 5 {
-		return shim.Error("Incorrect number of arguments. Expecting 2")
+	//   0       		1      	2     	3
+	// "userId", "userName", "role", "telephone"
+	// the status is changed through activateUser, suspendUser and deactivateUser
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
 	}
 	userId := args[0]
 	newName := args[1]
 	newRole := args[2]
 	newTelephone := args[3]
-
-	newValid, err := strconv.ParseBool(args[4])
-	if err == nil {
-	   /** displaying the string variable into the console */
-	   fmt.Println("Value:", args[4])
+	if len(newName) <= 0 {
+		return shim.Error("2nd argument must be a non-empty string")
+	}
+	if len(newTelephone) <= 0 && newRole != "consignee" {
+		return shim.Error("4th argument must be a non-empty string")
+	}
+	err := checkUserRole(newRole)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
 
-	userAsBytes, err := stub.GetState(userId)
+	userToChangeState, err := getUser(stub, userId)
 	if err != nil {
-		return shim.Error("Failed to get order:" + err.Error())
-	} else if userAsBytes == nil {
-		return shim.Error("Order does not exist")
+		return shim.Error(err.Error())
+	}
+	if userStatus(userToChangeState) == "DEACTIVATED" {
+		return shim.Error("User " + userId + " is DEACTIVATED")
 	}
-	userToChangeState := User{}
-	err = json.Unmarshal(userAsBytes, &userToChangeState) //unmarshal it aka JSON.parse()
+	caller, err := getCaller(stub)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	
+	if !isAdmin(caller) && caller.UserId != userId {
+		return shim.Error("User " + caller.UserId + " cannot update " + userId)
+	}
+	if !isAdmin(caller) && newRole != userToChangeState.Role {
+		return shim.Error("Only an admin can change the role of a user")
+	}
+
 	userToChangeState.UserName = newName
 	userToChangeState.Role = newRole
 	userToChangeState.Telephone = newTelephone
-	userToChangeState.Valid = newValid
 
-	// Encode JSON data
-	userInputBytes, err := json.Marshal(userToChangeState)
+	// Store in the Blockchain
+	err = putUser(stub, userToChangeState)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 
-	// Store in the Blockchain
-	err = stub.PutState(userToChangeState.UserId, userInputBytes)
-	
-	fmt.Println("- end transferOrder (success)")
+	fmt.Println("- end updateUser (success)")
 	return shim.Success(nil)
 }
 
@@ -571,15 +913,21 @@ func (t *SimpleChaincode) updateUser(stub shim.ChaincodeStubInterface, args []st
 func (t *SimpleChaincode) readUser(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	var userId string
 	var err error
-	userId = args[0]
 
 	mainStruct := UserGenerated{StatusMessage: "Success"}
 	if len(args) != 1 {
 		return shim.Error("Incorrect number of arguments. Expecting name of the order to query")
 	}
+	userId = args[0]
+	caller, err := getCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !isAdmin(caller) && caller.UserId != userId {
+		return shim.Error("User " + caller.UserId + " cannot read user " + userId)
+	}
 
-	queryFile := fmt.Sprintf("{\"selector\":{\"docType\":\"fileHashForUser\",\"orderId\":\"%s\"}}", userId)
-	fileResults, err := getQueryResultForQueryString(stub, queryFile)
+	fileResults, err := getQueryResultForSelector(stub, map[string]interface{}{"docType": "fileHashForUser", "orderId": userId})
 	if err != nil {
 		return shim.Error(err.Error())
 	}
@@ -597,13 +945,11 @@ func (t *SimpleChaincode) readUser(stub shim.ChaincodeStubInterface, args []stri
 			Comment:fileWithKey.Record.Comment})
 	}
 
-	userAsBytes, err := stub.GetState(userId)
+	user, err := getUser(stub, userId)
 	if err != nil {
-		return shim.Error("Failed to get order:" + err.Error())
+		return shim.Error(err.Error())
 	}
-	user := User{}
-	err = json.Unmarshal(userAsBytes, &user) //unmarshal it aka JSON.parse()
-	mainStruct.User = user
+	mainStruct.User = *user
 	js, err := json.MarshalIndent(mainStruct, "", "  ")
 	if err != nil {
 		return shim.Error(err.Error())
@@ -632,12 +978,26 @@ func (t *SimpleChaincode) readOrder(stub shim.ChaincodeStubInterface, args []str
 		jsonResp = "{\"Error\":\"Order does not exist: " + orderId + "\"}"
 		return shim.Error(jsonResp)
 	}
+	order := &Order{}
+	err = json.Unmarshal(valAsbytes, order)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	caller, err := getCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !canReadOrder(caller, order) {
+		return shim.Error("User " + caller.UserId + " cannot read order " + order.OrderId)
+	}
 
 	return shim.Success(valAsbytes)
 }
 
 // ==================================================
 // delete - remove a order key/value pair from state
+// Only an admin may delete an order, since any locked fee is refunded in full; a party
+// that wants out of an order cancels it with cancelOrder, which applies the penalties.
 // ==================================================
 func (t *SimpleChaincode) delete(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	var jsonResp string
@@ -645,6 +1005,10 @@ func (t *SimpleChaincode) delete(stub shim.ChaincodeStubInterface, args []string
 	if len(args) != 1 {
 		return shim.Error("Incorrect number of arguments. Expecting 1")
 	}
+	err := requireAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 	orderId := args[0]
 	// to maintain the color~name index, we need to read the order first and get its color
 	valAsbytes, err := stub.GetState(orderId) //get the order from chaincode state
@@ -660,12 +1024,34 @@ func (t *SimpleChaincode) delete(stub shim.ChaincodeStubInterface, args []string
 		jsonResp = "{\"Error\":\"Failed to decode JSON of: " + orderId + "\"}"
 		return shim.Error(jsonResp)
 	}
+	// give a fee that was never paid out back to the goods owner
+	var changes accountChanges
+	err = refundEscrow(stub, orderId, &changes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = changes.apply(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 	err = stub.DelState(orderId) //remove the order from chaincode state
 	if err != nil {
 		return shim.Error("Failed to delete state:" + err.Error())
 	}
 
-	// maintain the index
+	// maintain the indexes
+	err = reindexOrder(stub, &orderJSON, nil)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = updateOrderStats(stub, &orderJSON, nil)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = updatePartySummaries(stub, &orderJSON, nil)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 	indexName := "broker~createDate"
 	dateBrokerIndexKey, err := stub.CreateCompositeKey(indexName, []string{orderJSON.BrokerId, orderJSON.CreateDate})
 	if err != nil {
@@ -681,35 +1067,38 @@ func (t *SimpleChaincode) delete(stub shim.ChaincodeStubInterface, args []string
 }
 
 // ==================================================
-// delete - remove a order key/value pair from state
+// deleteUser - deactivate a user, kept for existing clients
 // ==================================================
 func (t *SimpleChaincode) deleteUser(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	var jsonResp string
-	var userJSON User
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
-	}
-	userId := args[0]
-	// to maintain the color~name index, we need to read the order first and get its color
-	valAsbytes, err := stub.GetState(userId) //get the order from chaincode state
-	if err != nil {
-		jsonResp = "{\"Error\":\"Failed to get state for " + userId + "\"}"
-		return shim.Error(jsonResp)
-	} else if valAsbytes == nil {
-		jsonResp = "{\"Error\":\"Order does not exist: " + userId + "\"}"
-		return shim.Error(jsonResp)
-	}
-	err = json.Unmarshal([]byte(valAsbytes), &userJSON)
-	if err != nil {
-		jsonResp = "{\"Error\":\"Failed to decode JSON of: " + userId + "\"}"
-		return shim.Error(jsonResp)
+	//   0       		1
+	// "userId", ["reason"]
+	// users are no longer removed from state, deleting one deactivates it and keeps its history
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 1 or 2")
 	}
-	err = stub.DelState(userId) //remove the order from chaincode state
-	if err != nil {
-		return shim.Error("Failed to delete state:" + err.Error())
+	reason := "deleted"
+	if len(args) == 2 && args[1] != "" {
+		reason = args[1]
 	}
+	return t.deactivateUser(stub, []string{args[0], reason})
+}
 
-	return shim.Success(nil)
+// orderTransitions lists the states changeStateOrder may move an order to from each state.
+// DISPUTED is entered through openDispute and left through resolveDispute only.
+var orderTransitions = map[string][]string{
+	"WAIT_DRIVER_ACCEPT":      {"DRIVER_ACCEPT_WAIT_ROAD"},
+	"DRIVER_ACCEPT_WAIT_ROAD": {"DRIVER_ON_ROAD"},
+	"DRIVER_ON_ROAD":          {"ARRIVED_WAIT_SIGN"},
+	"ARRIVED_WAIT_SIGN":       {"SIGNED"},
+}
+
+func canChangeState(from string, to string) bool {
+	for _, next := range orderTransitions[strings.ToUpper(from)] {
+		if next == to {
+			return true
+		}
+	}
+	return false
 }
 
 // ===========================================================
@@ -723,7 +1112,7 @@ func (t *SimpleChaincode) changeStateOrder(stub shim.ChaincodeStubInterface, arg
 		return shim.Error("Incorrect number of arguments. Expecting 2")
 	}
 	orderId := args[0]
-	newState := strings.ToLower(args[1])
+	newState := strings.ToUpper(args[1])
 	fmt.Println("- start changeStateOrder ", orderId, newState)
 	orderAsBytes, err := stub.GetState(orderId)
 	if err != nil {
@@ -736,14 +1125,64 @@ func (t *SimpleChaincode) changeStateOrder(stub shim.ChaincodeStubInterface, arg
 	if err != nil {
 		return shim.Error(err.Error())
 	}
+	if newState == "SIGNED" && canChangeState(orderToChangeState.OrderState, newState) {
+		return shim.Error("Order " + orderId + " is signed by submitting a proof of delivery with signDelivery")
+	}
+	// accepting, leaving and arriving are the driver's to report
+	err = requireOrderRole(stub, &orderToChangeState, "driver")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	res := transitionOrder(stub, orderToChangeState, newState)
+	if res.Status != shim.OK {
+		return res
+	}
+	fmt.Println("- end transferOrder (success)")
+	return shim.Success(nil)
+}
+
+// ===========================================================================================
+// transitionOrder moves an order to a new state, closing it and paying the fee out when it
+// is SIGNED
+// ===========================================================================================
+func transitionOrder(stub shim.ChaincodeStubInterface, orderToChangeState Order, newState string) pb.Response {
+	orderId := orderToChangeState.OrderId
+	if !canChangeState(orderToChangeState.OrderState, newState) {
+		return shim.Error("Order " + orderId + " cannot change from " + orderToChangeState.OrderState + " to " + newState)
+	}
+	if newState == "DRIVER_ON_ROAD" {
+		// dangerous goods need a credentialed driver and a safety document
+		err := checkHazardClearance(stub, &orderToChangeState)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
 	if newState == "SIGNED" {
+		// a weight variance beyond tolerance has to be resolved first
+		err := checkWeightCleared(stub, orderId)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
 		orderToChangeState.Open = false 
+		// pay the locked fee out to broker and driver
+		var changes accountChanges
+		err = releaseEscrow(stub, orderId, &changes)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = changes.apply(stub)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
 	}
 
 	orderToChangeState.OrderState = newState //change the state
-	writeToRecordsLedger(stub, orderToChangeState, newState)
-	fmt.Println("- end transferOrder (success)")
-	return shim.Success(nil)
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	evaluateDeadlines(&orderToChangeState, txTime)
+	return writeToRecordsLedger(stub, orderToChangeState, newState)
 }
 
 func (t *SimpleChaincode) updatePositionOrder(stub shim.ChaincodeStubInterface, args []string) pb.Response {
@@ -771,14 +1210,25 @@ func (t *SimpleChaincode) updatePositionOrder(stub shim.ChaincodeStubInterface,
 		return shim.Error("5th argument must be a non-empty string")
 	}
 
-	orderId := args[0]
+	positionId := args[0]
+	orderId := args[1]
 	// ==== Check if order already exists ====
-	orderAsBytes, err := stub.GetState(orderId)
+	order, err := getOrder(stub, orderId)
 	if err != nil {
-		return shim.Error("Failed to get order: " + err.Error())
-	} else if orderAsBytes == nil {
 		fmt.Println("This order does not exists: " + orderId)
-		return shim.Error("This order does not exists: " + orderId)
+		return shim.Error(err.Error())
+	}
+	err = requireOrderRole(stub, order, "driver")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// ==== Check the position does not overwrite another record ====
+	positionAsBytes, err := stub.GetState(positionId)
+	if err != nil {
+		return shim.Error("Failed to get position: " + err.Error())
+	} else if positionAsBytes != nil {
+		return shim.Error("This position already exists: " + positionId)
 	}
 
 	// ==== Create marble object and marshal to JSON ====
@@ -793,11 +1243,23 @@ func (t *SimpleChaincode) updatePositionOrder(stub shim.ChaincodeStubInterface,
 	//marbleJSONasBytes := []byte(str)
 
 	// === Save marble to state ===
-	err = stub.PutState(args[0], positionJSONasBytes)
+	err = stub.PutState(positionId, positionJSONasBytes)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 
+	// ==== A position report is also when missed deadlines are noticed ====
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if evaluateDeadlines(order, txTime) {
+		err = putOrder(stub, order)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
 	fmt.Println("- end init position")
 	return shim.Success(nil)
 }
@@ -822,9 +1284,12 @@ func constructQueryResponseFromIterator(resultsIterator shim.StateQueryIteratorI
 			buffer.WriteString(",")
 		}
 		buffer.WriteString("{\"Key\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(queryResponse.Key)
-		buffer.WriteString("\"")
+		// composite keys hold U+0000 separators, which have to be escaped
+		keyAsBytes, err := json.Marshal(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		buffer.Write(keyAsBytes)
 
 		buffer.WriteString(", \"Record\":")
 		// Record is a JSON object, so we write as-is
@@ -838,21 +1303,69 @@ func constructQueryResponseFromIterator(resultsIterator shim.StateQueryIteratorI
 }
 
 // ===========================================================================================
-// addPaginationMetadataToQueryResults adds QueryResponseMetadata, which contains pagination
-// info, to the constructed query results
+// constructQueryRecordsFromIterator reads the results of a query into QueryRecords
+// ===========================================================================================
+func constructQueryRecordsFromIterator(resultsIterator shim.StateQueryIteratorInterface) ([]QueryRecord, error) {
+	records := []QueryRecord{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, QueryRecord{queryResponse.Key, json.RawMessage(queryResponse.Value)})
+	}
+	return records, nil
+}
+
+// ===========================================================================================
+// parsePaginationArgs reads the optional pageSize and bookmark a list function accepts
+// after its fixed arguments. Without them every record is returned.
 // ===========================================================================================
-func addPaginationMetadataToQueryResults(buffer *bytes.Buffer, responseMetadata *pb.QueryResponseMetadata) *bytes.Buffer {
-
-	buffer.WriteString("[{\"ResponseMetadata\":{\"RecordsCount\":")
-	buffer.WriteString("\"")
-	buffer.WriteString(fmt.Sprintf("%v", responseMetadata.FetchedRecordsCount))
-	buffer.WriteString("\"")
-	buffer.WriteString(", \"Bookmark\":")
-	buffer.WriteString("\"")
-	buffer.WriteString(responseMetadata.Bookmark)
-	buffer.WriteString("\"}}]")
-
-	return buffer
+func parsePaginationArgs(args []string, fixed int) (int32, string, error) {
+	if len(args) != fixed && len(args) != fixed+2 {
+		return 0, "", fmt.Errorf("Incorrect number of arguments. Expecting %d or %d", fixed, fixed+2)
+	}
+	if len(args) == fixed {
+		return 0, "", nil
+	}
+	pageSize, err := parsePageSize(args[fixed])
+	return pageSize, args[fixed+1], err
+}
+
+func parsePageSize(arg string) (int32, error) {
+	pageSize, err := strconv.ParseInt(arg, 10, 32)
+	if err != nil || pageSize <= 0 {
+		return 0, fmt.Errorf("pageSize must be a positive integer: %s", arg)
+	}
+	return int32(pageSize), nil
+}
+
+// ===========================================================================================
+// pageOfRecords cuts a page out of records the chaincode has already filtered itself. The
+// bookmark is the key of the last record of the previous page; a bookmark none of the
+// records has any more is refused rather than starting over at the first page.
+// ===========================================================================================
+func pageOfRecords(records []QueryRecord, pageSize int32, bookmark string) (PaginatedResponse, error) {
+	if bookmark != "" {
+		found := false
+		for i, record := range records {
+			if record.Key == bookmark {
+				records, found = records[i+1:], true
+				break
+			}
+		}
+		if !found {
+			return PaginatedResponse{}, fmt.Errorf("Bookmark %s is not in the results any more, start again without a bookmark", bookmark)
+		}
+	}
+	hasMore := pageSize > 0 && int(pageSize) < len(records)
+	if hasMore {
+		records = records[:pageSize]
+	}
+	if len(records) > 0 {
+		bookmark = records[len(records)-1].Key
+	}
+	return PaginatedResponse{records, int32(len(records)), bookmark, hasMore}, nil
 }
 
 // ===========================================================================================
@@ -868,8 +1381,18 @@ func addPaginationMetadataToQueryResults(buffer *bytes.Buffer, responseMetadata
 // ===========================================================================================
 func (t *SimpleChaincode) getOrdersByRange(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 
-	if len(args) < 2 {
-		return shim.Error("Incorrect number of arguments. Expecting 2")
+	//   0       	1		 	2		3
+	// "startKey", "endKey", ["10", "bookmark"]
+	pageSize, _, err := parsePaginationArgs(args, 2)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if pageSize > 0 {
+		return t.getOrdersByRangeWithPagination(stub, args)
+	}
+	err = requireAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
 
 	startKey := args[0]
@@ -881,14 +1404,18 @@ func (t *SimpleChaincode) getOrdersByRange(stub shim.ChaincodeStubInterface, arg
 	}
 	defer resultsIterator.Close()
 
-	buffer, err := constructQueryResponseFromIterator(resultsIterator)
+	records, err := constructQueryRecordsFromIterator(resultsIterator)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	responseAsBytes, err := json.Marshal(PaginatedResponse{records, int32(len(records)), "", false})
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 
-	fmt.Printf("- getOrdersByRange queryResult:\n%s\n", buffer.String())
+	fmt.Printf("- getOrdersByRange queryResult:\n%s\n", string(responseAsBytes))
 
-	return shim.Success(buffer.Bytes())
+	return shim.Success(responseAsBytes)
 }
 
 // ==== Example: GetStateByPartialCompositeKey/RangeQuery =========================================
@@ -947,17 +1474,48 @@ func (t *SimpleChaincode) getOrdersByBroker(stub shim.ChaincodeStubInterface, ar
 // =========================================================================================
 func (t *SimpleChaincode) queryOrdersByBroker(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 
-	//   0
-	// "bob"
-	if len(args) < 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
-	}
+	//   0			1		2
+	// "bob", ["10", "bookmark"]
+	return queryOrdersByField(stub, "broker", args)
+}
 
-	broker := args[0]
+// queryOrdersByGoodsOwner lists the orders of a goods owner
+func (t *SimpleChaincode) queryOrdersByGoodsOwner(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 
-	queryString := fmt.Sprintf("{\"selector\":{\"docType\":\"order\",\"brokerId\":\"%s\"}}", broker)
+	//   0				1		2
+	// "goodsOwnerId", ["10", "bookmark"]
+	return queryOrdersByField(stub, "goodsOwner", args)
+}
+
+// queryOrdersByDriver lists the orders of a driver
+func (t *SimpleChaincode) queryOrdersByDriver(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0			1		2
+	// "driverId", ["10", "bookmark"]
+	return queryOrdersByField(stub, "driver", args)
+}
+
+// queryOrdersByState lists the orders in a state
+func (t *SimpleChaincode) queryOrdersByState(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0					1		2
+	// "DRIVER_ON_ROAD", ["10", "bookmark"]
+	return queryOrdersByField(stub, "orderState", args)
+}
 
-	queryResults, err := getQueryResultForQueryString(stub, queryString)
+// queryOrdersByField lists the orders of a party role, or in a state, through the filters
+// of queryRecords, so the caller only gets the orders they may read
+func queryOrdersByField(stub shim.ChaincodeStubInterface, field string, args []string) pb.Response {
+	pageSize, bookmark, err := parsePaginationArgs(args, 1)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	filter := &RecordFilter{DocType: "order", Party: args[0], PartyRole: field}
+	if field == "orderState" {
+		filter = &RecordFilter{DocType: "order", States: []string{args[0]}}
+	}
+
+	queryResults, err := runRecordFilter(stub, filter, pageSize, bookmark)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
@@ -973,9 +1531,19 @@ func (t *SimpleChaincode) queryOrderDetail(stub shim.ChaincodeStubInterface, arg
 		return shim.Error("Incorrect number of arguments. Expecting 1")
 	}
 	orderId := args[0]
+	order, err := getOrder(stub, orderId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	caller, err := getCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !canReadOrder(caller, order) {
+		return shim.Error("User " + caller.UserId + " cannot read order " + order.OrderId)
+	}
 	
-	queryString := fmt.Sprintf("{\"selector\":{\"docType\":\"stringHash\",\"orderId\":\"%s\"}}", orderId)
-	stringResults, err := getQueryResultForQueryString(stub, queryString)
+	stringResults, err := getQueryResultForSelector(stub, map[string]interface{}{"docType": "stringHash", "orderId": orderId})
 	if err != nil {
 		return shim.Error(err.Error())
 	}
@@ -995,8 +1563,7 @@ func (t *SimpleChaincode) queryOrderDetail(stub shim.ChaincodeStubInterface, arg
 			Comment:stringWithKey.Record.Comment})
 	}
 
-	queryFile := fmt.Sprintf("{\"selector\":{\"docType\":\"fileHashForOrder\",\"orderId\":\"%s\"}}", orderId)
-	fileResults, err := getQueryResultForQueryString(stub, queryFile)
+	fileResults, err := getQueryResultForSelector(stub, map[string]interface{}{"docType": "fileHashForOrder", "orderId": orderId})
 	if err != nil {
 		return shim.Error(err.Error())
 	}
@@ -1014,29 +1581,57 @@ func (t *SimpleChaincode) queryOrderDetail(stub shim.ChaincodeStubInterface, arg
 			Comment:fileWithKey.Record.Comment})
 	}
 
-	queryPosition := fmt.Sprintf("{\"selector\":{\"docType\":\"position\",\"orderId\":\"%s\"}}", orderId)
-	positionResults, err := getQueryResultForQueryString(stub, queryPosition)
+	positionResults, err := getQueryResultForSelector(stub, map[string]interface{}{"docType": "position", "orderId": orderId})
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 
-	var positions []UpdatePositionHistory
-	err = json.Unmarshal(positionResults, &positions)
+	var positionWithKeys []PositionWithKey
+	err = json.Unmarshal(positionResults, &positionWithKeys)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 
-	for _,position := range positions {
-		mainStruct.Position = append(mainStruct.Position, position)
+	for _,positionWithKey := range positionWithKeys {
+		mainStruct.Position = append(mainStruct.Position, positionWithKey.Record)
 	}
 
-	orderAsBytes, err := stub.GetState(orderId)
+	escrow, err := getEscrow(stub, orderId)
 	if err != nil {
-		return shim.Error("Failed to get order:" + err.Error())
+		return shim.Error(err.Error())
+	}
+	mainStruct.Escrow = escrow
+
+	dispute, err := getDispute(stub, orderId)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
-	order := Order{}
-	err = json.Unmarshal(orderAsBytes, &order) //unmarshal it aka JSON.parse()
-	mainStruct.Order = order
+	mainStruct.Dispute = dispute
+
+	weight, err := getWeightReconciliation(stub, orderId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	mainStruct.Weight = weight
+
+	ledgerResults, err := getQueryResultForSelector(stub, map[string]interface{}{"docType": "ledgerEntry", "orderId": orderId})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var ledgerWithKeys []struct {
+		Record LedgerEntry `json:"Record"`
+	}
+	err = json.Unmarshal(ledgerResults, &ledgerWithKeys)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	for _,ledgerWithKey := range ledgerWithKeys {
+		mainStruct.Ledger = append(mainStruct.Ledger, ledgerWithKey.Record)
+	}
+
+	mainStruct.Order = *order
 	js, err := json.MarshalIndent(mainStruct, "", "  ")
 	if err != nil {
 		return shim.Error(err.Error())
@@ -1049,20 +1644,26 @@ func (t *SimpleChaincode) queryOrderDetail(stub shim.ChaincodeStubInterface, arg
 // queryAssts uses a query string to perform a query for assets.
 // Query string matching state database syntax is passed in and executed as is.
 // Supports ad hoc queries that can be defined at runtime by the client.
-// If this is not desired, follow the queryOrdersForOwner example for parameterized queries.
+// Raw selectors can read any record, so only admins may run them; other clients go
+// through the named filters of queryRecords.
 // Only available on state databases that support rich query (e.g. CouchDB)
 // =========================================================================================
 func (t *SimpleChaincode) queryAssets(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 
-	//   0
-	// "queryString"
-	if len(args) < 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+	//   0				1		2
+	// "queryString", ["10", "bookmark"]
+	pageSize, bookmark, err := parsePaginationArgs(args, 1)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = requireAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
 
 	queryString := args[0]
 
-	queryResults, err := getQueryResultForQueryString(stub, queryString)
+	queryResults, err := getQueryResultForQueryStringWithPagination(stub, queryString, pageSize, bookmark)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
@@ -1093,6 +1694,17 @@ func getQueryResultForQueryString(stub shim.ChaincodeStubInterface, queryString
 	return buffer.Bytes(), nil
 }
 
+// =========================================================================================
+// getQueryResultForSelector builds the query string of a selector and executes it.
+// =========================================================================================
+func getQueryResultForSelector(stub shim.ChaincodeStubInterface, selector map[string]interface{}) ([]byte, error) {
+	queryString, err := buildQueryString(selector)
+	if err != nil {
+		return nil, err
+	}
+	return getQueryResultForQueryString(stub, queryString)
+}
+
 // ====== Pagination =========================================================================
 // Pagination provides a method to retrieve records with a defined pagesize and
 // start point (bookmark).  An empty string bookmark defines the first "page" of a query
@@ -1116,51 +1728,62 @@ func (t *SimpleChaincode) getOrdersByRangeWithPagination(stub shim.ChaincodeStub
 	if len(args) < 4 {
 		return shim.Error("Incorrect number of arguments. Expecting 4")
 	}
+	err := requireAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 	startKey := args[0]
 	endKey := args[1]
-	//return type of ParseInt is int64
-	pageSize, err := strconv.ParseInt(args[2], 10, 20)
+	pageSize, err := parsePageSize(args[2])
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 	bookmark := args[3]
-	resultsIterator, responseMetadata, err := stub.GetStateByRangeWithPagination(startKey, endKey, int32(pageSize), bookmark)
+	resultsIterator, responseMetadata, err := stub.GetStateByRangeWithPagination(startKey, endKey, pageSize, bookmark)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 	defer resultsIterator.Close()
-	buffer, err := constructQueryResponseFromIterator(resultsIterator)
+	records, err := constructQueryRecordsFromIterator(resultsIterator)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	bufferWithPaginationInfo := addPaginationMetadataToQueryResults(buffer, responseMetadata)
-	fmt.Printf("- getOrdersByRange queryResult:\n%s\n", bufferWithPaginationInfo.String())
-	return shim.Success(buffer.Bytes())
+	// the bookmark of a range query is the key the next page starts at, empty after the last page
+	responseAsBytes, err := json.Marshal(PaginatedResponse{records, responseMetadata.FetchedRecordsCount,
+		responseMetadata.Bookmark, responseMetadata.Bookmark != ""})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Printf("- getOrdersByRange queryResult:\n%s\n", string(responseAsBytes))
+	return shim.Success(responseAsBytes)
 }
 
 // ===== Example: Pagination with Ad hoc Rich Query ========================================================
 // queryOrdersWithPagination uses a query string, page size and a bookmark to perform a query
 // for orders. Query string matching state database syntax is passed in and executed as is.
 // The number of fetched records would be equal to or lesser than the specified page size.
-// Supports ad hoc queries that can be defined at runtime by the client.
-// If this is not desired, follow the queryOrdersForOwner example for parameterized queries.
+// Supports ad hoc queries that can be defined at runtime by the client, admins only, as
+// for queryAssets.
 // Only available on state databases that support rich query (e.g. CouchDB)
 // Paginated queries are only valid for read only transactions.
 // =========================================================================================
 func (t *SimpleChaincode) queryOrdersWithPagination(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	//   0
-	// "queryString"
+	//   0				1		2
+	// "queryString", "10", "bookmark"
 	if len(args) < 3 {
 		return shim.Error("Incorrect number of arguments. Expecting 3")
 	}
 	queryString := args[0]
-	//return type of ParseInt is int64
-	pageSize, err := strconv.ParseInt(args[1], 10, 16)
+	pageSize, err := parsePageSize(args[1])
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 	bookmark := args[2]
-	queryResults, err := getQueryResultForQueryStringWithPagination(stub, queryString, int32(pageSize), bookmark)
+	err = requireAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	queryResults, err := getQueryResultForQueryStringWithPagination(stub, queryString, pageSize, bookmark)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
@@ -1169,85 +1792,118 @@ func (t *SimpleChaincode) queryOrdersWithPagination(stub shim.ChaincodeStubInter
 
 // =========================================================================================
 // getQueryResultForQueryStringWithPagination executes the passed in query string with
-// pagination info. Result set is built and returned as a byte array containing the JSON results.
+// pagination info. Result set is built and returned as a PaginatedResponse; a pageSize
+// of 0 returns every record on a single page.
 // =========================================================================================
 func getQueryResultForQueryStringWithPagination(stub shim.ChaincodeStubInterface, queryString string, pageSize int32, bookmark string) ([]byte, error) {
 	fmt.Printf("- getQueryResultForQueryString queryString:\n%s\n", queryString)
+	if pageSize <= 0 {
+		resultsIterator, err := stub.GetQueryResult(queryString)
+		if err != nil {
+			return nil, err
+		}
+		defer resultsIterator.Close()
+		records, err := constructQueryRecordsFromIterator(resultsIterator)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(PaginatedResponse{records, int32(len(records)), "", false})
+	}
+
 	resultsIterator, responseMetadata, err := stub.GetQueryResultWithPagination(queryString, pageSize, bookmark)
 	if err != nil {
 		return nil, err
 	}
 	defer resultsIterator.Close()
-	buffer, err := constructQueryResponseFromIterator(resultsIterator)
+	records, err := constructQueryRecordsFromIterator(resultsIterator)
 	if err != nil {
 		return nil, err
 	}
-	bufferWithPaginationInfo := addPaginationMetadataToQueryResults(buffer, responseMetadata)
-	fmt.Printf("- getQueryResultForQueryString queryResult:\n%s\n", bufferWithPaginationInfo.String())
-	return buffer.Bytes(), nil
+	// CouchDB hands out a bookmark even after the last page, so look one record ahead
+	hasMore := false
+	if responseMetadata.FetchedRecordsCount == pageSize {
+		nextIterator, _, err := stub.GetQueryResultWithPagination(queryString, 1, responseMetadata.Bookmark)
+		if err != nil {
+			return nil, err
+		}
+		hasMore = nextIterator.HasNext()
+		nextIterator.Close()
+	}
+	responseAsBytes, err := json.Marshal(PaginatedResponse{records, responseMetadata.FetchedRecordsCount, responseMetadata.Bookmark, hasMore})
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("- getQueryResultForQueryString queryResult:\n%s\n", string(responseAsBytes))
+	return responseAsBytes, nil
 }
 
 func (t *SimpleChaincode) getHistoryForOrder(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 
-	if len(args) < 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+	//   0			1								2							3		4
+	// "order1", ["2019-04-01T00:00:00+08:00", "2019-04-02T00:00:00+08:00", ["10", "bookmark"]]
+	if len(args) != 1 && len(args) != 3 && len(args) != 5 {
+		return shim.Error("Incorrect number of arguments. Expecting 1, 3 or 5")
+	}
+	if len(args) == 1 {
+		args = append(args, "", "")
 	}
-
 	orderId := args[0]
+	pageSize, bookmark, err := parsePaginationArgs(args, 3)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	var window [2]time.Time
+	for i, arg := range args[1:3] {
+		if arg == "" {
+			continue
+		}
+		window[i], err = time.Parse(time.RFC3339, arg)
+		if err != nil {
+			return shim.Error("Dates must be RFC3339, e.g. 2019-04-01T08:00:00+08:00: " + arg)
+		}
+	}
 
 	fmt.Printf("- start getHistoryForOrder: %s\n", orderId)
 
-	resultsIterator, err := stub.GetHistoryForKey(orderId)
+	history, err := readOrderHistory(stub, orderId)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	defer resultsIterator.Close()
-
-	// buffer is a JSON array containing historic values for the order
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
-
-	bArrayMemberAlreadyWritten := false
-	for resultsIterator.HasNext() {
-		response, err := resultsIterator.Next()
+	caller, err := getCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	// the parties of a deleted order are those of its last version. Without a readable
+	// version there are no parties to check against, so only an admin gets the history.
+	var lastVersion *Order
+	for i := len(history) - 1; i >= 0 && lastVersion == nil; i-- {
+		lastVersion = history[i].Order
+	}
+	if (lastVersion == nil && !isAdmin(caller)) || (lastVersion != nil && !canReadOrder(caller, lastVersion)) {
+		return shim.Error("User " + caller.UserId + " cannot read order " + orderId)
+	}
+	records := []QueryRecord{}
+	for _, entry := range history {
+		at, _ := time.Parse(time.RFC3339Nano, entry.Timestamp)
+		if (!window[0].IsZero() && at.Before(window[0])) || (!window[1].IsZero() && !at.Before(window[1])) {
+			continue
+		}
+		entryAsBytes, err := json.Marshal(entry)
 		if err != nil {
 			return shim.Error(err.Error())
 		}
-		// Add a comma before array members, suppress it for the first array member
-		if bArrayMemberAlreadyWritten == true {
-			buffer.WriteString(",")
-		}
-		buffer.WriteString("{\"TxId\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(response.TxId)
-		buffer.WriteString("\"")
-
-		buffer.WriteString(", \"Value\":")
-		// if it was a delete operation on given key, then we need to set the
-		//corresponding value null. Else, we will write the response.Value
-		//as-is (as the Value itself a JSON order)
-		if response.IsDelete {
-			buffer.WriteString("null")
-		} else {
-			buffer.WriteString(string(response.Value))
-		}
-
-		buffer.WriteString(", \"Timestamp\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(time.Unix(response.Timestamp.Seconds, int64(response.Timestamp.Nanos)).String())
-		buffer.WriteString("\"")
-
-		buffer.WriteString(", \"IsDelete\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(strconv.FormatBool(response.IsDelete))
-		buffer.WriteString("\"")
-
-		buffer.WriteString("}")
-		bArrayMemberAlreadyWritten = true
+		records = append(records, QueryRecord{entry.TxId, json.RawMessage(entryAsBytes)})
+	}
+	page, err := pageOfRecords(records, pageSize, bookmark)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	historyAsBytes, err := json.Marshal(page)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
-	buffer.WriteString("]")
 
-	fmt.Printf("- getHistoryForOrder returning:\n%s\n", buffer.String())
+	fmt.Printf("- getHistoryForOrder returning:\n%s\n", string(historyAsBytes))
 
-	return shim.Success(buffer.Bytes())
+	return shim.Success(historyAsBytes)
 }
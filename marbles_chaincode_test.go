@@ -0,0 +1,934 @@
+package main
+
+import (
+	"container/list"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+	"github.com/hyperledger/fabric/protos/msp"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ===========================================================================================
+// testStub wraps shim.MockStub with the parts the mock leaves out: rich queries run by
+// executeMangoQuery over the JSON world state, GetHistoryForKey and paginated queries.
+// Every invoke runs as its own transaction at the stub's clock, submitted by the caller
+// user, and a transaction returning an error is rolled back, since a peer would never
+// commit it. Like a peer, reads inside a transaction see the state committed before it and
+// not the transaction's own writes.
+// ===========================================================================================
+type testStub struct {
+	*shim.MockStub
+	cc         shim.Chaincode
+	args       [][]byte
+	caller     string
+	commonName string
+	now        time.Time
+	txSeq      int
+	history    map[string][]*queryresult.KeyModification
+	committed  map[string][]byte // the state reads see while a transaction runs
+	writes     map[string][]byte
+	writeOrder []string
+	transient  map[string][]byte
+	leveldb    bool // refuse rich queries as a LevelDB peer does
+}
+
+func newTestStub() *testStub {
+	cc := new(SimpleChaincode)
+	return &testStub{
+		MockStub: shim.NewMockStub("orders", cc),
+		cc:       cc,
+		caller:   "admin",
+		now:      time.Date(2019, 4, 1, 8, 0, 0, 0, time.UTC),
+		history:  make(map[string][]*queryresult.KeyModification),
+	}
+}
+
+// invoke runs one transaction and moves the clock on by a minute
+func (s *testStub) invoke(function string, args ...string) pb.Response {
+	s.txSeq++
+	txId := fmt.Sprintf("tx%d", s.txSeq)
+	s.args = [][]byte{[]byte(function)}
+	for _, arg := range args {
+		s.args = append(s.args, []byte(arg))
+	}
+	snapshot := make(map[string][]byte, len(s.State))
+	for key, value := range s.State {
+		snapshot[key] = value
+	}
+	s.writes = make(map[string][]byte)
+	s.writeOrder = nil
+
+	s.MockTransactionStart(txId)
+	s.TxTimestamp = &timestamp.Timestamp{Seconds: s.now.Unix(), Nanos: int32(s.now.Nanosecond())}
+	s.committed = snapshot
+	res := s.cc.Invoke(s)
+	s.committed = nil
+	s.MockTransactionEnd(txId)
+
+	if res.Status != shim.OK {
+		s.restore(snapshot)
+	} else {
+		for _, key := range s.writeOrder {
+			value := s.writes[key]
+			s.history[key] = append(s.history[key], &queryresult.KeyModification{TxId: txId, Value: value,
+				Timestamp: &timestamp.Timestamp{Seconds: s.now.Unix()}, IsDelete: value == nil})
+		}
+	}
+	s.now = s.now.Add(time.Minute)
+	return res
+}
+
+func (s *testStub) restore(snapshot map[string][]byte) {
+	s.State = snapshot
+	keys := make([]string, 0, len(snapshot))
+	for key := range snapshot {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	s.Keys = list.New()
+	for _, key := range keys {
+		s.Keys.PushBack(key)
+	}
+}
+
+func (s *testStub) recordWrite(key string, value []byte) {
+	if _, written := s.writes[key]; !written {
+		s.writeOrder = append(s.writeOrder, key)
+	}
+	s.writes[key] = value
+}
+
+func (s *testStub) GetArgs() [][]byte {
+	return s.args
+}
+
+func (s *testStub) GetStringArgs() []string {
+	strargs := make([]string, 0, len(s.args))
+	for _, arg := range s.args {
+		strargs = append(strargs, string(arg))
+	}
+	return strargs
+}
+
+func (s *testStub) GetFunctionAndParameters() (string, []string) {
+	allargs := s.GetStringArgs()
+	if len(allargs) == 0 {
+		return "", []string{}
+	}
+	return allargs[0], allargs[1:]
+}
+
+// GetTransient returns the transient data set on the stub for the next invoke
+func (s *testStub) GetTransient() (map[string][]byte, error) {
+	return s.transient, nil
+}
+
+// GetCreator identifies the caller by a certificate carrying a userId attribute, the way
+// Fabric CA enrolls users. The certificate is issued to the caller unless commonName is set.
+func (s *testStub) GetCreator() ([]byte, error) {
+	commonName := s.commonName
+	if commonName == "" {
+		commonName = s.caller
+	}
+	certAsPem, err := certificateFor(commonName, s.caller)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(&msp.SerializedIdentity{Mspid: "Org1MSP", IdBytes: certAsPem})
+}
+
+var testCreatorKey, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+// certificateFor issues a PEM certificate to commonName carrying the userId attribute
+func certificateFor(commonName string, userId string) ([]byte, error) {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"org1.example.com"}},
+		NotBefore:    time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2029, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	if userId != "" {
+		attrs, err := json.Marshal(map[string]map[string]string{"attrs": {"userId": userId}})
+		if err != nil {
+			return nil, err
+		}
+		template.ExtraExtensions = []pkix.Extension{{Id: asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 7, 8, 1}, Value: attrs}}
+	}
+	certAsBytes, err := x509.CreateCertificate(rand.Reader, template, template, &testCreatorKey.PublicKey, testCreatorKey)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certAsBytes}), nil
+}
+
+// readState is the world state reads see: the state committed before the running
+// transaction, or the current state outside of one
+func (s *testStub) readState() map[string][]byte {
+	if s.committed != nil {
+		return s.committed
+	}
+	return s.State
+}
+
+func (s *testStub) GetState(key string) ([]byte, error) {
+	return s.readState()[key], nil
+}
+
+func (s *testStub) PutState(key string, value []byte) error {
+	err := s.MockStub.PutState(key, value)
+	if err == nil {
+		s.recordWrite(key, value)
+	}
+	return err
+}
+
+func (s *testStub) DelState(key string) error {
+	err := s.MockStub.DelState(key)
+	if err == nil {
+		s.recordWrite(key, nil)
+	}
+	return err
+}
+
+// sortedKeys lists the keys of the world state, composite keys included, in key order
+func (s *testStub) sortedKeys() []string {
+	state := s.readState()
+	keys := make([]string, 0, len(state))
+	for key := range state {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// rangeKeys lists the simple keys in [startKey, endKey). Like a peer, an empty startKey
+// skips the composite key namespace.
+func (s *testStub) rangeKeys(startKey string, endKey string) []string {
+	if startKey == "" {
+		startKey = "\x01"
+	}
+	var keys []string
+	for _, key := range s.sortedKeys() {
+		if key >= startKey && (endKey == "" || key < endKey) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func (s *testStub) kvs(keys []string) []*queryresult.KV {
+	kvs := make([]*queryresult.KV, 0, len(keys))
+	for _, key := range keys {
+		kvs = append(kvs, &queryresult.KV{Key: key, Value: s.readState()[key]})
+	}
+	return kvs
+}
+
+func (s *testStub) GetStateByRange(startKey string, endKey string) (shim.StateQueryIteratorInterface, error) {
+	return &testKVIterator{kvs: s.kvs(s.rangeKeys(startKey, endKey))}, nil
+}
+
+func (s *testStub) GetStateByPartialCompositeKey(objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+	prefix, err := s.CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, key := range s.sortedKeys() {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return &testKVIterator{kvs: s.kvs(keys)}, nil
+}
+
+func (s *testStub) GetStateByRangeWithPagination(startKey string, endKey string, pageSize int32,
+	bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	if bookmark != "" {
+		startKey = bookmark
+	}
+	keys := s.rangeKeys(startKey, endKey)
+	page, next := keys, ""
+	if int(pageSize) < len(keys) {
+		page, next = keys[:pageSize], keys[pageSize]
+	}
+	metadata := &pb.QueryResponseMetadata{FetchedRecordsCount: int32(len(page)), Bookmark: next}
+	return &testKVIterator{kvs: s.kvs(page)}, metadata, nil
+}
+
+// issuedQueries collects every valid rich query the suite runs, for TestMain to check against
+// the packaged CouchDB indexes
+var issuedQueries = make(map[string]bool)
+
+// GetQueryResult runs rich queries with the in-memory Mango evaluator
+func (s *testStub) GetQueryResult(query string) (shim.StateQueryIteratorInterface, error) {
+	if s.leveldb {
+		return nil, fmt.Errorf("ExecuteQuery not supported for leveldb")
+	}
+	kvs, _, err := executeMangoQuery(s.readState(), query, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	issuedQueries[query] = true
+	return &testKVIterator{kvs: kvs}, nil
+}
+
+func (s *testStub) GetQueryResultWithPagination(query string, pageSize int32,
+	bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	if s.leveldb {
+		return nil, nil, fmt.Errorf("ExecuteQueryWithMetadata not supported for leveldb")
+	}
+	kvs, next, err := executeMangoQuery(s.readState(), query, pageSize, bookmark)
+	if err != nil {
+		return nil, nil, err
+	}
+	issuedQueries[query] = true
+	metadata := &pb.QueryResponseMetadata{FetchedRecordsCount: int32(len(kvs)), Bookmark: next}
+	return &testKVIterator{kvs: kvs}, metadata, nil
+}
+
+func (s *testStub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	return &testHistoryIterator{mods: s.history[key]}, nil
+}
+
+type testKVIterator struct {
+	kvs  []*queryresult.KV
+	next int
+}
+
+func (it *testKVIterator) HasNext() bool {
+	return it.next < len(it.kvs)
+}
+
+func (it *testKVIterator) Next() (*queryresult.KV, error) {
+	if !it.HasNext() {
+		return nil, fmt.Errorf("iterator exhausted")
+	}
+	it.next++
+	return it.kvs[it.next-1], nil
+}
+
+func (it *testKVIterator) Close() error {
+	return nil
+}
+
+type testHistoryIterator struct {
+	mods []*queryresult.KeyModification
+	next int
+}
+
+func (it *testHistoryIterator) HasNext() bool {
+	return it.next < len(it.mods)
+}
+
+func (it *testHistoryIterator) Next() (*queryresult.KeyModification, error) {
+	if !it.HasNext() {
+		return nil, fmt.Errorf("iterator exhausted")
+	}
+	it.next++
+	return it.mods[it.next-1], nil
+}
+
+func (it *testHistoryIterator) Close() error {
+	return nil
+}
+
+// ===========================================================================================
+// Helpers
+// ===========================================================================================
+
+func mustInvoke(t *testing.T, s *testStub, function string, args ...string) []byte {
+	t.Helper()
+	res := s.invoke(function, args...)
+	if res.Status != shim.OK {
+		t.Fatalf("%s%v failed: %s", function, args, res.Message)
+	}
+	return res.Payload
+}
+
+func expectError(t *testing.T, s *testStub, message string, function string, args ...string) {
+	t.Helper()
+	res := s.invoke(function, args...)
+	if res.Status == shim.OK {
+		t.Fatalf("%s%v succeeded, expected error containing %q", function, args, message)
+	}
+	if !strings.Contains(res.Message, message) {
+		t.Fatalf("%s%v failed with %q, expected error containing %q", function, args, res.Message, message)
+	}
+}
+
+// newFixture registers the parties used by most tests and gives the goods owner credit
+func newFixture(t *testing.T) *testStub {
+	s := newTestStub()
+	mustInvoke(t, s, "initUser", "admin", "管理员", "admin", "13800000000", "true")
+	mustInvoke(t, s, "initUser", "owner", "货主", "goodsOwner", "13800000001", "true")
+	mustInvoke(t, s, "initUser", "broker", "承运人", "broker", "13800000002", "true")
+	mustInvoke(t, s, "initUser", "driver", "司机", "driver", "13800000003", "true")
+	mustInvoke(t, s, "initUser", "arbitrator", "仲裁员", "arbitrator", "13800000004", "true")
+	mustInvoke(t, s, "deposit", "owner", "100000")
+	return s
+}
+
+func createOrder(t *testing.T, s *testStub, orderId string) {
+	t.Helper()
+	mustInvoke(t, s, "initOrder", orderId, "上海", "北京", "煤炭", "20", "4000", "WAIT_DRIVER_ACCEPT", "owner", "broker", "driver")
+}
+
+func advanceOrder(t *testing.T, s *testStub, orderId string, states ...string) {
+	t.Helper()
+	for _, state := range states {
+		mustInvoke(t, s, "changeStateOrder", orderId, state)
+	}
+}
+
+var testConsigneeKey, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+// signOrder submits the consignee's proof of delivery of an arrived order as the consignee
+func signOrder(t *testing.T, s *testStub, orderId string) {
+	t.Helper()
+	args := podArgs(t, s, orderId)
+	caller := s.caller
+	var order Order
+	orderAsBytes, _ := s.GetState(orderId)
+	unmarshalPayload(t, orderAsBytes, &order)
+	s.caller = orderConsigneeId(&order)
+	mustInvoke(t, s, "signDelivery", args...)
+	s.caller = caller
+}
+
+// podArgs signs the delivery of an order with the consignee's key, registering the key
+// first unless it is already registered
+func podArgs(t *testing.T, s *testStub, orderId string) []string {
+	t.Helper()
+	var order Order
+	orderAsBytes, _ := s.GetState(orderId)
+	unmarshalPayload(t, orderAsBytes, &order)
+	consigneeId := orderConsigneeId(&order)
+	var consignee User
+	userAsBytes, _ := s.GetState(consigneeId)
+	unmarshalPayload(t, userAsBytes, &consignee)
+	if consignee.SigningKey == "" {
+		caller := s.caller
+		s.caller = "admin"
+		mustInvoke(t, s, "registerSigningKey", consigneeId, testPublicKeyPem(t, &testConsigneeKey.PublicKey))
+		s.caller = caller
+	}
+	weight := strconv.FormatFloat(order.WeightTon, 'f', -1, 64)
+	timestamp := s.now.Format(time.RFC3339)
+	digest := sha256.Sum256(podMessage(orderId, weight, timestamp))
+	signature, err := ecdsa.SignASN1(rand.Reader, testConsigneeKey, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []string{orderId, weight, timestamp, base64.StdEncoding.EncodeToString(signature)}
+}
+
+func testPublicKeyPem(t *testing.T, key interface{}) string {
+	t.Helper()
+	keyAsBytes, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: keyAsBytes}))
+}
+
+func deliverOrder(t *testing.T, s *testStub, orderId string) {
+	t.Helper()
+	advanceOrder(t, s, orderId, "DRIVER_ACCEPT_WAIT_ROAD", "DRIVER_ON_ROAD", "ARRIVED_WAIT_SIGN")
+}
+
+func readOrder(t *testing.T, s *testStub, orderId string) Order {
+	t.Helper()
+	var order Order
+	err := json.Unmarshal(mustInvoke(t, s, "readOrder", orderId), &order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return order
+}
+
+// unmarshalPage decodes a PaginatedResponse and its records into records
+func unmarshalPage(t *testing.T, payload []byte, records interface{}) PaginatedResponse {
+	t.Helper()
+	var page PaginatedResponse
+	unmarshalPayload(t, payload, &page)
+	if int(page.RecordsCount) != len(page.Records) {
+		t.Errorf("recordsCount %d does not match %d records", page.RecordsCount, len(page.Records))
+	}
+	recordsAsBytes, err := json.Marshal(page.Records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unmarshalPayload(t, recordsAsBytes, records)
+	return page
+}
+
+func unmarshalPayload(t *testing.T, payload []byte, v interface{}) {
+	t.Helper()
+	err := json.Unmarshal(payload, v)
+	if err != nil {
+		t.Fatalf("cannot decode %s: %s", payload, err.Error())
+	}
+}
+
+// ===========================================================================================
+// Tests
+// ===========================================================================================
+
+func TestInvokeUnknownFunction(t *testing.T) {
+	s := newTestStub()
+	expectError(t, s, "Received unknown function invocation", "transferOrder", "orderId0")
+}
+
+func TestInvokeDispatchesEveryFunction(t *testing.T) {
+	functions := []string{"initOrder", "initStringHash", "initFileHash", "initUser", "updateUser",
+		"readUser", "deleteUser", "delete", "changeStateOrder", "readOrder", "queryOrdersByBroker",
+		"queryAssets", "updatePositionOrder", "getHistoryForOrder", "getOrdersByRange",
+		"getOrdersByRangeWithPagination", "queryOrderDetail", "queryOrdersWithPagination",
+		"deposit", "withdraw", "readAccount", "queryLedgerEntries", "openDispute", "respondDispute",
+		"resolveDispute", "recordWeighbridge", "resolveWeightFlag", "cancelOrder",
+		"setOrderDeadlines", "queryOverdueOrders", "setConfig", "readConfig",
+		"queryOrdersByGoodsOwner", "queryOrdersByDriver", "queryOrdersByState", "queryRecords",
+		"searchOrders", "queryOrderStats", "compactOrderStats", "readPartySummary", "compactPartySummaries",
+		"getOrderAsOf", "activateUser", "suspendUser", "deactivateUser",
+		"whoAmI", "rotateUserCertificate", "initOrganization", "readOrganization", "setUserOrganization",
+		"initVehicle", "readVehicle", "assignVehicleDriver", "unassignVehicleDriver", "queryOrganizationDrivers",
+		"queryOrganizationVehicles", "queryOrganizationOrders", "updateOrder", "queryOrderAmendments",
+		"proposeAmendment", "approveAmendment", "rejectAmendment", "queryAmendmentProposals",
+		"registerSigningKey", "signDelivery", "addConsigneeDelegate", "removeConsigneeDelegate", "queryConsigneeDelegates",
+		"setConsigneeContact", "readConsigneeContact", "queryInboundOrders", "putRateCard", "readRateCard", "queryRateCards",
+		"quoteFee", "putGoods", "readGoods", "queryGoods", "setDriverCredential", "readDriverCredentialNo", "attachSafetyDocument",
+		"putLocation", "readLocation", "queryLocations"}
+	s := newTestStub()
+	for _, function := range functions {
+		res := s.invoke(function)
+		if res.Message == "Received unknown function invocation" {
+			t.Errorf("%s is not dispatched by Invoke", function)
+		}
+	}
+}
+
+func TestInitOrder(t *testing.T) {
+	s := newFixture(t)
+	createOrder(t, s, "order1")
+
+	order := readOrder(t, s, "order1")
+	if order.ObjectType != "order" || order.FromAddress != "上海" || order.WeightTon != 20 || order.TransFee != 4000 {
+		t.Errorf("unexpected order %+v", order)
+	}
+	if !order.Open || order.OrderState != "WAIT_DRIVER_ACCEPT" {
+		t.Errorf("new order should be open and waiting, got %+v", order)
+	}
+	if date := order.ChangeStateHistory["createOrder"]; date != "Mon, 01 Apr 2019 08:06:00 UTC" || order.CreateDate != date {
+		t.Errorf("createOrder should be stamped with the transaction time, got %v", order.ChangeStateHistory)
+	}
+
+	expectError(t, s, "This order already exists", "initOrder", "order1", "上海", "北京", "煤炭", "20", "4000", "WAIT_DRIVER_ACCEPT", "owner", "broker", "driver")
+	expectError(t, s, "Expecting 10 or 11", "initOrder", "order2", "上海")
+	expectError(t, s, "2nd argument must be a non-empty string", "initOrder", "order2", "", "北京", "煤炭", "20", "4000", "WAIT_DRIVER_ACCEPT", "owner", "broker", "driver")
+	expectError(t, s, "5th argument must be a numeric string", "initOrder", "order2", "上海", "北京", "煤炭", "twenty", "4000", "WAIT_DRIVER_ACCEPT", "owner", "broker", "driver")
+	expectError(t, s, "6th argument must not be negative", "initOrder", "order2", "上海", "北京", "煤炭", "20", "-1", "WAIT_DRIVER_ACCEPT", "owner", "broker", "driver")
+	expectError(t, s, "11th argument must be a JSON object", "initOrder", "order2", "上海", "北京", "煤炭", "20", "4000", "WAIT_DRIVER_ACCEPT", "owner", "broker", "driver", "{")
+	expectError(t, s, "7th argument must be a state before SIGNED", "initOrder", "order2", "上海", "北京", "煤炭", "20", "4000", "SIGNED", "owner", "broker", "driver")
+	expectError(t, s, "7th argument must be a state before SIGNED", "initOrder", "order2", "上海", "北京", "煤炭", "20", "4000", "FOO", "owner", "broker", "driver")
+	expectError(t, s, "7th argument must be a state before SIGNED", "initOrder", "order2", "上海", "北京", "煤炭", "20", "4000", "DISPUTED", "owner", "broker", "driver")
+	expectError(t, s, "Insufficient balance", "initOrder", "order2", "上海", "北京", "煤炭", "20", "400000", "WAIT_DRIVER_ACCEPT", "owner", "broker", "driver")
+
+	// the failed attempts must not leave anything behind
+	expectError(t, s, "Order does not exist", "readOrder", "order2")
+}
+
+func TestReadOrder(t *testing.T) {
+	s := newFixture(t)
+	createOrder(t, s, "order1")
+	if readOrder(t, s, "order1").OrderId != "order1" {
+		t.Error("readOrder returned the wrong order")
+	}
+	expectError(t, s, "Order does not exist: missing", "readOrder", "missing")
+	expectError(t, s, "Incorrect number of arguments", "readOrder")
+}
+
+func TestChangeStateOrder(t *testing.T) {
+	s := newFixture(t)
+	createOrder(t, s, "order1")
+	s.caller = "broker"
+	expectError(t, s, "User broker is not the driver of order order1", "changeStateOrder", "order1", "DRIVER_ACCEPT_WAIT_ROAD")
+
+	// states are normalized to upper case
+	s.caller = "driver"
+	advanceOrder(t, s, "order1", "driver_accept_wait_road", "DRIVER_ON_ROAD", "ARRIVED_WAIT_SIGN")
+	s.caller = "admin"
+	if order := readOrder(t, s, "order1"); order.OrderState != "ARRIVED_WAIT_SIGN" || !order.Open {
+		t.Fatalf("unexpected order %+v", order)
+	}
+
+	signOrder(t, s, "order1")
+	order := readOrder(t, s, "order1")
+	if order.OrderState != "SIGNED" || order.Open {
+		t.Errorf("a SIGNED order should be closed, got %+v", order)
+	}
+	for _, state := range []string{"DRIVER_ACCEPT_WAIT_ROAD", "DRIVER_ON_ROAD", "ARRIVED_WAIT_SIGN", "SIGNED"} {
+		if _, ok := order.ChangeStateHistory[state]; !ok {
+			t.Errorf("%s missing from history %v", state, order.ChangeStateHistory)
+		}
+	}
+
+	createOrder(t, s, "order2")
+	expectError(t, s, "cannot change from WAIT_DRIVER_ACCEPT to SIGNED", "changeStateOrder", "order2", "SIGNED")
+	expectError(t, s, "Order does not exist", "changeStateOrder", "missing", "SIGNED")
+	expectError(t, s, "Incorrect number of arguments", "changeStateOrder", "order2")
+}
+
+func TestDeleteOrder(t *testing.T) {
+	s := newFixture(t)
+	createOrder(t, s, "order1")
+	s.caller = "owner"
+	expectError(t, s, "Only an admin can call delete, owner is goodsOwner", "delete", "order1")
+	s.caller = "admin"
+	mustInvoke(t, s, "delete", "order1")
+	expectError(t, s, "Order does not exist", "readOrder", "order1")
+	expectError(t, s, "Order does not exist", "delete", "order1")
+	expectError(t, s, "Incorrect number of arguments", "delete")
+}
+
+func TestUpdatePositionOrder(t *testing.T) {
+	s := newFixture(t)
+	createOrder(t, s, "order1")
+	s.caller = "owner"
+	expectError(t, s, "User owner is not the driver of order order1", "updatePositionOrder", "position1", "order1", "1", "Mon, 01 Apr 2019 08:00:00 UTC", "上海")
+	s.caller = "driver"
+	mustInvoke(t, s, "updatePositionOrder", "position1", "order1", "1", "Mon, 01 Apr 2019 08:00:00 UTC", "上海")
+	s.caller = "admin"
+
+	// the position is its own record and leaves the order alone
+	var position UpdatePositionHistory
+	positionAsBytes, _ := s.GetState("position1")
+	unmarshalPayload(t, positionAsBytes, &position)
+	if position.ObjectType != "position" || position.OrderId != "order1" || position.PositionString != "上海" {
+		t.Errorf("unexpected position %+v", position)
+	}
+	if order := readOrder(t, s, "order1"); order.ObjectType != "order" || order.FromAddress != "上海" {
+		t.Errorf("order was overwritten: %+v", order)
+	}
+
+	expectError(t, s, "This position already exists", "updatePositionOrder", "position1", "order1", "2", "Mon, 01 Apr 2019 09:00:00 UTC", "南京")
+	expectError(t, s, "This position already exists", "updatePositionOrder", "order1", "order1", "2", "Mon, 01 Apr 2019 09:00:00 UTC", "南京")
+	expectError(t, s, "Order does not exist", "updatePositionOrder", "position2", "missing", "2", "Mon, 01 Apr 2019 09:00:00 UTC", "南京")
+	expectError(t, s, "5th argument must be a non-empty string", "updatePositionOrder", "position2", "order1", "2", "Mon, 01 Apr 2019 09:00:00 UTC", "")
+	expectError(t, s, "Incorrect number of arguments", "updatePositionOrder", "position2", "order1")
+}
+
+func TestInitStringHash(t *testing.T) {
+	s := newFixture(t)
+	createOrder(t, s, "order1")
+	mustInvoke(t, s, "initStringHash", "data1", "order1", "http://example.com/data1", "sha1", "装货照片")
+
+	var stringHash StringHash
+	stringAsBytes, _ := s.GetState("data1")
+	unmarshalPayload(t, stringAsBytes, &stringHash)
+	if stringHash.ObjectType != "stringHash" || stringHash.OrderId != "order1" || stringHash.ShaResult != "sha1" {
+		t.Errorf("unexpected string hash %+v", stringHash)
+	}
+
+	expectError(t, s, "This string already exists", "initStringHash", "data1", "order1", "", "sha2", "")
+	expectError(t, s, "This order not exists", "initStringHash", "data2", "missing", "", "sha2", "")
+	expectError(t, s, "4th argument must be a non-empty string", "initStringHash", "data2", "order1", "", "", "")
+	expectError(t, s, "Incorrect number of arguments", "initStringHash", "data2", "order1")
+}
+
+func TestInitFileHash(t *testing.T) {
+	s := newFixture(t)
+	createOrder(t, s, "order1")
+	mustInvoke(t, s, "initFileHash", "file1", "order1", "http://example.com/file1", "sha1", "回单", "true")
+	mustInvoke(t, s, "initFileHash", "file2", "driver", "http://example.com/file2", "sha2", "驾驶证", "false")
+
+	var fileHash FileHash
+	fileAsBytes, _ := s.GetState("file1")
+	unmarshalPayload(t, fileAsBytes, &fileHash)
+	if fileHash.ObjectType != "fileHashForOrder" {
+		t.Errorf("unexpected file hash %+v", fileHash)
+	}
+	fileAsBytes, _ = s.GetState("file2")
+	unmarshalPayload(t, fileAsBytes, &fileHash)
+	if fileHash.ObjectType != "fileHashForUser" {
+		t.Errorf("unexpected file hash %+v", fileHash)
+	}
+
+	expectError(t, s, "This file already exists", "initFileHash", "file1", "order1", "", "sha3", "回单", "true")
+	expectError(t, s, "This order not exists", "initFileHash", "file3", "missing", "", "sha3", "回单", "true")
+	expectError(t, s, "5th argument must be a non-empty string", "initFileHash", "file3", "order1", "", "sha3", "", "true")
+	expectError(t, s, "Incorrect number of arguments", "initFileHash", "file3", "order1")
+}
+
+func TestUserLifecycle(t *testing.T) {
+	s := newTestStub()
+	s.caller = "driver"
+	mustInvoke(t, s, "initUser", "driver", "司机", "driver", "13800000003", "true")
+	expectError(t, s, "This user exists", "initUser", "driver", "司机", "driver", "13800000003", "true")
+	expectError(t, s, "5th argument must be a non-empty string", "initUser", "driver2", "司机", "driver", "13800000003", "")
+	expectError(t, s, "4th argument must be a non-empty string", "initUser", "driver2", "司机", "driver", "", "true")
+	expectError(t, s, "Incorrect number of arguments", "initUser", "driver2")
+	expectError(t, s, "5th argument must be true or false", "initUser", "driver2", "司机", "driver", "13800000003", "yes")
+	expectError(t, s, "Role must be", "initUser", "driver2", "司机", "trucker", "13800000003", "true")
+
+	mustInvoke(t, s, "initFileHash", "license1", "driver", "http://example.com/license", "sha1", "驾驶证", "false")
+	mustInvoke(t, s, "updateUser", "driver", "王师傅", "driver", "13900000003")
+
+	var userGenerated UserGenerated
+	unmarshalPayload(t, mustInvoke(t, s, "readUser", "driver"), &userGenerated)
+	if userGenerated.User.UserName != "王师傅" || userGenerated.User.Telephone != "13900000003" || !userGenerated.User.Valid {
+		t.Errorf("unexpected user %+v", userGenerated.User)
+	}
+	if len(userGenerated.File) != 1 || userGenerated.File[0].FileId != "license1" {
+		t.Errorf("unexpected user files %+v", userGenerated.File)
+	}
+
+	expectError(t, s, "does not exist", "updateUser", "missing", "x", "driver", "1")
+	expectError(t, s, "Role must be", "updateUser", "driver", "王师傅", "trucker", "13900000003")
+	expectError(t, s, "Incorrect number of arguments", "updateUser", "driver", "王师傅", "driver", "13900000003", "false")
+	expectError(t, s, "User driver cannot read user missing", "readUser", "missing")
+	expectError(t, s, "Incorrect number of arguments", "readUser")
+
+	s.caller = "admin"
+	mustInvoke(t, s, "initUser", "admin", "管理员", "admin", "13800000000", "true")
+	expectError(t, s, "User does not exist", "readUser", "missing")
+	mustInvoke(t, s, "deleteUser", "driver")
+	unmarshalPayload(t, mustInvoke(t, s, "readUser", "driver"), &userGenerated)
+	if userGenerated.User.Status != "DEACTIVATED" || userGenerated.User.Valid || userGenerated.User.StatusReason != "deleted" {
+		t.Errorf("deleting a user should deactivate it, got %+v", userGenerated.User)
+	}
+	expectError(t, s, "cannot change from DEACTIVATED", "deleteUser", "driver")
+	expectError(t, s, "does not exist", "deleteUser", "missing")
+}
+
+func TestQueryOrdersByBroker(t *testing.T) {
+	s := newFixture(t)
+	createOrder(t, s, "order1")
+	createOrder(t, s, "order2")
+	mustInvoke(t, s, "initOrder", "order3", "上海", "北京", "煤炭", "20", "4000", "WAIT_DRIVER_ACCEPT", "owner", "broker2", "driver")
+
+	var orders []OrderWithKey
+	unmarshalPage(t, mustInvoke(t, s, "queryOrdersByBroker", "broker"), &orders)
+	if len(orders) != 2 || orders[0].Key != "order1" || orders[1].Key != "order2" {
+		t.Errorf("unexpected orders %+v", orders)
+	}
+	page := unmarshalPage(t, mustInvoke(t, s, "queryOrdersByBroker", "broker", "1", ""), &orders)
+	if len(orders) != 1 || orders[0].Key != "order1" || !page.HasMore {
+		t.Errorf("unexpected first page %+v %+v", page, orders)
+	}
+	page = unmarshalPage(t, mustInvoke(t, s, "queryOrdersByBroker", "broker", "1", page.Bookmark), &orders)
+	if len(orders) != 1 || orders[0].Key != "order2" || page.HasMore {
+		t.Errorf("unexpected last page %+v %+v", page, orders)
+	}
+	expectError(t, s, "Incorrect number of arguments", "queryOrdersByBroker")
+	expectError(t, s, "Incorrect number of arguments", "queryOrdersByBroker", "broker", "1")
+	expectError(t, s, "pageSize must be a positive integer", "queryOrdersByBroker", "broker", "0", "")
+}
+
+func TestQueryOrdersByPartyAndState(t *testing.T) {
+	s := newFixture(t)
+	createOrder(t, s, "order1")
+	createOrder(t, s, "order2")
+	mustInvoke(t, s, "initOrder", "order3", "上海", "北京", "煤炭", "20", "4000", "WAIT_DRIVER_ACCEPT", "owner", "broker", "driver2")
+	advanceOrder(t, s, "order2", "DRIVER_ACCEPT_WAIT_ROAD", "DRIVER_ON_ROAD")
+
+	var orders []OrderWithKey
+	unmarshalPage(t, mustInvoke(t, s, "queryOrdersByGoodsOwner", "owner"), &orders)
+	if len(orders) != 3 {
+		t.Errorf("unexpected owner orders %+v", orders)
+	}
+	unmarshalPage(t, mustInvoke(t, s, "queryOrdersByDriver", "driver2"), &orders)
+	if len(orders) != 1 || orders[0].Key != "order3" {
+		t.Errorf("unexpected driver orders %+v", orders)
+	}
+	unmarshalPage(t, mustInvoke(t, s, "queryOrdersByState", "driver_on_road", "10", ""), &orders)
+	if len(orders) != 1 || orders[0].Key != "order2" {
+		t.Errorf("unexpected orders on road %+v", orders)
+	}
+	expectError(t, s, "Incorrect number of arguments", "queryOrdersByGoodsOwner")
+	expectError(t, s, "Incorrect number of arguments", "queryOrdersByDriver")
+	expectError(t, s, "Incorrect number of arguments", "queryOrdersByState")
+}
+
+func TestQueryAssets(t *testing.T) {
+	s := newFixture(t)
+	createOrder(t, s, "order1")
+	mustInvoke(t, s, "updatePositionOrder", "position1", "order1", "1", "Mon, 01 Apr 2019 08:00:00 UTC", "上海")
+
+	var positions []PositionWithKey
+	unmarshalPage(t, mustInvoke(t, s, "queryAssets", `{"selector":{"docType":"position","orderId":"order1"}}`), &positions)
+	if len(positions) != 1 || positions[0].Record.PositionId != "position1" {
+		t.Errorf("unexpected positions %+v", positions)
+	}
+	expectError(t, s, "invalid query", "queryAssets", "{")
+	expectError(t, s, "Incorrect number of arguments", "queryAssets")
+}
+
+func TestQueryOrderDetail(t *testing.T) {
+	s := newFixture(t)
+	createOrder(t, s, "order1")
+	mustInvoke(t, s, "initStringHash", "data1", "order1", "http://example.com/data1", "sha1", "装货照片")
+	mustInvoke(t, s, "initFileHash", "file1", "order1", "http://example.com/file1", "sha2", "回单", "true")
+	mustInvoke(t, s, "updatePositionOrder", "position1", "order1", "1", "Mon, 01 Apr 2019 08:00:00 UTC", "上海")
+
+	var detail AutoGenerated
+	unmarshalPayload(t, mustInvoke(t, s, "queryOrderDetail", "order1"), &detail)
+	if detail.Order.OrderId != "order1" {
+		t.Errorf("unexpected order %+v", detail.Order)
+	}
+	if len(detail.String) != 1 || detail.String[0].DataId != "data1" {
+		t.Errorf("unexpected strings %+v", detail.String)
+	}
+	if len(detail.File) != 1 || detail.File[0].FileId != "file1" {
+		t.Errorf("unexpected files %+v", detail.File)
+	}
+	if len(detail.Position) != 1 || detail.Position[0].PositionString != "上海" {
+		t.Errorf("unexpected positions %+v", detail.Position)
+	}
+	if detail.Escrow == nil || detail.Escrow.EscrowState != "LOCKED" || len(detail.Ledger) != 1 {
+		t.Errorf("unexpected escrow %+v and ledger %+v", detail.Escrow, detail.Ledger)
+	}
+
+	expectError(t, s, "Order does not exist", "queryOrderDetail", "missing")
+	expectError(t, s, "Incorrect number of arguments", "queryOrderDetail")
+}
+
+func TestGetHistoryForOrder(t *testing.T) {
+	s := newFixture(t)
+	createOrder(t, s, "order1")
+	advanceOrder(t, s, "order1", "DRIVER_ACCEPT_WAIT_ROAD")
+	mustInvoke(t, s, "delete", "order1")
+
+	var history []struct {
+		Key    string
+		Record OrderHistoryEntry
+	}
+	page := unmarshalPage(t, mustInvoke(t, s, "getHistoryForOrder", "order1"), &history)
+	if len(history) != 3 || page.HasMore {
+		t.Fatalf("expected 3 history entries, got %+v", history)
+	}
+	created, changed, deleted := history[0].Record, history[1].Record, history[2].Record
+	if created.Order.OrderState != "WAIT_DRIVER_ACCEPT" || len(created.Changes) != 0 || created.Timestamp != "2019-04-01T08:06:00Z" {
+		t.Errorf("unexpected first entry %+v", created)
+	}
+	if changed.Order.OrderState != "DRIVER_ACCEPT_WAIT_ROAD" || history[1].Key != changed.TxId || changed.IsDelete {
+		t.Errorf("unexpected second entry %+v", changed)
+	}
+	stateChanged := false
+	for _, change := range changed.Changes {
+		if change.Field == "orderState" {
+			stateChanged = change.From == "WAIT_DRIVER_ACCEPT" && change.To == "DRIVER_ACCEPT_WAIT_ROAD"
+		}
+		if change.Field == "content" {
+			t.Errorf("unchanged field reported %+v", change)
+		}
+	}
+	if !stateChanged {
+		t.Errorf("expected an orderState change, got %+v", changed.Changes)
+	}
+	if deleted.Order != nil || !deleted.IsDelete {
+		t.Errorf("last entry should be the delete, got %+v", deleted)
+	}
+
+	page = unmarshalPage(t, mustInvoke(t, s, "getHistoryForOrder", "order1", "", "", "2", ""), &history)
+	if len(history) != 2 || !page.HasMore || page.Bookmark != changed.TxId {
+		t.Errorf("unexpected first page %+v", page)
+	}
+	page = unmarshalPage(t, mustInvoke(t, s, "getHistoryForOrder", "order1", "", "", "2", page.Bookmark), &history)
+	if len(history) != 1 || !history[0].Record.IsDelete || page.HasMore {
+		t.Errorf("unexpected second page %+v", page)
+	}
+	unmarshalPage(t, mustInvoke(t, s, "getHistoryForOrder", "order1", "2019-04-01T16:07:00+08:00", "2019-04-01T08:08:00Z"), &history)
+	if len(history) != 1 || history[0].Record.TxId != changed.TxId {
+		t.Errorf("expected only the state change in the window, got %+v", history)
+	}
+	expectError(t, s, "Bookmark tx999 is not in the results any more", "getHistoryForOrder", "order1", "", "", "2", "tx999")
+	expectError(t, s, "Incorrect number of arguments", "getHistoryForOrder")
+	expectError(t, s, "Incorrect number of arguments", "getHistoryForOrder", "order1", "")
+	expectError(t, s, "RFC3339", "getHistoryForOrder", "order1", "yesterday", "")
+}
+
+func TestGetOrdersByRange(t *testing.T) {
+	s := newFixture(t)
+	createOrder(t, s, "order1")
+	createOrder(t, s, "order2")
+	createOrder(t, s, "order3")
+
+	var orders []OrderWithKey
+	unmarshalPage(t, mustInvoke(t, s, "getOrdersByRange", "order1", "order3"), &orders)
+	if len(orders) != 2 || orders[0].Key != "order1" || orders[1].Key != "order2" {
+		t.Errorf("unexpected orders %+v", orders)
+	}
+	page := unmarshalPage(t, mustInvoke(t, s, "getOrdersByRange", "order1", "order3", "1", ""), &orders)
+	if len(orders) != 1 || orders[0].Key != "order1" || !page.HasMore {
+		t.Errorf("unexpected page %+v %+v", page, orders)
+	}
+	expectError(t, s, "Incorrect number of arguments", "getOrdersByRange", "order1")
+}
+
+func TestGetOrdersByRangeWithPagination(t *testing.T) {
+	s := newFixture(t)
+	createOrder(t, s, "order1")
+	createOrder(t, s, "order2")
+	createOrder(t, s, "order3")
+
+	var orders []OrderWithKey
+	page := unmarshalPage(t, mustInvoke(t, s, "getOrdersByRangeWithPagination", "order1", "order4", "2", ""), &orders)
+	if len(orders) != 2 || orders[0].Key != "order1" || orders[1].Key != "order2" {
+		t.Errorf("unexpected first page %+v", orders)
+	}
+	if page.RecordsCount != 2 || page.Bookmark != "order3" || !page.HasMore {
+		t.Errorf("unexpected first page metadata %+v", page)
+	}
+	page = unmarshalPage(t, mustInvoke(t, s, "getOrdersByRangeWithPagination", "order1", "order4", "2", page.Bookmark), &orders)
+	if len(orders) != 1 || orders[0].Key != "order3" || page.Bookmark != "" || page.HasMore {
+		t.Errorf("unexpected second page %+v %+v", page, orders)
+	}
+	expectError(t, s, "pageSize must be a positive integer", "getOrdersByRangeWithPagination", "order1", "order4", "two", "")
+	expectError(t, s, "Incorrect number of arguments", "getOrdersByRangeWithPagination", "order1", "order4")
+}
+
+func TestQueryOrdersWithPagination(t *testing.T) {
+	s := newFixture(t)
+	createOrder(t, s, "order1")
+	createOrder(t, s, "order2")
+	createOrder(t, s, "order3")
+	createOrder(t, s, "order4")
+
+	query := `{"selector":{"docType":"order"}}`
+	var orders []OrderWithKey
+	page := unmarshalPage(t, mustInvoke(t, s, "queryOrdersWithPagination", query, "2", ""), &orders)
+	if len(orders) != 2 || orders[0].Key != "order1" || orders[1].Key != "order2" || !page.HasMore {
+		t.Errorf("unexpected first page %+v %+v", page, orders)
+	}
+	page = unmarshalPage(t, mustInvoke(t, s, "queryOrdersWithPagination", query, "2", page.Bookmark), &orders)
+	if len(orders) != 2 || orders[0].Key != "order3" || page.HasMore {
+		t.Errorf("a full last page should not report more records, got %+v %+v", page, orders)
+	}
+	page = unmarshalPage(t, mustInvoke(t, s, "queryOrdersWithPagination", query, "2", page.Bookmark), &orders)
+	if len(orders) != 0 || page.RecordsCount != 0 || page.HasMore {
+		t.Errorf("unexpected page after the end %+v %+v", page, orders)
+	}
+	expectError(t, s, "pageSize must be a positive integer", "queryOrdersWithPagination", query, "two", "")
+	expectError(t, s, "Incorrect number of arguments", "queryOrdersWithPagination", query)
+}
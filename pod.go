@@ -0,0 +1,193 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"github.com/tjfoc/gmsm/sm2"
+	gmx509 "github.com/tjfoc/gmsm/x509"
+)
+
+// ====PROOF OF DELIVERY (CLI) =============================================================
+// 登记签名公钥 peer chaincode invoke -C myc1 -n orders -c '{"Args":["registerSigningKey","goodsOwnerId","-----BEGIN PUBLIC KEY-----\n...\n-----END PUBLIC KEY-----"]}'
+// 电子签收 peer chaincode invoke -C myc1 -n orders -c '{"Args":["signDelivery","orderId0","19.95","2019-04-02T10:00:00+08:00","MEUCIQ..."]}'
+//
+// An order is SIGNED only with a proof of delivery: the consignee, or a delegate of theirs,
+// signs "orderId|receivedWeightTon|timestamp", the three arguments of signDelivery exactly
+// as submitted, with the key they registered and submits it themselves. ECDSA P-256
+// signatures are over the SHA-256 digest, SM2 signatures over the SM3 digest with the
+// default user id; both are base64 encoded ASN.1 DER. The timestamp cannot be before the
+// order arrived nor later than the transaction. The verified proof, with the key it was
+// verified with, is stored on the order. changeStateOrder no longer moves an order to SIGNED.
+// =========================================================================================
+
+// podClockSkew is how far a signature timestamp may be ahead of the transaction time
+const podClockSkew = 5 * time.Minute
+
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+// parseSigningKey reads a PEM public key, telling ECDSA P-256 and SM2 keys apart
+func parseSigningKey(keyPem string) (interface{}, string, error) {
+	block, _ := pem.Decode([]byte(keyPem))
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, "", fmt.Errorf("Signing key must be a PEM encoded public key")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err == nil {
+		ecdsaKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || ecdsaKey.Curve != elliptic.P256() {
+			return nil, "", fmt.Errorf("Signing key must be an ECDSA P-256 or SM2 key")
+		}
+		return ecdsaKey, "ECDSA_P256", nil
+	}
+	sm2Key, err := gmx509.ParseSm2PublicKey(block.Bytes)
+	if err != nil || sm2Key.X == nil {
+		return nil, "", fmt.Errorf("Signing key must be an ECDSA P-256 or SM2 key")
+	}
+	return sm2Key, "SM2", nil
+}
+
+// verifySignature checks a base64 ASN.1 DER signature of message
+func verifySignature(keyPem string, message []byte, signatureBase64 string) (string, error) {
+	key, algorithm, err := parseSigningKey(keyPem)
+	if err != nil {
+		return "", err
+	}
+	signature, err := base64.StdEncoding.DecodeString(signatureBase64)
+	if err != nil {
+		return "", fmt.Errorf("Signature must be base64 encoded")
+	}
+	var valid bool
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		var rs ecdsaSignature
+		rest, err := asn1.Unmarshal(signature, &rs)
+		if err == nil && len(rest) == 0 && rs.R != nil && rs.S != nil {
+			digest := sha256.Sum256(message)
+			valid = ecdsa.Verify(key, digest[:], rs.R, rs.S)
+		}
+	case *sm2.PublicKey:
+		valid = key.Verify(message, signature)
+	}
+	if !valid {
+		return "", fmt.Errorf("The %s signature does not verify", algorithm)
+	}
+	return algorithm, nil
+}
+
+// podMessage is what the consignee signs
+func podMessage(orderId string, receivedWeightTon string, timestamp string) []byte {
+	return []byte(orderId + "|" + receivedWeightTon + "|" + timestamp)
+}
+
+// ============================================================
+// registerSigningKey - register the public key a user signs proofs of delivery with
+// ============================================================
+func (t *SimpleChaincode) registerSigningKey(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0       		1
+	// "goodsOwnerId", "-----BEGIN PUBLIC KEY-----..."
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	userId, err := actingUserId(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	_, algorithm, err := parseSigningKey(args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	user, err := getUser(stub, userId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	user.SigningKey = args[1]
+	user.SigningAlgorithm = algorithm
+	err = putUser(stub, user)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ============================================================
+// signDelivery - sign an arrived order with the consignee's proof of delivery
+// ============================================================
+func (t *SimpleChaincode) signDelivery(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0       		1		2								3
+	// "orderId0", "19.95", "2019-04-02T10:00:00+08:00", "base64 signature"
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+	fmt.Println("- start signDelivery")
+	orderId := args[0]
+	receivedWeightTon, err := strconv.ParseFloat(args[1], 64)
+	if err != nil || receivedWeightTon < 0 {
+		return shim.Error("2nd argument must be a non-negative number")
+	}
+	signedAt, err := time.Parse(time.RFC3339, args[2])
+	if err != nil {
+		return shim.Error("3rd argument must be an RFC3339 time")
+	}
+	caller, err := getCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	order, err := getOrder(stub, orderId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	consigneeId := orderConsigneeId(order)
+	if caller.UserId != consigneeId {
+		delegated, err := isConsigneeDelegate(stub, consigneeId, caller.UserId)
+		if err != nil {
+			return shim.Error(err.Error())
+		} else if !delegated {
+			return shim.Error("Only consignee " + consigneeId + " or a delegate of theirs can sign order " + orderId)
+		}
+	}
+	if order.OrderState != "ARRIVED_WAIT_SIGN" {
+		return shim.Error("Order " + orderId + " cannot change from " + order.OrderState + " to SIGNED")
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if signedAt.After(txTime.Add(podClockSkew)) {
+		return shim.Error("The proof of delivery is signed in the future: " + args[2])
+	}
+	arrivedAt, err := time.Parse(time.RFC3339, order.StateEnteredAt["ARRIVED_WAIT_SIGN"])
+	if err == nil && signedAt.Before(arrivedAt) {
+		return shim.Error("The proof of delivery is signed before the order arrived at " + arrivedAt.Format(time.RFC3339))
+	}
+
+	if caller.SigningKey == "" {
+		return shim.Error("User " + caller.UserId + " has not registered a signing key")
+	}
+	algorithm, err := verifySignature(caller.SigningKey, podMessage(orderId, args[1], args[2]), args[3])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	order.ProofOfDelivery = &ProofOfDelivery{ConsigneeId: consigneeId, ReceivedWeightTon: receivedWeightTon,
+		SignedAt: args[2], Algorithm: algorithm, PublicKey: caller.SigningKey, Signature: args[3],
+		SignedBy: caller.UserId}
+	res := transitionOrder(stub, *order, "SIGNED")
+	if res.Status != shim.OK {
+		return res
+	}
+	fmt.Println("- end signDelivery (success)")
+	return shim.Success(nil)
+}
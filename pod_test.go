@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/tjfoc/gmsm/sm2"
+	gmx509 "github.com/tjfoc/gmsm/x509"
+)
+
+func TestSignDeliveryWithECDSA(t *testing.T) {
+	s := newFixture(t)
+	createOrder(t, s, "order1")
+	deliverOrder(t, s, "order1")
+	s.caller = "owner"
+	expectError(t, s, "User owner has not registered a signing key", "signDelivery", "order1", "20", "2019-04-01T08:10:00Z", "AA==")
+
+	args := podArgs(t, s, "order1")
+	tampered := append([]string{}, args...)
+	tampered[1] = "25"
+	expectError(t, s, "The ECDSA_P256 signature does not verify", "signDelivery", tampered...)
+	expectError(t, s, "Signature must be base64 encoded", "signDelivery", args[0], args[1], args[2], "%%%")
+	for _, caller := range []string{"driver", "arbitrator", "admin"} {
+		s.caller = caller
+		expectError(t, s, "Only consignee owner or a delegate of theirs can sign order order1", "signDelivery", args...)
+	}
+	s.caller = "owner"
+	mustInvoke(t, s, "signDelivery", args...)
+
+	order := readOrder(t, s, "order1")
+	pod := order.ProofOfDelivery
+	if order.OrderState != "SIGNED" || order.Open || pod == nil || pod.ConsigneeId != "owner" || pod.ReceivedWeightTon != 20 ||
+		pod.Algorithm != "ECDSA_P256" || pod.Signature != args[3] || pod.SignedAt != args[2] || pod.SignedBy != "owner" {
+		t.Errorf("unexpected signed order %+v, proof %+v", order, pod)
+	}
+	expectError(t, s, "cannot change from SIGNED to SIGNED", "signDelivery", args...)
+}
+
+func TestSignDeliveryWithSM2(t *testing.T) {
+	s := newFixture(t)
+	key, err := sm2.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPem, err := gmx509.WritePublicKeyToPem(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.caller = "owner"
+	mustInvoke(t, s, "registerSigningKey", "", string(keyPem))
+	if user := readUser(t, s, "owner"); user.SigningAlgorithm != "SM2" {
+		t.Errorf("unexpected signing key of %+v", user)
+	}
+	s.caller = "admin"
+	createOrder(t, s, "order1")
+	deliverOrder(t, s, "order1")
+	s.caller = "owner"
+
+	timestamp := s.now.Format(time.RFC3339)
+	signature, err := key.Sign(rand.Reader, podMessage("order1", "19.95", timestamp), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectError(t, s, "The SM2 signature does not verify",
+		"signDelivery", "order1", "19.9", timestamp, base64.StdEncoding.EncodeToString(signature))
+	mustInvoke(t, s, "signDelivery", "order1", "19.95", timestamp, base64.StdEncoding.EncodeToString(signature))
+	if order := readOrder(t, s, "order1"); order.ProofOfDelivery == nil || order.ProofOfDelivery.Algorithm != "SM2" ||
+		order.ProofOfDelivery.ReceivedWeightTon != 19.95 {
+		t.Errorf("unexpected proof of delivery %+v", order.ProofOfDelivery)
+	}
+}
+
+func TestSignDeliveryTimestamps(t *testing.T) {
+	s := newFixture(t)
+	createOrder(t, s, "order1")
+	deliverOrder(t, s, "order1")
+	args := podArgs(t, s, "order1")
+	s.caller = "owner"
+
+	sign := func(timestamp string) []string {
+		digest := sha256.Sum256(podMessage("order1", args[1], timestamp))
+		signature, err := ecdsa.SignASN1(rand.Reader, testConsigneeKey, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return []string{"order1", args[1], timestamp, base64.StdEncoding.EncodeToString(signature)}
+	}
+	expectError(t, s, "signed in the future", "signDelivery", sign(s.now.Add(time.Hour).Format(time.RFC3339))...)
+	expectError(t, s, "signed before the order arrived", "signDelivery", sign("2019-04-01T08:00:00Z")...)
+	expectError(t, s, "3rd argument must be an RFC3339 time", "signDelivery", "order1", "20", "yesterday", "AA==")
+	mustInvoke(t, s, "signDelivery", args...)
+}
+
+func TestRegisterSigningKey(t *testing.T) {
+	s := newFixture(t)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectError(t, s, "Signing key must be an ECDSA P-256 or SM2 key", "registerSigningKey", "owner", testPublicKeyPem(t, &rsaKey.PublicKey))
+	expectError(t, s, "Signing key must be an ECDSA P-256 or SM2 key", "registerSigningKey", "owner", testPublicKeyPem(t, &p384Key.PublicKey))
+	expectError(t, s, "Signing key must be a PEM encoded public key", "registerSigningKey", "owner", "key")
+	s.caller = "broker"
+	expectError(t, s, "User broker cannot act as owner", "registerSigningKey", "owner", testPublicKeyPem(t, &testConsigneeKey.PublicKey))
+
+	s.caller = "admin"
+	createOrder(t, s, "order1")
+	deliverOrder(t, s, "order1")
+	expectError(t, s, "is signed by submitting a proof of delivery", "changeStateOrder", "order1", "SIGNED")
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ====QUERIES (CLI) =======================================================================
+// 按条件查询 peer chaincode query -C myc1 -n orders -c '{"Args":["queryRecords","{\"docType\":\"order\",\"party\":\"brokerId0\",\"partyRole\":\"broker\",\"states\":[\"DRIVER_ON_ROAD\"],\"createdFrom\":\"2019-04-01T00:00:00+08:00\"}","10",""]}'
+// 查询运单轨迹 peer chaincode query -C myc1 -n orders -c '{"Args":["queryRecords","{\"docType\":\"position\",\"orderId\":\"orderId0\"}"]}'
+//
+// Clients query through named filters instead of Mango selectors. The chaincode builds the
+// selector itself, so values are always escaped, and narrows it to what the caller may read:
+// admins read everything, arbitrators every order, other users the orders they are a party
+// of, their own user record and their own ledger entries. The caller is the registered user
+// bound to the submitting certificate (see identity.go). Raw selectors (queryAssets,
+// queryOrdersWithPagination) are left to admins.
+// =========================================================================================
+
+// getCaller returns the registered user that submitted the transaction
+func getCaller(stub shim.ChaincodeStubInterface) (*User, error) {
+	user, err := registeredCaller(stub)
+	if err != nil {
+		return nil, err
+	} else if user == nil {
+		userId, found, err := cid.GetAttributeValue(stub, "userId")
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read caller identity: %s", err.Error())
+		} else if !found {
+			return nil, fmt.Errorf("Caller certificate is not bound to a user and has no userId attribute")
+		}
+		return nil, fmt.Errorf("User does not exist: %s", userId)
+	}
+	if !user.Valid {
+		return nil, fmt.Errorf("User %s is not valid", user.UserId)
+	}
+	return user, nil
+}
+
+func isAdmin(user *User) bool {
+	return user.Role == "admin"
+}
+
+// requireAdmin refuses the transaction unless an admin submitted it
+func requireAdmin(stub shim.ChaincodeStubInterface) error {
+	caller, err := getCaller(stub)
+	if err != nil {
+		return err
+	}
+	if !isAdmin(caller) {
+		function, _ := stub.GetFunctionAndParameters()
+		return fmt.Errorf("Only an admin can call %s, %s is %s", function, caller.UserId, caller.Role)
+	}
+	return nil
+}
+
+// canReadOrder lets admins and arbitrators read every order and other users their own
+func canReadOrder(caller *User, order *Order) bool {
+	if isAdmin(caller) || caller.Role == "arbitrator" {
+		return true
+	}
+	return caller.UserId == order.GoodsOwnerId || caller.UserId == order.BrokerId || caller.UserId == order.DriverId ||
+		caller.UserId == order.ConsigneeId
+}
+
+// requireOrderRole checks that the caller is an admin or one of the given parties of an order
+func requireOrderRole(stub shim.ChaincodeStubInterface, order *Order, roles ...string) error {
+	caller, err := getCaller(stub)
+	if err != nil {
+		return err
+	}
+	if isAdmin(caller) {
+		return nil
+	}
+	return requirePartyRole(order, caller.UserId, roles...)
+}
+
+// requirePartyRole checks that a user is one of the given parties of an order. Handlers that
+// act for a user named with actingUserId check that user, whoever the caller is.
+func requirePartyRole(order *Order, userId string, roles ...string) error {
+	for _, party := range orderParties(order) {
+		for _, role := range roles {
+			if party.role == role && party.partyId == userId {
+				return nil
+			}
+		}
+	}
+	named := roles[len(roles)-1]
+	if len(roles) > 1 {
+		named = strings.Join(roles[:len(roles)-1], ", ") + " or " + named
+	}
+	return fmt.Errorf("User %s is not the %s of order %s", userId, named, order.OrderId)
+}
+
+// buildQueryString wraps a selector in a query. Values given by clients are escaped by
+// the marshalling, so they cannot change the shape of the selector.
+func buildQueryString(selector map[string]interface{}) (string, error) {
+	return buildSortedQueryString(selector, nil)
+}
+
+// buildSortedQueryString wraps a selector and an optional sort in a query
+func buildSortedQueryString(selector map[string]interface{}, sort []interface{}) (string, error) {
+	query := map[string]interface{}{"selector": selector}
+	if len(sort) > 0 {
+		query["sort"] = sort
+	}
+	queryAsBytes, err := json.Marshal(query)
+	if err != nil {
+		return "", err
+	}
+	return string(queryAsBytes), nil
+}
+
+// decodeRecordFilter reads a filter, refusing fields it does not know
+func decodeRecordFilter(arg string) (*RecordFilter, error) {
+	filter := &RecordFilter{}
+	decoder := json.NewDecoder(bytes.NewReader([]byte(arg)))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(filter)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid filter %s: %s", arg, err.Error())
+	}
+	return filter, nil
+}
+
+func parseRecordFilter(arg string) (*RecordFilter, error) {
+	filter, err := decodeRecordFilter(arg)
+	if err != nil {
+		return nil, err
+	}
+	if len(filter.DocType) == 0 {
+		return nil, fmt.Errorf("Filter docType is required")
+	}
+	return filter, nil
+}
+
+// partyFields names the order fields a party filter matches for a role
+func partyFields(partyRole string) ([]string, error) {
+	switch partyRole {
+	case "":
+		return []string{"goodsOwnerId", "brokerId", "driverId", "consigneeId"}, nil
+	case "goodsOwner":
+		return []string{"goodsOwnerId"}, nil
+	case "broker":
+		return []string{"brokerId"}, nil
+	case "driver":
+		return []string{"driverId"}, nil
+	case "consignee":
+		return []string{"consigneeId"}, nil
+	}
+	return nil, fmt.Errorf("partyRole must be goodsOwner, broker, driver or consignee, got %s", partyRole)
+}
+
+// anyOf matches value against any of fields
+func anyOf(fields []string, value string) map[string]interface{} {
+	if len(fields) == 1 {
+		return map[string]interface{}{fields[0]: value}
+	}
+	clauses := make([]interface{}, 0, len(fields))
+	for _, field := range fields {
+		clauses = append(clauses, map[string]interface{}{field: value})
+	}
+	return map[string]interface{}{"$or": clauses}
+}
+
+// orderSelector translates the filters of an order query
+func orderSelector(caller *User, filter *RecordFilter) (map[string]interface{}, error) {
+	clauses := []interface{}{map[string]interface{}{"docType": "order"}}
+	if filter.OrderId != "" {
+		clauses = append(clauses, map[string]interface{}{"orderId": filter.OrderId})
+	}
+	if filter.Party != "" {
+		fields, err := partyFields(filter.PartyRole)
+		if err != nil {
+			return nil, err
+		}
+		clauses = append(clauses, anyOf(fields, filter.Party))
+	} else if filter.PartyRole != "" {
+		return nil, fmt.Errorf("Filter partyRole needs a party")
+	}
+	if len(filter.States) > 0 {
+		states := make([]interface{}, 0, len(filter.States))
+		for _, state := range filter.States {
+			state = strings.ToUpper(state)
+			if _, ok := orderStage[state]; !ok && state != "CANCELLED" {
+				return nil, fmt.Errorf("Unknown order state %s", state)
+			}
+			states = append(states, state)
+		}
+		clauses = append(clauses, map[string]interface{}{"orderState": map[string]interface{}{"$in": states}})
+	}
+	windows := []struct{ field, from, to string }{
+		{"createdAt", filter.CreatedFrom, filter.CreatedTo},
+		{"updatedAt", filter.UpdatedFrom, filter.UpdatedTo},
+	}
+	for _, window := range windows {
+		condition, err := timeWindow(window.from, window.to)
+		if err != nil {
+			return nil, err
+		}
+		if condition != nil {
+			clauses = append(clauses, map[string]interface{}{window.field: condition})
+		}
+	}
+	if filter.Content != "" {
+		clauses = append(clauses, map[string]interface{}{"content": filter.Content})
+	}
+	if filter.OriginRegion != "" {
+		clauses = append(clauses, map[string]interface{}{"originRegion": filter.OriginRegion})
+	}
+	if filter.DestinationRegion != "" {
+		clauses = append(clauses, map[string]interface{}{"destinationRegion": filter.DestinationRegion})
+	}
+	ranges := []struct {
+		field    string
+		min, max *float64
+	}{
+		{"weightTon", filter.MinWeightTon, filter.MaxWeightTon},
+		{"transFee", filter.MinTransFee, filter.MaxTransFee},
+	}
+	for _, bounds := range ranges {
+		condition := map[string]interface{}{}
+		if bounds.min != nil {
+			condition["$gte"] = *bounds.min
+		}
+		if bounds.max != nil {
+			condition["$lte"] = *bounds.max
+		}
+		if len(condition) > 0 {
+			clauses = append(clauses, map[string]interface{}{bounds.field: condition})
+		}
+	}
+	// a sorted query must select on the sort field for CouchDB to use its index
+	if filter.SortBy != "" && !selectsField(clauses, filter.SortBy) {
+		clauses = append(clauses, map[string]interface{}{filter.SortBy: map[string]interface{}{"$gt": nil}})
+	}
+	if !isAdmin(caller) && caller.Role != "arbitrator" {
+		fields, _ := partyFields("")
+		clauses = append(clauses, anyOf(fields, caller.UserId))
+	}
+	return map[string]interface{}{"$and": clauses}, nil
+}
+
+// timeWindow translates an RFC3339 from (inclusive) and to (exclusive) into a condition on
+// the UTC timestamps orders keep, nil when both are empty
+func timeWindow(from string, to string) (map[string]interface{}, error) {
+	window := map[string]interface{}{}
+	for operator, arg := range map[string]string{"$gte": from, "$lt": to} {
+		if arg == "" {
+			continue
+		}
+		at, err := time.Parse(time.RFC3339, arg)
+		if err != nil {
+			return nil, fmt.Errorf("Dates must be RFC3339, e.g. 2019-04-01T08:00:00+08:00: %s", arg)
+		}
+		window[operator] = at.UTC().Format(time.RFC3339)
+	}
+	if len(window) == 0 {
+		return nil, nil
+	}
+	return window, nil
+}
+
+func selectsField(clauses []interface{}, field string) bool {
+	for _, clause := range clauses {
+		if _, ok := clause.(map[string]interface{})[field]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// orderSort translates the sort of a filter, led by docType so that it matches the
+// docType-prefixed indexes
+func orderSort(filter *RecordFilter) ([]interface{}, error) {
+	if filter.SortBy == "" {
+		if filter.SortOrder != "" {
+			return nil, fmt.Errorf("Filter sortOrder needs a sortBy")
+		}
+		return nil, nil
+	}
+	switch filter.SortBy {
+	case "createdAt", "updatedAt", "weightTon", "transFee":
+	default:
+		return nil, fmt.Errorf("sortBy must be createdAt, updatedAt, weightTon or transFee, got %s", filter.SortBy)
+	}
+	direction := filter.SortOrder
+	if direction == "" {
+		direction = "asc"
+	} else if direction != "asc" && direction != "desc" {
+		return nil, fmt.Errorf("sortOrder must be asc or desc, got %s", direction)
+	}
+	return []interface{}{
+		map[string]interface{}{"docType": direction},
+		map[string]interface{}{filter.SortBy: direction},
+	}, nil
+}
+
+// recordSelector translates a filter into a selector limited to what the caller may read
+func recordSelector(stub shim.ChaincodeStubInterface, caller *User, filter *RecordFilter) (map[string]interface{}, error) {
+	if filter.DocType == "order" {
+		return orderSelector(caller, filter)
+	}
+	if filter.Party != "" || filter.PartyRole != "" || len(filter.States) > 0 ||
+		filter.CreatedFrom != "" || filter.CreatedTo != "" || filter.UpdatedFrom != "" || filter.UpdatedTo != "" ||
+		filter.Content != "" || filter.OriginRegion != "" || filter.DestinationRegion != "" ||
+		filter.MinWeightTon != nil || filter.MaxWeightTon != nil || filter.MinTransFee != nil || filter.MaxTransFee != nil || filter.SortBy != "" || filter.SortOrder != "" {
+		return nil, fmt.Errorf("Filters party, partyRole, states, time windows, content, regions, ranges and sorting only apply to orders")
+	}
+	selector := map[string]interface{}{"docType": filter.DocType}
+	switch filter.DocType {
+	case "position", "stringHash", "fileHashForOrder":
+		if filter.OrderId == "" {
+			return nil, fmt.Errorf("Filter orderId is required for %s", filter.DocType)
+		}
+		order, err := getOrder(stub, filter.OrderId)
+		if err != nil {
+			return nil, err
+		}
+		if !canReadOrder(caller, order) {
+			return nil, fmt.Errorf("User %s cannot read order %s", caller.UserId, filter.OrderId)
+		}
+		selector["orderId"] = filter.OrderId
+	case "fileHashForUser":
+		// files of a user are kept under the user's id in orderId
+		if filter.OrderId != "" {
+			selector["orderId"] = filter.OrderId
+		}
+		if !isAdmin(caller) {
+			if filter.OrderId != "" && filter.OrderId != caller.UserId {
+				return nil, fmt.Errorf("User %s cannot read the files of %s", caller.UserId, filter.OrderId)
+			}
+			selector["orderId"] = caller.UserId
+		}
+	case "user":
+		if filter.OrderId != "" {
+			return nil, fmt.Errorf("Filter orderId does not apply to users")
+		}
+		if !isAdmin(caller) {
+			selector["userId"] = caller.UserId
+		}
+	case "ledgerEntry":
+		if filter.OrderId != "" {
+			selector["orderId"] = filter.OrderId
+		}
+		if !isAdmin(caller) {
+			selector["$or"] = []interface{}{
+				map[string]interface{}{"fromAccount": caller.UserId},
+				map[string]interface{}{"toAccount": caller.UserId},
+			}
+		}
+	default:
+		return nil, fmt.Errorf("Unsupported docType %s", filter.DocType)
+	}
+	return selector, nil
+}
+
+// recordQueryString builds the query of a filter on behalf of the caller
+func recordQueryString(stub shim.ChaincodeStubInterface, caller *User, filter *RecordFilter) (string, error) {
+	selector, err := recordSelector(stub, caller, filter)
+	if err != nil {
+		return "", err
+	}
+	sort, err := orderSort(filter)
+	if err != nil {
+		return "", err
+	}
+	return buildSortedQueryString(selector, sort)
+}
+
+// runRecordFilter runs a filter on behalf of the caller and returns a PaginatedResponse
+func runRecordFilter(stub shim.ChaincodeStubInterface, filter *RecordFilter, pageSize int32, bookmark string) ([]byte, error) {
+	caller, err := getCaller(stub)
+	if err != nil {
+		return nil, err
+	}
+	queryString, err := recordQueryString(stub, caller, filter)
+	if err != nil {
+		return nil, err
+	}
+	return getQueryResultForQueryStringWithPagination(stub, queryString, pageSize, bookmark)
+}
+
+// ============================================================
+// queryRecords - query records through the whitelisted named filters
+// Only available on state databases that support rich query (e.g. CouchDB)
+// ============================================================
+func (t *SimpleChaincode) queryRecords(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0       					1		2
+	// "{\"docType\":\"order\"}", ["10", "bookmark"]
+	pageSize, bookmark, err := parsePaginationArgs(args, 1)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	filter, err := parseRecordFilter(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	queryResults, err := runRecordFilter(stub, filter, pageSize, bookmark)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(queryResults)
+}
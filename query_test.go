@@ -0,0 +1,146 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func queryOrderKeys(t *testing.T, s *testStub, function string, args ...string) []string {
+	t.Helper()
+	var orders []OrderWithKey
+	unmarshalPage(t, mustInvoke(t, s, function, args...), &orders)
+	keys := make([]string, 0, len(orders))
+	for _, order := range orders {
+		keys = append(keys, order.Key)
+	}
+	return keys
+}
+
+func TestQueryRecordsFilters(t *testing.T) {
+	s := newFixture(t)
+	createOrder(t, s, "order1")
+	s.now = s.now.Add(24 * 60 * 60 * 1e9)
+	createOrder(t, s, "order2")
+	mustInvoke(t, s, "initOrder", "order3", "上海", "北京", "钢材", "20", "4000", "WAIT_DRIVER_ACCEPT", "owner", "broker2", "driver")
+	advanceOrder(t, s, "order2", "DRIVER_ACCEPT_WAIT_ROAD", "DRIVER_ON_ROAD")
+
+	cases := map[string]string{
+		`{"docType":"order"}`: "order1,order2,order3",
+		`{"docType":"order","party":"broker","partyRole":"broker"}`:                           "order1,order2",
+		`{"docType":"order","party":"broker2"}`:                                               "order3",
+		`{"docType":"order","states":["driver_on_road","SIGNED"]}`:                            "order2",
+		`{"docType":"order","createdFrom":"2019-04-02T08:00:00+08:00"}`:                       "order2,order3",
+		`{"docType":"order","createdTo":"2019-04-02T00:00:00Z"}`:                              "order1",
+		`{"docType":"order","content":"钢材"}`:                                                  "order3",
+		`{"docType":"order","orderId":"order2","party":"owner","partyRole":"goodsOwner"}`:     "order2",
+		`{"docType":"order","party":"broker\"},\"docType\":{\"$gt\":null},\"x\":{\"y\":\"z"}`: "",
+	}
+	for filter, want := range cases {
+		keys := queryOrderKeys(t, s, "queryRecords", filter)
+		if got := strings.Join(keys, ","); got != want {
+			t.Errorf("%s: expected %s, got %s", filter, want, got)
+		}
+	}
+
+	var positions []PositionWithKey
+	mustInvoke(t, s, "updatePositionOrder", "position1", "order1", "1", "Mon, 01 Apr 2019 08:00:00 UTC", "上海")
+	unmarshalPage(t, mustInvoke(t, s, "queryRecords", `{"docType":"position","orderId":"order1"}`), &positions)
+	if len(positions) != 1 || positions[0].Record.PositionId != "position1" {
+		t.Errorf("unexpected positions %+v", positions)
+	}
+}
+
+func TestQueryRecordsRejectsUnknownFilters(t *testing.T) {
+	s := newFixture(t)
+	createOrder(t, s, "order1")
+	expectError(t, s, "unknown field", "queryRecords", `{"docType":"order","selector":{}}`)
+	expectError(t, s, "docType is required", "queryRecords", `{}`)
+	expectError(t, s, "Unsupported docType account", "queryRecords", `{"docType":"account"}`)
+	expectError(t, s, "partyRole must be", "queryRecords", `{"docType":"order","party":"x","partyRole":"owner"}`)
+	expectError(t, s, "partyRole needs a party", "queryRecords", `{"docType":"order","partyRole":"broker"}`)
+	expectError(t, s, "Unknown order state LOST", "queryRecords", `{"docType":"order","states":["LOST"]}`)
+	expectError(t, s, "RFC3339", "queryRecords", `{"docType":"order","createdFrom":"Mon, 01 Apr 2019"}`)
+	expectError(t, s, "only apply to orders", "queryRecords", `{"docType":"user","content":"煤炭"}`)
+	expectError(t, s, "orderId is required", "queryRecords", `{"docType":"position"}`)
+	expectError(t, s, "Incorrect number of arguments", "queryRecords")
+}
+
+func TestQueryRecordsAccessRights(t *testing.T) {
+	s := newFixture(t)
+	mustInvoke(t, s, "initUser", "broker2", "承运人2", "broker", "13800000005", "true")
+	mustInvoke(t, s, "initUser", "suspended", "停用", "broker", "13800000006", "false")
+	createOrder(t, s, "order1")
+	mustInvoke(t, s, "initOrder", "order2", "上海", "北京", "煤炭", "20", "4000", "WAIT_DRIVER_ACCEPT", "owner", "broker2", "driver")
+
+	s.caller = "broker2"
+	if keys := strings.Join(queryOrderKeys(t, s, "queryRecords", `{"docType":"order"}`), ","); keys != "order2" {
+		t.Errorf("broker2 should only see its own order, got %s", keys)
+	}
+	if keys := strings.Join(queryOrderKeys(t, s, "queryOrdersByBroker", "broker"), ","); keys != "" {
+		t.Errorf("broker2 should not list the orders of broker, got %s", keys)
+	}
+	if keys := strings.Join(queryOrderKeys(t, s, "queryOrdersByGoodsOwner", "owner"), ","); keys != "order2" {
+		t.Errorf("broker2 should only see its own order of owner, got %s", keys)
+	}
+	expectError(t, s, "cannot read order order1", "queryRecords", `{"docType":"position","orderId":"order1"}`)
+	expectError(t, s, "cannot read the files of owner", "queryRecords", `{"docType":"fileHashForUser","orderId":"owner"}`)
+	expectError(t, s, "Only an admin can call queryAssets", "queryAssets", `{"selector":{"docType":"user"}}`)
+	expectError(t, s, "Only an admin can call queryOrdersWithPagination", "queryOrdersWithPagination", `{"selector":{"docType":"user"}}`, "10", "")
+
+	var users []struct {
+		Record User
+	}
+	unmarshalPage(t, mustInvoke(t, s, "queryRecords", `{"docType":"user"}`), &users)
+	if len(users) != 1 || users[0].Record.UserId != "broker2" {
+		t.Errorf("a user should only read their own record, got %+v", users)
+	}
+
+	s.caller = "driver"
+	if keys := strings.Join(queryOrderKeys(t, s, "queryOrdersByState", "WAIT_DRIVER_ACCEPT"), ","); keys != "order1,order2" {
+		t.Errorf("driver should see both of its orders, got %s", keys)
+	}
+	s.caller = "arbitrator"
+	if keys := strings.Join(queryOrderKeys(t, s, "queryRecords", `{"docType":"order"}`), ","); keys != "order1,order2" {
+		t.Errorf("arbitrator should see every order, got %s", keys)
+	}
+	s.caller = "admin"
+	unmarshalPage(t, mustInvoke(t, s, "queryRecords", `{"docType":"user"}`), &users)
+	if len(users) != 7 {
+		t.Errorf("admin should read every user, got %d", len(users))
+	}
+
+	s.caller = "suspended"
+	expectError(t, s, "User suspended is not valid", "queryRecords", `{"docType":"order"}`)
+	s.caller = "nobody"
+	expectError(t, s, "User does not exist: nobody", "queryRecords", `{"docType":"order"}`)
+	s.caller = ""
+	expectError(t, s, "no userId attribute", "queryRecords", `{"docType":"order"}`)
+}
+
+func TestOrderReadsCheckTheCaller(t *testing.T) {
+	s := newFixture(t)
+	mustInvoke(t, s, "initUser", "broker2", "承运人2", "broker", "13800000005", "true")
+	createOrder(t, s, "order1")
+	mustInvoke(t, s, "delete", "order1")
+	createOrder(t, s, "order2")
+
+	s.caller = "broker2"
+	expectError(t, s, "User broker2 cannot read order order2", "readOrder", "order2")
+	expectError(t, s, "User broker2 cannot read order order2", "queryOrderDetail", "order2")
+	expectError(t, s, "User broker2 cannot read order order2", "getHistoryForOrder", "order2")
+	expectError(t, s, "User broker2 cannot read order order1", "getHistoryForOrder", "order1")
+	expectError(t, s, "User broker2 cannot read order missing", "getHistoryForOrder", "missing")
+	expectError(t, s, "User broker2 cannot read order order2", "getOrderAsOf", "order2", "2019-04-01T08:30:00Z")
+	expectError(t, s, "User broker2 cannot read user broker", "readUser", "broker")
+	expectError(t, s, "Only an admin can call getOrdersByRange", "getOrdersByRange", "order1", "order3")
+	expectError(t, s, "Only an admin can call getOrdersByRangeWithPagination", "getOrdersByRangeWithPagination", "order1", "order3", "1", "")
+	mustInvoke(t, s, "readUser", "broker2")
+
+	s.caller = "broker"
+	if order := readOrder(t, s, "order2"); order.BrokerId != "broker" {
+		t.Errorf("the broker should read its order, got %+v", order)
+	}
+	mustInvoke(t, s, "getHistoryForOrder", "order1")
+	s.caller = "arbitrator"
+	mustInvoke(t, s, "queryOrderDetail", "order2")
+}
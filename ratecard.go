@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ====RATE CARDS (CLI) ====================================================================
+// 发布运价 peer chaincode invoke -C myc1 -n orders -c '{"Args":["putRateCard","brokerId0","{\"validFrom\":\"2019-04-01T00:00:00+08:00\",\"rates\":[{\"originRegion\":\"上海\",\"destinationRegion\":\"北京\",\"category\":\"煤炭\",\"minWeightTon\":0,\"maxWeightTon\":30,\"pricePerTon\":200,\"minimumFee\":1000}]}"]}'
+// 查询运价 peer chaincode query -C myc1 -n orders -c '{"Args":["readRateCard","brokerId0"]}'
+// 查询运价版本 peer chaincode query -C myc1 -n orders -c '{"Args":["readRateCard","brokerId0","2"]}'
+// 运价历史 peer chaincode query -C myc1 -n orders -c '{"Args":["queryRateCards","brokerId0","10",""]}'
+// 询价 peer chaincode query -C myc1 -n orders -c '{"Args":["quoteFee","brokerId0","上海","北京","煤炭","20"]}'
+// 按运价下单 peer chaincode invoke -C myc1 -n orders -c '{"Args":["initOrder","orderId0","上海","北京","煤炭","20","auto","WAIT_DRIVER_ACCEPT","goodsOwnerId","brokerId0","driverId"]}'
+// 改价下单 peer chaincode invoke -C myc1 -n orders -c '{"Args":["initOrder","orderId0","上海","北京","煤炭","20","3500","WAIT_DRIVER_ACCEPT","goodsOwnerId","brokerId0","driverId","{\"feeOverrideReason\":\"老客户优惠\"}"]}'
+//
+// A broker publishes their prices as a rate card (rateCard: brokerId, version). Each
+// putRateCard adds a version, earlier versions stay as they were. A rate prices a lane,
+// given as the regions fromAddress and toAddress, or the region codes of the locations of
+// an order, start with, a goods category matching the content or the goods code of an
+// order, any when left out, and a weight bracket. The fee is the price per ton times the
+// weight, at least the minimum fee, rounded to cents. The active version is the latest one
+// valid at the transaction time; of its rates the one with the most specific lane and
+// category applies, the first listed when several are as specific.
+//
+// initOrder calculates the fee from the broker's active rate card when transFee is "auto".
+// A fee given by hand that differs from the rate card fee needs a feeOverrideReason. Either
+// way the order records the version and rate it was priced with. Orders of brokers without
+// a rate card, or whose lane no rate covers, take the fee as given.
+// =========================================================================================
+
+const (
+	rateCardIndex = "rateCard"
+	autoFee       = "auto"
+)
+
+func rateCardKey(stub shim.ChaincodeStubInterface, brokerId string, version int) (string, error) {
+	// zero padded so that the versions of a broker range in order
+	return stub.CreateCompositeKey(rateCardIndex, []string{brokerId, fmt.Sprintf("%06d", version)})
+}
+
+func getRateCard(stub shim.ChaincodeStubInterface, brokerId string, version int) (*RateCard, error) {
+	key, err := rateCardKey(stub, brokerId, version)
+	if err != nil {
+		return nil, err
+	}
+	cardAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get rate card: %s", err.Error())
+	} else if cardAsBytes == nil {
+		return nil, fmt.Errorf("Rate card %d of %s does not exist", version, brokerId)
+	}
+	card := &RateCard{}
+	err = json.Unmarshal(cardAsBytes, card)
+	if err != nil {
+		return nil, err
+	}
+	return card, nil
+}
+
+// rateCardRecords lists the versions of a broker's rate card, oldest first
+func rateCardRecords(stub shim.ChaincodeStubInterface, brokerId string) ([]QueryRecord, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(rateCardIndex, []string{brokerId})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+	var records []QueryRecord
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, QueryRecord{queryResponse.Key, queryResponse.Value})
+	}
+	return records, nil
+}
+
+// activeRateCard returns the latest version of a broker's rate card valid at a time, nil
+// when there is none
+func activeRateCard(stub shim.ChaincodeStubInterface, brokerId string, at time.Time) (*RateCard, error) {
+	records, err := rateCardRecords(stub, brokerId)
+	if err != nil {
+		return nil, err
+	}
+	now := at.UTC().Format(time.RFC3339)
+	for i := len(records) - 1; i >= 0; i-- {
+		card := &RateCard{}
+		err = json.Unmarshal(records[i].Record, card)
+		if err != nil {
+			return nil, err
+		}
+		if card.ValidFrom <= now && (card.ValidTo == "" || now < card.ValidTo) {
+			return card, nil
+		}
+	}
+	return nil, nil
+}
+
+// inRegion tells whether an end of an order lies in the region of a rate, by its address
+// or by the region code of its location
+func inRegion(address string, regionCode string, region string) bool {
+	return strings.HasPrefix(address, region) || regionCode != "" && strings.HasPrefix(regionCode, region)
+}
+
+// matchRate picks the rate of a card that prices an order, nil when none does. The
+// category of a rate matches the content or the goods code.
+func matchRate(card *RateCard, order *Order) *Rate {
+	var match *Rate
+	best := -1
+	for i := range card.Rates {
+		rate := &card.Rates[i]
+		if !inRegion(order.FromAddress, order.OriginRegion, rate.OriginRegion) ||
+			!inRegion(order.ToAddress, order.DestinationRegion, rate.DestinationRegion) {
+			continue
+		} else if rate.Category != "" && rate.Category != order.Content && (order.GoodsCode == "" || rate.Category != order.GoodsCode) {
+			continue
+		} else if order.WeightTon < rate.MinWeightTon || rate.MaxWeightTon > 0 && order.WeightTon >= rate.MaxWeightTon {
+			continue
+		}
+		specificity := len(rate.OriginRegion) + len(rate.DestinationRegion)
+		if rate.Category != "" {
+			specificity += len(rate.Category)
+		}
+		if specificity > best {
+			match, best = rate, specificity
+		}
+	}
+	return match
+}
+
+// rateFee is the fee of a rate for a weight, rounded to cents
+func rateFee(rate *Rate, weightTon float64) float64 {
+	fee := math.Max(rate.PricePerTon*weightTon, rate.MinimumFee)
+	return math.Round(fee*100) / 100
+}
+
+// quote calculates the fee of an order from the broker's active rate card, nil when no
+// rate applies
+func quote(stub shim.ChaincodeStubInterface, order *Order) (*FeeCalculation, error) {
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	card, err := activeRateCard(stub, order.BrokerId, txTime)
+	if err != nil || card == nil {
+		return nil, err
+	}
+	rate := matchRate(card, order)
+	if rate == nil {
+		return nil, nil
+	}
+	return &FeeCalculation{BrokerId: order.BrokerId, RateVersion: card.Version, Rate: *rate,
+		CalculatedFee: rateFee(rate, order.WeightTon)}, nil
+}
+
+// priceOrder sets the fee of a new order from the broker's rate card when asked to, and
+// records the rate card fee of an order priced by hand, refusing a different fee without a
+// reason
+func priceOrder(stub shim.ChaincodeStubInterface, order *Order, auto bool, overrideReason string) error {
+	calculation, err := quote(stub, order)
+	if err != nil {
+		return err
+	}
+	if calculation == nil {
+		if auto {
+			return fmt.Errorf("No rate card of %s prices %s to %s, %s, %v tons", order.BrokerId,
+				order.FromAddress, order.ToAddress, order.Content, order.WeightTon)
+		} else if overrideReason != "" {
+			return fmt.Errorf("No rate card of %s applies, there is no fee to override", order.BrokerId)
+		}
+		return nil
+	}
+	if auto {
+		if overrideReason != "" {
+			return fmt.Errorf("A calculated fee is not overridden, give the fee to override it with")
+		}
+		order.TransFee = calculation.CalculatedFee
+	} else if order.TransFee != calculation.CalculatedFee {
+		if overrideReason == "" {
+			return fmt.Errorf("The fee %v differs from %v of rate card %d of %s, a feeOverrideReason is required",
+				order.TransFee, calculation.CalculatedFee, calculation.RateVersion, order.BrokerId)
+		}
+		calculation.OverrideReason = overrideReason
+	}
+	order.FeeCalculation = calculation
+	return nil
+}
+
+// decodeRateCard reads and checks the rate card JSON of putRateCard
+func decodeRateCard(arg string) (*RateCard, error) {
+	card := &RateCard{}
+	decoder := json.NewDecoder(bytes.NewReader([]byte(arg)))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(card)
+	if err != nil {
+		return nil, fmt.Errorf("2nd argument must be a JSON rate card: %s", err.Error())
+	}
+	validFrom, err := time.Parse(time.RFC3339, card.ValidFrom)
+	if err != nil {
+		return nil, fmt.Errorf("validFrom must be an RFC3339 time")
+	}
+	card.ValidFrom = validFrom.UTC().Format(time.RFC3339)
+	if card.ValidTo != "" {
+		validTo, err := time.Parse(time.RFC3339, card.ValidTo)
+		if err != nil {
+			return nil, fmt.Errorf("validTo must be an RFC3339 time")
+		} else if !validTo.After(validFrom) {
+			return nil, fmt.Errorf("validTo must be after validFrom")
+		}
+		card.ValidTo = validTo.UTC().Format(time.RFC3339)
+	}
+	if len(card.Rates) == 0 {
+		return nil, fmt.Errorf("A rate card needs rates")
+	}
+	for i, rate := range card.Rates {
+		switch {
+		case rate.OriginRegion == "" || rate.DestinationRegion == "":
+			return nil, fmt.Errorf("Rate %d needs an originRegion and a destinationRegion", i+1)
+		case rate.MinWeightTon < 0 || rate.MaxWeightTon < 0 || rate.MaxWeightTon > 0 && rate.MaxWeightTon <= rate.MinWeightTon:
+			return nil, fmt.Errorf("Rate %d has an empty weight bracket", i+1)
+		case rate.PricePerTon <= 0 || rate.MinimumFee < 0:
+			return nil, fmt.Errorf("Rate %d needs a positive pricePerTon and a non-negative minimumFee", i+1)
+		}
+	}
+	return card, nil
+}
+
+// ============================================================
+// putRateCard - publish a new version of a broker's rate card
+// ============================================================
+func (t *SimpleChaincode) putRateCard(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0       		1
+	// "brokerId0", "{\"validFrom\":\"...\",\"validTo\":\"...\",\"rates\":[...]}"
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	brokerId, err := actingUserId(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	broker, err := getUser(stub, brokerId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if broker.Role != "broker" {
+		return shim.Error("User " + brokerId + " is " + broker.Role + " and cannot have a rate card")
+	}
+	card, err := decodeRateCard(args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	records, err := rateCardRecords(stub, brokerId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	caller, err := getCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	card.ObjectType = "rateCard"
+	card.BrokerId = brokerId
+	card.Version = len(records) + 1
+	card.CreatedBy = caller.UserId
+	card.CreatedAt = txTime.Format(time.RFC3339)
+	key, err := rateCardKey(stub, brokerId, card.Version)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	cardAsBytes, err := json.Marshal(card)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(key, cardAsBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(cardAsBytes)
+}
+
+// ============================================================
+// readRateCard - read a version of a broker's rate card, the active one by default
+// ============================================================
+func (t *SimpleChaincode) readRateCard(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0       		1
+	// "brokerId0", ["2"]
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 1 or 2")
+	}
+	_, err := getCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	var card *RateCard
+	if len(args) == 2 {
+		version, err := strconv.Atoi(args[1])
+		if err != nil || version <= 0 {
+			return shim.Error("2nd argument must be a positive version")
+		}
+		card, err = getRateCard(stub, args[0], version)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	} else {
+		txTime, err := getTxTime(stub)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		card, err = activeRateCard(stub, args[0], txTime)
+		if err != nil {
+			return shim.Error(err.Error())
+		} else if card == nil {
+			return shim.Error("Broker " + args[0] + " has no active rate card")
+		}
+	}
+	cardAsBytes, err := json.Marshal(card)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(cardAsBytes)
+}
+
+// ============================================================
+// queryRateCards - list the versions of a broker's rate card, oldest first
+// ============================================================
+func (t *SimpleChaincode) queryRateCards(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0       		1		2
+	// "brokerId0", ["10", "bookmark"]
+	pageSize, bookmark, err := parsePaginationArgs(args, 1)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	_, err = getCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	records, err := rateCardRecords(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	page, err := pageOfRecords(records, pageSize, bookmark)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	pageAsBytes, err := json.Marshal(page)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(pageAsBytes)
+}
+
+// ============================================================
+// quoteFee - calculate the fee of an order from a broker's active rate card
+// ============================================================
+func (t *SimpleChaincode) quoteFee(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0       		1		2		3		4
+	// "brokerId0", "上海", "北京", "煤炭", "20"
+	// the goods are matched as content and as goods code
+	if len(args) != 5 {
+		return shim.Error("Incorrect number of arguments. Expecting 5")
+	}
+	weightTon, err := strconv.ParseFloat(args[4], 64)
+	if err != nil || weightTon <= 0 {
+		return shim.Error("5th argument must be a positive number")
+	}
+	_, err = getCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	order := &Order{BrokerId: args[0], FromAddress: args[1], ToAddress: args[2], Content: args[3], GoodsCode: args[3],
+		WeightTon: weightTon}
+	calculation, err := quote(stub, order)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if calculation == nil {
+		return shim.Error("No rate card of " + args[0] + " prices the order")
+	}
+	calculationAsBytes, err := json.Marshal(calculation)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(calculationAsBytes)
+}
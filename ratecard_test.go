@@ -0,0 +1,135 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+const testRateCard = `{"validFrom":"2019-04-01T00:00:00+08:00","rates":[
+	{"originRegion":"上海","destinationRegion":"北京","category":"煤炭","minWeightTon":0,"maxWeightTon":30,"pricePerTon":200,"minimumFee":1000},
+	{"originRegion":"上海","destinationRegion":"北京","minWeightTon":0,"pricePerTon":250},
+	{"originRegion":"上海市浦东","destinationRegion":"北京","category":"煤炭","minWeightTon":0,"maxWeightTon":30,"pricePerTon":180}]}`
+
+func initAutoOrder(t *testing.T, s *testStub, orderId string, fromAddress string, content string, weightTon string) Order {
+	t.Helper()
+	mustInvoke(t, s, "initOrder", orderId, fromAddress, "北京市朝阳区", content, weightTon, "auto", "WAIT_DRIVER_ACCEPT", "owner", "broker", "driver")
+	return readOrder(t, s, orderId)
+}
+
+func TestRateCardPricesOrders(t *testing.T) {
+	s := newFixture(t)
+	s.caller = "broker"
+	var card RateCard
+	unmarshalPayload(t, mustInvoke(t, s, "putRateCard", "", testRateCard), &card)
+	if card.Version != 1 || card.BrokerId != "broker" || card.ValidFrom != "2019-03-31T16:00:00Z" || len(card.Rates) != 3 {
+		t.Fatalf("unexpected rate card %+v", card)
+	}
+	s.caller = "admin"
+
+	for _, c := range []struct {
+		orderId, fromAddress, content, weightTon string
+		fee, pricePerTon                         float64
+	}{
+		{"order1", "上海", "煤炭", "20", 4000, 200},
+		{"order2", "上海", "钢材", "20", 5000, 250},
+		{"order3", "上海市浦东新区", "煤炭", "20", 3600, 180},
+		{"order4", "上海", "煤炭", "2", 1000, 200},
+		{"order5", "上海", "煤炭", "40", 10000, 250},
+	} {
+		order := initAutoOrder(t, s, c.orderId, c.fromAddress, c.content, c.weightTon)
+		calculation := order.FeeCalculation
+		if order.TransFee != c.fee || calculation == nil || calculation.CalculatedFee != c.fee || calculation.RateVersion != 1 ||
+			calculation.Rate.PricePerTon != c.pricePerTon || calculation.OverrideReason != "" {
+			t.Errorf("unexpected fee of %s: %v, %+v", c.orderId, order.TransFee, calculation)
+		}
+	}
+	var account Account
+	unmarshalPayload(t, mustInvoke(t, s, "readAccount", "owner"), &account)
+	if account.Balance != 100000-4000-5000-3600-1000-10000 {
+		t.Errorf("the calculated fees should be locked, balance %v", account.Balance)
+	}
+
+	expectError(t, s, "The fee 3500 differs from 4000 of rate card 1 of broker, a feeOverrideReason is required",
+		"initOrder", "order6", "上海", "北京", "煤炭", "20", "3500", "WAIT_DRIVER_ACCEPT", "owner", "broker", "driver")
+	mustInvoke(t, s, "initOrder", "order6", "上海", "北京", "煤炭", "20", "3500", "WAIT_DRIVER_ACCEPT", "owner", "broker", "driver",
+		`{"feeOverrideReason":"老客户优惠"}`)
+	if order := readOrder(t, s, "order6"); order.TransFee != 3500 || order.FeeCalculation == nil ||
+		order.FeeCalculation.CalculatedFee != 4000 || order.FeeCalculation.OverrideReason != "老客户优惠" {
+		t.Errorf("unexpected overridden order %+v, %+v", order, order.FeeCalculation)
+	}
+	mustInvoke(t, s, "initOrder", "order7", "上海", "北京", "煤炭", "20", "4000", "WAIT_DRIVER_ACCEPT", "owner", "broker", "driver")
+	if order := readOrder(t, s, "order7"); order.FeeCalculation == nil || order.FeeCalculation.OverrideReason != "" {
+		t.Errorf("a fee matching the rate card should be recorded as calculated, got %+v", order.FeeCalculation)
+	}
+
+	expectError(t, s, "No rate card of broker prices 广州 to 北京", "initOrder",
+		"order8", "广州", "北京", "煤炭", "20", "auto", "WAIT_DRIVER_ACCEPT", "owner", "broker", "driver")
+	expectError(t, s, "there is no fee to override", "initOrder",
+		"order8", "广州", "北京", "煤炭", "20", "3000", "WAIT_DRIVER_ACCEPT", "owner", "broker", "driver", `{"feeOverrideReason":"x"}`)
+	expectError(t, s, "A calculated fee is not overridden", "initOrder",
+		"order8", "上海", "北京", "煤炭", "20", "auto", "WAIT_DRIVER_ACCEPT", "owner", "broker", "driver", `{"feeOverrideReason":"x"}`)
+	mustInvoke(t, s, "initOrder", "order8", "广州", "北京", "煤炭", "20", "3000", "WAIT_DRIVER_ACCEPT", "owner", "broker", "driver")
+	if order := readOrder(t, s, "order8"); order.TransFee != 3000 || order.FeeCalculation != nil {
+		t.Errorf("an order no rate covers should keep its fee, got %+v", order)
+	}
+}
+
+func TestRateCardVersions(t *testing.T) {
+	s := newFixture(t)
+	s.caller = "broker"
+	mustInvoke(t, s, "putRateCard", "broker", `{"validFrom":"2019-04-01T00:00:00Z","validTo":"2019-04-02T00:00:00Z",
+		"rates":[{"originRegion":"上海","destinationRegion":"北京","pricePerTon":200}]}`)
+	mustInvoke(t, s, "putRateCard", "broker", `{"validFrom":"2019-04-05T00:00:00Z",
+		"rates":[{"originRegion":"上海","destinationRegion":"北京","pricePerTon":220}]}`)
+
+	var card RateCard
+	unmarshalPayload(t, mustInvoke(t, s, "readRateCard", "broker"), &card)
+	if card.Version != 1 {
+		t.Errorf("version 1 should be active, got %+v", card)
+	}
+	unmarshalPayload(t, mustInvoke(t, s, "readRateCard", "broker", "2"), &card)
+	if card.Version != 2 || card.Rates[0].PricePerTon != 220 {
+		t.Errorf("unexpected version 2 %+v", card)
+	}
+	var cards []struct {
+		Key    string
+		Record RateCard
+	}
+	unmarshalPage(t, mustInvoke(t, s, "queryRateCards", "broker"), &cards)
+	if len(cards) != 2 || cards[0].Record.Version != 1 || cards[1].Record.Version != 2 {
+		t.Errorf("unexpected versions %+v", cards)
+	}
+
+	s.now = time.Date(2019, 4, 3, 8, 0, 0, 0, time.UTC)
+	expectError(t, s, "Broker broker has no active rate card", "readRateCard", "broker")
+	expectError(t, s, "No rate card of broker prices the order", "quoteFee", "broker", "上海", "北京", "煤炭", "20")
+	s.now = time.Date(2019, 4, 6, 8, 0, 0, 0, time.UTC)
+	var calculation FeeCalculation
+	unmarshalPayload(t, mustInvoke(t, s, "quoteFee", "broker", "上海", "北京", "煤炭", "20"), &calculation)
+	if calculation.RateVersion != 2 || calculation.CalculatedFee != 4400 {
+		t.Errorf("unexpected quote %+v", calculation)
+	}
+	expectError(t, s, "Rate card 3 of broker does not exist", "readRateCard", "broker", "3")
+}
+
+func TestPutRateCardErrors(t *testing.T) {
+	s := newFixture(t)
+	rates := `"rates":[{"originRegion":"上海","destinationRegion":"北京","pricePerTon":200}]`
+	s.caller = "driver"
+	expectError(t, s, "User driver is driver and cannot have a rate card", "putRateCard", "", `{"validFrom":"2019-04-01T00:00:00Z",`+rates+`}`)
+	s.caller = "broker"
+	expectError(t, s, "User broker cannot act as broker2", "putRateCard", "broker2", `{"validFrom":"2019-04-01T00:00:00Z",`+rates+`}`)
+	expectError(t, s, "unknown field \"currency\"", "putRateCard", "", `{"validFrom":"2019-04-01T00:00:00Z","currency":"CNY",`+rates+`}`)
+	expectError(t, s, "validFrom must be an RFC3339 time", "putRateCard", "", `{"validFrom":"2019-04-01",`+rates+`}`)
+	expectError(t, s, "validTo must be after validFrom", "putRateCard", "",
+		`{"validFrom":"2019-04-01T00:00:00Z","validTo":"2019-04-01T00:00:00Z",`+rates+`}`)
+	expectError(t, s, "A rate card needs rates", "putRateCard", "", `{"validFrom":"2019-04-01T00:00:00Z","rates":[]}`)
+	expectError(t, s, "Rate 1 needs an originRegion", "putRateCard", "",
+		`{"validFrom":"2019-04-01T00:00:00Z","rates":[{"destinationRegion":"北京","pricePerTon":200}]}`)
+	expectError(t, s, "Rate 1 has an empty weight bracket", "putRateCard", "",
+		`{"validFrom":"2019-04-01T00:00:00Z","rates":[{"originRegion":"上海","destinationRegion":"北京","minWeightTon":30,"maxWeightTon":10,"pricePerTon":200}]}`)
+	expectError(t, s, "Rate 1 needs a positive pricePerTon", "putRateCard", "",
+		`{"validFrom":"2019-04-01T00:00:00Z","rates":[{"originRegion":"上海","destinationRegion":"北京"}]}`)
+	expectError(t, s, "6th argument must be a numeric string or auto", "initOrder",
+		"order1", "上海", "北京", "煤炭", "20", "free", "WAIT_DRIVER_ACCEPT", "owner", "broker", "driver")
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ====SEARCH (CLI) ========================================================================
+// 本周在途运单,最新在前 peer chaincode query -C myc1 -n orders -c '{"Args":["searchOrders","{\"party\":\"brokerId0\",\"partyRole\":\"broker\",\"states\":[\"DRIVER_ON_ROAD\"],\"createdFrom\":\"2019-04-01T00:00:00+08:00\",\"sortBy\":\"createdAt\",\"sortOrder\":\"desc\"}","10",""]}'
+// 按重量和运费查询 peer chaincode query -C myc1 -n orders -c '{"Args":["searchOrders","{\"content\":\"煤炭\",\"minWeightTon\":10,\"maxWeightTon\":30,\"maxTransFee\":5000,\"sortBy\":\"transFee\"}"]}'
+//
+// searchOrders takes the order filters of queryRecords and runs them as a Mango query. A
+// LevelDB peer refuses rich queries, so there the orders are looked up through composite-key
+// indexes kept by putOrder, by party (party~order: partyId, role, orderId), by the region
+// codes of their locations (origin~order and destination~order: regionCode, orderId) or by
+// state (state~order: orderState, orderId), and the filters and sort are applied in the
+// chaincode. A LevelDB search therefore needs an orderId, a party, a region or states,
+// except for users who only see their own orders. Orders written before the indexes existed
+// are indexed on their next write.
+// =========================================================================================
+
+const (
+	orderPartyIndex       = "party~order"
+	orderStateIndex       = "state~order"
+	orderOriginIndex      = "origin~order"
+	orderDestinationIndex = "destination~order"
+)
+
+type orderParty struct {
+	role    string
+	partyId string
+}
+
+// orderParties lists the parties of an order by role, leaving out unassigned roles
+func orderParties(order *Order) []orderParty {
+	var parties []orderParty
+	for _, party := range []orderParty{
+		{"goodsOwner", order.GoodsOwnerId},
+		{"broker", order.BrokerId},
+		{"driver", order.DriverId},
+		{"consignee", order.ConsigneeId},
+	} {
+		if party.partyId != "" {
+			parties = append(parties, party)
+		}
+	}
+	return parties
+}
+
+// orderIndexKeys lists the composite keys that index an order for LevelDB searches
+func orderIndexKeys(stub shim.ChaincodeStubInterface, order *Order) ([]string, error) {
+	var keys []string
+	for _, party := range orderParties(order) {
+		key, err := stub.CreateCompositeKey(orderPartyIndex, []string{party.partyId, party.role, order.OrderId})
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	for _, region := range []struct{ index, regionCode string }{
+		{orderOriginIndex, order.OriginRegion},
+		{orderDestinationIndex, order.DestinationRegion},
+	} {
+		if region.regionCode == "" {
+			continue
+		}
+		key, err := stub.CreateCompositeKey(region.index, []string{region.regionCode, order.OrderId})
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	key, err := stub.CreateCompositeKey(orderStateIndex, []string{order.OrderState, order.OrderId})
+	if err != nil {
+		return nil, err
+	}
+	return append(keys, key), nil
+}
+
+// reindexOrder moves the index entries of an order from its previous to its current
+// version; either may be nil for a created or deleted order
+func reindexOrder(stub shim.ChaincodeStubInterface, previous *Order, current *Order) error {
+	stale := map[string]bool{}
+	if previous != nil {
+		keys, err := orderIndexKeys(stub, previous)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			stale[key] = true
+		}
+	}
+	if current != nil {
+		keys, err := orderIndexKeys(stub, current)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if stale[key] {
+				delete(stale, key)
+				continue
+			}
+			//  Only the key name is needed, passing a 'nil' value would delete the key
+			err = stub.PutState(key, []byte{0x00})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for key := range stale {
+		err := stub.DelState(key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexedOrderIds reads the order ids under the partial keys of an index
+func indexedOrderIds(stub shim.ChaincodeStubInterface, index string, partialKeys [][]string, ids map[string]bool) error {
+	for _, partialKey := range partialKeys {
+		resultsIterator, err := stub.GetStateByPartialCompositeKey(index, partialKey)
+		if err != nil {
+			return err
+		}
+		for resultsIterator.HasNext() {
+			queryResponse, err := resultsIterator.Next()
+			if err != nil {
+				resultsIterator.Close()
+				return err
+			}
+			_, keyParts, err := stub.SplitCompositeKey(queryResponse.Key)
+			if err != nil {
+				resultsIterator.Close()
+				return err
+			}
+			ids[keyParts[len(keyParts)-1]] = true
+		}
+		resultsIterator.Close()
+	}
+	return nil
+}
+
+// candidateOrders reads the orders a LevelDB search has to look at, through the narrowest
+// entry point the filter offers
+func candidateOrders(stub shim.ChaincodeStubInterface, caller *User, filter *RecordFilter) (map[string][]byte, error) {
+	ids := map[string]bool{}
+	var err error
+	if filter.OrderId != "" {
+		ids[filter.OrderId] = true
+	} else if filter.Party != "" {
+		partialKey := []string{filter.Party}
+		if filter.PartyRole != "" {
+			partialKey = append(partialKey, filter.PartyRole)
+		}
+		err = indexedOrderIds(stub, orderPartyIndex, [][]string{partialKey}, ids)
+	} else if !isAdmin(caller) && caller.Role != "arbitrator" {
+		err = indexedOrderIds(stub, orderPartyIndex, [][]string{{caller.UserId}}, ids)
+	} else if filter.OriginRegion != "" {
+		err = indexedOrderIds(stub, orderOriginIndex, [][]string{{filter.OriginRegion}}, ids)
+	} else if filter.DestinationRegion != "" {
+		err = indexedOrderIds(stub, orderDestinationIndex, [][]string{{filter.DestinationRegion}}, ids)
+	} else if len(filter.States) > 0 {
+		var partialKeys [][]string
+		for _, state := range filter.States {
+			partialKeys = append(partialKeys, []string{strings.ToUpper(state)})
+		}
+		err = indexedOrderIds(stub, orderStateIndex, partialKeys, ids)
+	} else {
+		return nil, fmt.Errorf("On LevelDB a search needs an orderId, a party or states, or an origin or destination region")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	orders := make(map[string][]byte, len(ids))
+	for orderId := range ids {
+		orderAsBytes, err := stub.GetState(orderId)
+		if err != nil {
+			return nil, err
+		} else if orderAsBytes != nil {
+			orders[orderId] = orderAsBytes
+		}
+	}
+	return orders, nil
+}
+
+// richQueryUnsupported tells whether a query failed because the state database of the peer
+// is LevelDB, which refuses rich queries
+func richQueryUnsupported(err error) bool {
+	return strings.Contains(err.Error(), "not supported for leveldb")
+}
+
+// searchOrdersByIndex runs a search on LevelDB: the query the chaincode would send to
+// CouchDB is evaluated over the orders found through the composite-key indexes
+func searchOrdersByIndex(stub shim.ChaincodeStubInterface, filter *RecordFilter, pageSize int32, bookmark string) ([]byte, error) {
+	caller, err := getCaller(stub)
+	if err != nil {
+		return nil, err
+	}
+	queryString, err := recordQueryString(stub, caller, filter)
+	if err != nil {
+		return nil, err
+	}
+	candidates, err := candidateOrders(stub, caller, filter)
+	if err != nil {
+		return nil, err
+	}
+	queryResults, _, err := executeMangoQuery(candidates, queryString, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	records := make([]QueryRecord, 0, len(queryResults))
+	for _, queryResult := range queryResults {
+		records = append(records, QueryRecord{queryResult.Key, json.RawMessage(queryResult.Value)})
+	}
+	page, err := pageOfRecords(records, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(page)
+}
+
+// ============================================================
+// searchOrders - search orders by party, states, time windows, content and weight or fee
+// ranges, sorted and paginated, on CouchDB or LevelDB
+// ============================================================
+func (t *SimpleChaincode) searchOrders(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0       								1		2
+	// "{\"states\":[\"DRIVER_ON_ROAD\"]}", ["10", "bookmark"]
+	pageSize, bookmark, err := parsePaginationArgs(args, 1)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	filter, err := decodeRecordFilter(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if filter.DocType == "" {
+		filter.DocType = "order"
+	} else if filter.DocType != "order" {
+		return shim.Error("searchOrders only searches orders, got docType " + filter.DocType)
+	}
+
+	queryResults, err := runRecordFilter(stub, filter, pageSize, bookmark)
+	if err != nil && richQueryUnsupported(err) {
+		queryResults, err = searchOrdersByIndex(stub, filter, pageSize, bookmark)
+	}
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(queryResults)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// newSearchFixture creates three orders on consecutive days and puts the first on the road
+// on the fourth day
+func newSearchFixture(t *testing.T, stateDatabase string) *testStub {
+	s := newFixture(t)
+	mustInvoke(t, s, "initUser", "broker2", "承运人2", "broker", "13800000005", "true")
+	s.leveldb = stateDatabase == "leveldb"
+	createOrder(t, s, "order1")
+	s.now = s.now.Add(24 * time.Hour)
+	mustInvoke(t, s, "initOrder", "order2", "上海", "北京", "钢材", "30", "6000", "WAIT_DRIVER_ACCEPT", "owner", "broker", "driver")
+	s.now = s.now.Add(24 * time.Hour)
+	mustInvoke(t, s, "initOrder", "order3", "上海", "北京", "煤炭", "10", "2000", "WAIT_DRIVER_ACCEPT", "owner", "broker2", "driver")
+	s.now = s.now.Add(24 * time.Hour)
+	advanceOrder(t, s, "order1", "DRIVER_ACCEPT_WAIT_ROAD", "DRIVER_ON_ROAD")
+	return s
+}
+
+func TestSearchOrders(t *testing.T) {
+	cases := map[string]string{
+		`{"party":"broker","sortBy":"createdAt","sortOrder":"desc"}`:                                                   "order2,order1",
+		`{"party":"broker","partyRole":"broker","states":["DRIVER_ON_ROAD"]}`:                                          "order1",
+		`{"states":["WAIT_DRIVER_ACCEPT"],"sortBy":"weightTon"}`:                                                       "order3,order2",
+		`{"states":["WAIT_DRIVER_ACCEPT","driver_on_road"],"updatedFrom":"2019-04-04T00:00:00Z"}`:                      "order1",
+		`{"party":"broker","minWeightTon":25}`:                                                                         "order2",
+		`{"party":"broker2","maxTransFee":2000,"content":"煤炭"}`:                                                        "order3",
+		`{"states":["WAIT_DRIVER_ACCEPT","DRIVER_ON_ROAD"],"minTransFee":3000,"sortBy":"transFee","sortOrder":"desc"}`: "order2,order1",
+		`{"party":"owner","createdFrom":"2019-04-02T00:00:00Z","createdTo":"2019-04-03T00:00:00Z"}`:                    "order2",
+		`{"orderId":"order3","party":"driver","sortBy":"updatedAt"}`:                                                   "order3",
+	}
+	for _, stateDatabase := range []string{"couchdb", "leveldb"} {
+		s := newSearchFixture(t, stateDatabase)
+		for filter, want := range cases {
+			keys := queryOrderKeys(t, s, "searchOrders", filter)
+			if got := strings.Join(keys, ","); got != want {
+				t.Errorf("%s %s: expected %s, got %s", stateDatabase, filter, want, got)
+			}
+		}
+	}
+}
+
+func TestSearchOrdersPagination(t *testing.T) {
+	for _, stateDatabase := range []string{"couchdb", "leveldb"} {
+		s := newSearchFixture(t, stateDatabase)
+		filter := `{"party":"owner","sortBy":"createdAt","sortOrder":"desc"}`
+		var orders []OrderWithKey
+		page := unmarshalPage(t, mustInvoke(t, s, "searchOrders", filter, "2", ""), &orders)
+		if len(orders) != 2 || orders[0].Key != "order3" || orders[1].Key != "order2" || !page.HasMore {
+			t.Fatalf("%s: unexpected first page %+v of %+v", stateDatabase, page, orders)
+		}
+		page = unmarshalPage(t, mustInvoke(t, s, "searchOrders", filter, "2", page.Bookmark), &orders)
+		if len(orders) != 1 || orders[0].Key != "order1" || page.HasMore {
+			t.Errorf("%s: unexpected second page %+v of %+v", stateDatabase, page, orders)
+		}
+	}
+}
+
+func TestSearchOrdersAccessRights(t *testing.T) {
+	for _, stateDatabase := range []string{"couchdb", "leveldb"} {
+		s := newSearchFixture(t, stateDatabase)
+		s.caller = "broker2"
+		if keys := strings.Join(queryOrderKeys(t, s, "searchOrders", `{}`), ","); keys != "order3" {
+			t.Errorf("%s: broker2 should only find its own order, got %s", stateDatabase, keys)
+		}
+		if keys := strings.Join(queryOrderKeys(t, s, "searchOrders", `{"party":"broker"}`), ","); keys != "" {
+			t.Errorf("%s: broker2 should not find the orders of broker, got %s", stateDatabase, keys)
+		}
+	}
+}
+
+func TestSearchOrdersLevelDBIndexes(t *testing.T) {
+	s := newSearchFixture(t, "leveldb")
+	expectError(t, s, "needs an orderId, a party or states", "searchOrders", `{"sortBy":"updatedAt"}`)
+
+	mustInvoke(t, s, "delete", "order2")
+	if keys := strings.Join(queryOrderKeys(t, s, "searchOrders", `{"party":"broker"}`), ","); keys != "order1" {
+		t.Errorf("expected the deleted order to leave the index, got %s", keys)
+	}
+	for _, index := range []string{orderPartyIndex, orderStateIndex} {
+		iterator, err := s.GetStateByPartialCompositeKey(index, []string{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for iterator.HasNext() {
+			entry, _ := iterator.Next()
+			if strings.Contains(entry.Key, "order2") {
+				t.Errorf("stale index entry %q", entry.Key)
+			}
+			if strings.Contains(entry.Key, "order1") && strings.Contains(entry.Key, "WAIT_DRIVER_ACCEPT") {
+				t.Errorf("stale state entry %q", entry.Key)
+			}
+		}
+		iterator.Close()
+	}
+}
+
+func TestSearchOrdersRejectsBadFilters(t *testing.T) {
+	s := newSearchFixture(t, "couchdb")
+	expectError(t, s, "only searches orders", "searchOrders", `{"docType":"user"}`)
+	expectError(t, s, "unknown field", "searchOrders", `{"minWeight":1}`)
+	expectError(t, s, "sortBy must be", "searchOrders", `{"sortBy":"createDate"}`)
+	expectError(t, s, "sortOrder must be", "searchOrders", `{"sortBy":"createdAt","sortOrder":"newest"}`)
+	expectError(t, s, "sortOrder needs a sortBy", "searchOrders", `{"sortOrder":"desc"}`)
+	expectError(t, s, "RFC3339", "searchOrders", `{"updatedTo":"2019-04-01"}`)
+	expectError(t, s, "Unknown config setting: stateDatabase", "setConfig", "stateDatabase", "leveldb")
+}
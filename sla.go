@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ====DEADLINES ===========================================================================
+// An order may carry a promised pickup and a promised delivery time. Pickup is met when the
+// order reaches DRIVER_ON_ROAD and delivery when it reaches ARRIVED_WAIT_SIGN, measured
+// against the transaction time. changeStateOrder and updatePositionOrder both re-evaluate
+// the deadlines, so a missed deadline is marked LATE as soon as the order is next touched.
+// =========================================================================================
+
+// orderStage orders the states so deadlines can tell whether a stage has been reached
+var orderStage = map[string]int{
+	"WAIT_DRIVER_ACCEPT":      0,
+	"DRIVER_ACCEPT_WAIT_ROAD": 1,
+	"DRIVER_ON_ROAD":          2,
+	"ARRIVED_WAIT_SIGN":       3,
+	"DISPUTED":                3,
+	"SIGNED":                  4,
+}
+
+// parseDeadline accepts an RFC3339 time and returns it normalized, an empty string meaning none
+func parseDeadline(arg string) (string, error) {
+	if len(arg) == 0 {
+		return "", nil
+	}
+	deadline, err := time.Parse(time.RFC3339, arg)
+	if err != nil {
+		return "", fmt.Errorf("Deadline must be an RFC3339 time: %s", arg)
+	}
+	return deadline.UTC().Format(time.RFC3339), nil
+}
+
+// setDeadlines puts new promised times on an order and marks them pending
+func setDeadlines(order *Order, pickup string, delivery string) error {
+	pickup, err := parseDeadline(pickup)
+	if err != nil {
+		return err
+	}
+	delivery, err = parseDeadline(delivery)
+	if err != nil {
+		return err
+	}
+	if pickup != "" {
+		order.PromisedPickup = pickup
+		order.PickupStatus = "PENDING"
+	}
+	if delivery != "" {
+		order.PromisedDelivery = delivery
+		order.DeliveryStatus = "PENDING"
+	}
+	// RFC3339 times in UTC compare as strings
+	if order.PromisedPickup != "" && order.PromisedDelivery != "" && order.PromisedDelivery < order.PromisedPickup {
+		return fmt.Errorf("Promised delivery %s is before promised pickup %s", order.PromisedDelivery, order.PromisedPickup)
+	}
+	order.SlaStatus = slaStatus(order)
+	return nil
+}
+
+// evaluateDeadline decides a single deadline. ON_TIME and LATE are final.
+func evaluateDeadline(promised string, status string, reached bool, now time.Time) string {
+	if promised == "" || status == "ON_TIME" || status == "LATE" {
+		return status
+	}
+	deadline, err := time.Parse(time.RFC3339, promised)
+	if err != nil {
+		return status
+	}
+	if now.After(deadline) {
+		return "LATE"
+	} else if reached {
+		return "ON_TIME"
+	}
+	return "PENDING"
+}
+
+func slaStatus(order *Order) string {
+	if order.PromisedPickup == "" && order.PromisedDelivery == "" {
+		return ""
+	}
+	if order.PickupStatus == "LATE" || order.DeliveryStatus == "LATE" {
+		return "LATE"
+	}
+	return "ON_TIME"
+}
+
+// evaluateDeadlines brings the deadline statuses of an order up to date with its current
+// state, returning whether anything changed
+func evaluateDeadlines(order *Order, now time.Time) bool {
+	before := order.PickupStatus + order.DeliveryStatus + order.SlaStatus
+	stage, known := orderStage[strings.ToUpper(order.OrderState)]
+	if !known {
+		return false
+	}
+	order.PickupStatus = evaluateDeadline(order.PromisedPickup, order.PickupStatus, stage >= orderStage["DRIVER_ON_ROAD"], now)
+	order.DeliveryStatus = evaluateDeadline(order.PromisedDelivery, order.DeliveryStatus, stage >= orderStage["ARRIVED_WAIT_SIGN"], now)
+	order.SlaStatus = slaStatus(order)
+	return before != order.PickupStatus+order.DeliveryStatus+order.SlaStatus
+}
+
+// ============================================================
+// setOrderDeadlines - set or move the promised times of an open order
+// ============================================================
+func (t *SimpleChaincode) setOrderDeadlines(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0       		1       						2
+	// "orderId0", "2019-04-01T08:00:00+08:00", "2019-04-02T18:00:00+08:00"
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	fmt.Println("- start setOrderDeadlines")
+	orderId := args[0]
+	order, err := getOrder(stub, orderId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = requireOrderRole(stub, order, "goodsOwner", "broker")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !order.Open {
+		return shim.Error("Order " + orderId + " is closed")
+	}
+	if len(args[1]) > 0 && (order.PickupStatus == "ON_TIME" || order.PickupStatus == "LATE") {
+		return shim.Error("Pickup of order " + orderId + " has already been evaluated")
+	}
+	if len(args[2]) > 0 && (order.DeliveryStatus == "ON_TIME" || order.DeliveryStatus == "LATE") {
+		return shim.Error("Delivery of order " + orderId + " has already been evaluated")
+	}
+
+	err = setDeadlines(order, args[1], args[2])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	evaluateDeadlines(order, txTime)
+	res := writeToRecordsLedger(stub, *order, "setOrderDeadlines")
+	if res.Status != shim.OK {
+		return res
+	}
+	fmt.Println("- end setOrderDeadlines")
+	return shim.Success(nil)
+}
+
+// ============================================================
+// queryOverdueOrders - list the open orders of a broker or driver that missed a deadline,
+// among those the caller may read
+// Only available on state databases that support rich query (e.g. CouchDB)
+// ============================================================
+func (t *SimpleChaincode) queryOverdueOrders(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0       	1			2		3
+	// "broker", "brokerId0", ["10", "bookmark"]
+	pageSize, bookmark, err := parsePaginationArgs(args, 2)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	var field string
+	switch args[0] {
+	case "broker":
+		field = "brokerId"
+	case "driver":
+		field = "driverId"
+	default:
+		return shim.Error("1st argument must be broker or driver")
+	}
+
+	queryResults, err := getQueryResultForSelector(stub, map[string]interface{}{"docType": "order", "open": true, field: args[1]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	var orderWithKeys []OrderWithKey
+	err = json.Unmarshal(queryResults, &orderWithKeys)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	caller, err := getCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	// deadlines are evaluated at query time, so the page is cut after filtering
+	overdue := []QueryRecord{}
+	for _, orderWithKey := range orderWithKeys {
+		if !canReadOrder(caller, &orderWithKey.Record) {
+			continue
+		}
+		evaluateDeadlines(&orderWithKey.Record, txTime)
+		if orderWithKey.Record.SlaStatus != "LATE" {
+			continue
+		}
+		orderAsBytes, err := json.Marshal(orderWithKey.Record)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		overdue = append(overdue, QueryRecord{orderWithKey.Key, orderAsBytes})
+	}
+	page, err := pageOfRecords(overdue, pageSize, bookmark)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	overdueAsBytes, err := json.Marshal(page)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(overdueAsBytes)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDeadlinesOnTime(t *testing.T) {
+	s := newFixture(t)
+	mustInvoke(t, s, "initOrder", "order1", "上海", "北京", "煤炭", "20", "4000", "WAIT_DRIVER_ACCEPT", "owner", "broker", "driver",
+		`{"promisedPickup":"2019-04-01T18:00:00+08:00","promisedDelivery":"2019-04-02T18:00:00+08:00"}`)
+	order := readOrder(t, s, "order1")
+	if order.PromisedPickup != "2019-04-01T10:00:00Z" || order.PickupStatus != "PENDING" || order.SlaStatus != "ON_TIME" {
+		t.Errorf("unexpected deadlines %+v", order)
+	}
+
+	deliverOrder(t, s, "order1")
+	order = readOrder(t, s, "order1")
+	if order.PickupStatus != "ON_TIME" || order.DeliveryStatus != "ON_TIME" || order.SlaStatus != "ON_TIME" {
+		t.Errorf("unexpected deadlines %+v", order)
+	}
+}
+
+func TestDeadlinesLate(t *testing.T) {
+	s := newFixture(t)
+	mustInvoke(t, s, "initOrder", "order1", "上海", "北京", "煤炭", "20", "4000", "WAIT_DRIVER_ACCEPT", "owner", "broker", "driver",
+		`{"promisedPickup":"2019-04-01T09:00:00Z"}`)
+	advanceOrder(t, s, "order1", "DRIVER_ACCEPT_WAIT_ROAD")
+
+	// a position report after the deadline is enough to mark the order late
+	s.now = s.now.Add(2 * 60 * 60 * 1e9)
+	mustInvoke(t, s, "updatePositionOrder", "position1", "order1", "1", "Mon, 01 Apr 2019 10:00:00 UTC", "上海")
+	order := readOrder(t, s, "order1")
+	if order.PickupStatus != "LATE" || order.SlaStatus != "LATE" {
+		t.Errorf("unexpected deadlines %+v", order)
+	}
+
+	var overdue []OrderWithKey
+	unmarshalPage(t, mustInvoke(t, s, "queryOverdueOrders", "broker", "broker"), &overdue)
+	if len(overdue) != 1 || overdue[0].Key != "order1" {
+		t.Errorf("unexpected overdue orders %+v", overdue)
+	}
+	page := unmarshalPage(t, mustInvoke(t, s, "queryOverdueOrders", "driver", "driver2", "10", ""), &overdue)
+	if len(overdue) != 0 || page.HasMore {
+		t.Errorf("unexpected overdue orders %+v", overdue)
+	}
+	mustInvoke(t, s, "initUser", "broker2", "承运人2", "broker", "13800000005", "true")
+	s.caller = "broker2"
+	unmarshalPage(t, mustInvoke(t, s, "queryOverdueOrders", "broker", "broker"), &overdue)
+	if len(overdue) != 0 {
+		t.Errorf("broker2 should not see the orders of broker, got %+v", overdue)
+	}
+	s.caller = "admin"
+	expectError(t, s, "1st argument must be broker or driver", "queryOverdueOrders", "owner", "owner")
+	expectError(t, s, "Incorrect number of arguments", "queryOverdueOrders", "broker")
+}
+
+func TestSetOrderDeadlines(t *testing.T) {
+	s := newFixture(t)
+	createOrder(t, s, "order1")
+	s.caller = "driver"
+	expectError(t, s, "User driver is not the goodsOwner or broker of order order1", "setOrderDeadlines", "order1", "2019-04-01T18:00:00Z", "")
+	s.caller = "broker"
+	mustInvoke(t, s, "setOrderDeadlines", "order1", "2019-04-01T18:00:00Z", "2019-04-02T18:00:00Z")
+	s.caller = "admin"
+	order := readOrder(t, s, "order1")
+	if order.PromisedDelivery != "2019-04-02T18:00:00Z" || order.DeliveryStatus != "PENDING" {
+		t.Errorf("unexpected deadlines %+v", order)
+	}
+	if _, ok := order.ChangeStateHistory["setOrderDeadlines"]; !ok {
+		t.Errorf("setOrderDeadlines missing from history %v", order.ChangeStateHistory)
+	}
+
+	expectError(t, s, "is before promised pickup", "setOrderDeadlines", "order1", "", "2019-04-01T12:00:00Z")
+	expectError(t, s, "RFC3339", "setOrderDeadlines", "order1", "tomorrow", "")
+	advanceOrder(t, s, "order1", "DRIVER_ACCEPT_WAIT_ROAD", "DRIVER_ON_ROAD")
+	expectError(t, s, "Pickup of order order1 has already been evaluated", "setOrderDeadlines", "order1", "2019-04-01T20:00:00Z", "")
+	expectError(t, s, "Order does not exist", "setOrderDeadlines", "missing", "", "")
+	expectError(t, s, "Incorrect number of arguments", "setOrderDeadlines", "order1")
+}
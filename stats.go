@@ -0,0 +1,330 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ====STATISTICS (CLI) ====================================================================
+// 承运人本周统计 peer chaincode query -C myc1 -n orders -c '{"Args":["queryOrderStats","broker","brokerId0","2019-04-01","2019-04-07"]}'
+// 司机全部统计 peer chaincode query -C myc1 -n orders -c '{"Args":["queryOrderStats","driver","driverId"]}'
+// 压缩统计(仅管理员) peer chaincode invoke -C myc1 -n orders -c '{"Args":["compactOrderStats","broker","brokerId0"]}'
+// 压缩全部统计(仅管理员) peer chaincode invoke -C myc1 -n orders -c '{"Args":["compactOrderStats"]}'
+//
+// Orders are counted in per-party, per-day buckets (stats~party~day: role, partyId, day the
+// order was created), so a statistics query only adds up the days of its window instead of
+// rescanning the orders. As with the party summaries, an order write does not read and
+// rewrite the buckets, which would make concurrent writes of one party's orders fail MVCC
+// validation, but adds a delta moving the order between them (stats~party~day~delta: role,
+// partyId, day, txId, orderId). Queries add the pending deltas to the buckets;
+// compactOrderStats, run periodically by an admin, folds them in. Delivery durations run from the time the order
+// last entered DRIVER_ON_ROAD to SIGNED; on-time counts signed orders with promised times
+// whose SLA was met.
+// =========================================================================================
+
+const (
+	orderStatsIndex      = "stats~party~day"
+	orderStatsDeltaIndex = "stats~party~day~delta"
+)
+
+const statsDayLayout = "2006-01-02"
+
+// addOrderToStats adds (sign 1) or removes (sign -1) the contribution of an order
+func addOrderToStats(stats *OrderStats, order *Order, sign int) {
+	stats.OrderCount += sign
+	stats.StateCounts[order.OrderState] += sign
+	if stats.StateCounts[order.OrderState] == 0 {
+		delete(stats.StateCounts, order.OrderState)
+	}
+	stats.WeightTon += float64(sign) * order.WeightTon
+	stats.TransFee += float64(sign) * order.TransFee
+	if order.OrderState != "SIGNED" {
+		return
+	}
+	onRoad, err := time.Parse(time.RFC3339, order.StateEnteredAt["DRIVER_ON_ROAD"])
+	if err == nil {
+		signed, err := time.Parse(time.RFC3339, order.StateEnteredAt["SIGNED"])
+		if err == nil {
+			stats.DeliveredCount += sign
+			stats.DeliverySeconds += float64(sign) * signed.Sub(onRoad).Seconds()
+		}
+	}
+	if order.SlaStatus != "" {
+		stats.SlaCount += sign
+		if order.SlaStatus == "ON_TIME" {
+			stats.OnTimeCount += sign
+		}
+	}
+}
+
+// isZero tells whether a delta changes nothing
+func (stats *OrderStats) isZero() bool {
+	return stats.OrderCount == 0 && len(stats.StateCounts) == 0 && stats.WeightTon == 0 && stats.TransFee == 0 &&
+		stats.DeliveredCount == 0 && stats.DeliverySeconds == 0 && stats.SlaCount == 0 && stats.OnTimeCount == 0
+}
+
+// add folds the counters of a day or of a delta into stats
+func (stats *OrderStats) add(other *OrderStats) {
+	stats.OrderCount += other.OrderCount
+	for state, count := range other.StateCounts {
+		stats.StateCounts[state] += count
+		if stats.StateCounts[state] == 0 {
+			delete(stats.StateCounts, state)
+		}
+	}
+	stats.WeightTon += other.WeightTon
+	stats.TransFee += other.TransFee
+	stats.DeliveredCount += other.DeliveredCount
+	stats.DeliverySeconds += other.DeliverySeconds
+	stats.SlaCount += other.SlaCount
+	stats.OnTimeCount += other.OnTimeCount
+}
+
+func getStatsBucket(stub shim.ChaincodeStubInterface, key string, party orderParty, day string) (*OrderStats, error) {
+	stats := &OrderStats{ObjectType: "orderStats", PartyId: party.partyId, PartyRole: party.role, Day: day,
+		StateCounts: make(map[string]int)}
+	statsAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	} else if statsAsBytes == nil {
+		return stats, nil
+	}
+	err = json.Unmarshal(statsAsBytes, stats)
+	if stats.StateCounts == nil {
+		stats.StateCounts = make(map[string]int)
+	}
+	return stats, err
+}
+
+// readStatsDocuments reads the day buckets or the deltas under a partial key, with their keys
+func readStatsDocuments(stub shim.ChaincodeStubInterface, index string, partialKey []string) ([]string, []*OrderStats, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(index, partialKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resultsIterator.Close()
+	var keys []string
+	var documents []*OrderStats
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		stats := &OrderStats{}
+		err = json.Unmarshal(queryResponse.Value, stats)
+		if err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, queryResponse.Key)
+		documents = append(documents, stats)
+	}
+	return keys, documents, nil
+}
+
+// updateOrderStats records how an order write moves the order between the buckets of its
+// previous version and those of its current one; either may be nil for a created or deleted
+// order. Orders written before createdAt existed have no day and are not counted.
+func updateOrderStats(stub shim.ChaincodeStubInterface, previous *Order, current *Order) error {
+	order := current
+	if order == nil {
+		order = previous
+	}
+	deltas := map[string]*OrderStats{}
+	var keys []string
+	for _, change := range []struct {
+		order *Order
+		sign  int
+	}{{previous, -1}, {current, 1}} {
+		if change.order == nil || len(change.order.CreatedAt) < len(statsDayLayout) {
+			continue
+		}
+		day := change.order.CreatedAt[:len(statsDayLayout)]
+		for _, party := range orderParties(change.order) {
+			key, err := stub.CreateCompositeKey(orderStatsDeltaIndex,
+				[]string{party.role, party.partyId, day, stub.GetTxID(), order.OrderId})
+			if err != nil {
+				return err
+			}
+			delta, ok := deltas[key]
+			if !ok {
+				delta = &OrderStats{ObjectType: "orderStatsDelta", PartyId: party.partyId, PartyRole: party.role, Day: day,
+					StateCounts: make(map[string]int)}
+				deltas[key] = delta
+				keys = append(keys, key)
+			}
+			addOrderToStats(delta, change.order, change.sign)
+		}
+	}
+
+	for _, key := range keys {
+		delta := deltas[key]
+		if delta.isZero() {
+			continue
+		}
+		deltaAsBytes, err := json.Marshal(delta)
+		if err != nil {
+			return err
+		}
+		err = stub.PutState(key, deltaAsBytes)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compactOrderStatsDeltas folds the deltas under a partial key into their day buckets and
+// deletes them, deleting the buckets left empty
+func compactOrderStatsDeltas(stub shim.ChaincodeStubInterface, partialKey []string) error {
+	deltaKeys, deltas, err := readStatsDocuments(stub, orderStatsDeltaIndex, partialKey)
+	if err != nil {
+		return err
+	}
+	buckets := map[string]*OrderStats{}
+	var keys []string
+	for i, delta := range deltas {
+		key, err := stub.CreateCompositeKey(orderStatsIndex, []string{delta.PartyRole, delta.PartyId, delta.Day})
+		if err != nil {
+			return err
+		}
+		stats, ok := buckets[key]
+		if !ok {
+			stats, err = getStatsBucket(stub, key, orderParty{delta.PartyRole, delta.PartyId}, delta.Day)
+			if err != nil {
+				return err
+			}
+			buckets[key] = stats
+			keys = append(keys, key)
+		}
+		stats.add(delta)
+		err = stub.DelState(deltaKeys[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, key := range keys {
+		stats := buckets[key]
+		if stats.OrderCount == 0 {
+			err := stub.DelState(key)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		statsAsBytes, err := json.Marshal(stats)
+		if err != nil {
+			return err
+		}
+		err = stub.PutState(key, statsAsBytes)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseStatsDay accepts a day as 2006-01-02, an empty string meaning unbounded
+func parseStatsDay(arg string) (string, error) {
+	if arg == "" {
+		return "", nil
+	}
+	_, err := time.Parse(statsDayLayout, arg)
+	if err != nil {
+		return "", fmt.Errorf("Days must be given as 2006-01-02: %s", arg)
+	}
+	return arg, nil
+}
+
+// ============================================================
+// queryOrderStats - order counts by state, tonnage, fees, average delivery time and
+// on-time share of a party's orders created between two days, both inclusive
+// ============================================================
+func (t *SimpleChaincode) queryOrderStats(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0       		1			2				3
+	// "broker", "brokerId0", ["2019-04-01", "2019-04-07"]
+	if len(args) != 2 && len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 2 or 4")
+	}
+	partyRole, partyId := args[0], args[1]
+	if partyRole == "" {
+		return shim.Error("partyRole must be goodsOwner, broker, driver or consignee")
+	}
+	_, err := partyFields(partyRole)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	var from, to string
+	if len(args) == 4 {
+		from, err = parseStatsDay(args[2])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		to, err = parseStatsDay(args[3])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	caller, err := getCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !isAdmin(caller) && caller.Role != "arbitrator" && caller.UserId != partyId {
+		return shim.Error("User " + caller.UserId + " cannot read the statistics of " + partyId)
+	}
+
+	summary := OrderStats{ObjectType: "orderStats", PartyId: partyId, PartyRole: partyRole, From: from, To: to,
+		StateCounts: make(map[string]int)}
+	for _, index := range []string{orderStatsIndex, orderStatsDeltaIndex} {
+		_, days, err := readStatsDocuments(stub, index, []string{partyRole, partyId})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		for _, day := range days {
+			if (from != "" && day.Day < from) || (to != "" && day.Day > to) {
+				continue
+			}
+			summary.add(day)
+			if index == orderStatsDeltaIndex {
+				summary.PendingDeltas++
+			}
+		}
+	}
+	if summary.DeliveredCount > 0 {
+		summary.AverageDeliveryHours = summary.DeliverySeconds / float64(summary.DeliveredCount) / 3600
+	}
+	if summary.SlaCount > 0 {
+		summary.OnTimePercent = float64(summary.OnTimeCount) * 100 / float64(summary.SlaCount)
+	}
+
+	summaryAsBytes, err := json.Marshal(summary)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(summaryAsBytes)
+}
+
+// ============================================================
+// compactOrderStats - fold the pending deltas of one party, of one role, or of every party
+// into their day buckets
+// ============================================================
+func (t *SimpleChaincode) compactOrderStats(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0       		1
+	// ["broker", ["brokerId0"]]
+	if len(args) > 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 0, 1 or 2")
+	}
+	err := requireAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = compactOrderStatsDeltas(stub, args)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
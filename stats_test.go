@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+)
+
+func readStats(t *testing.T, s *testStub, args ...string) OrderStats {
+	t.Helper()
+	var stats OrderStats
+	err := json.Unmarshal(mustInvoke(t, s, "queryOrderStats", args...), &stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stats
+}
+
+func TestQueryOrderStats(t *testing.T) {
+	s := newFixture(t)
+	mustInvoke(t, s, "initUser", "broker2", "承运人2", "broker", "13800000005", "true")
+	mustInvoke(t, s, "initOrder", "order1", "上海", "北京", "煤炭", "20", "4000", "WAIT_DRIVER_ACCEPT", "owner", "broker", "driver",
+		`{"promisedDelivery":"2019-04-05T00:00:00Z"}`)
+	advanceOrder(t, s, "order1", "DRIVER_ACCEPT_WAIT_ROAD", "DRIVER_ON_ROAD")
+	s.now = s.now.Add(2 * time.Hour)
+	advanceOrder(t, s, "order1", "ARRIVED_WAIT_SIGN")
+	signOrder(t, s, "order1")
+	s.now = s.now.Add(24 * time.Hour)
+	mustInvoke(t, s, "initOrder", "order2", "上海", "北京", "钢材", "30", "6000", "WAIT_DRIVER_ACCEPT", "owner", "broker", "driver")
+	mustInvoke(t, s, "initOrder", "order3", "上海", "北京", "煤炭", "10", "2000", "WAIT_DRIVER_ACCEPT", "owner", "broker2", "driver")
+
+	stats := readStats(t, s, "broker", "broker")
+	if stats.OrderCount != 2 || stats.StateCounts["SIGNED"] != 1 || stats.StateCounts["WAIT_DRIVER_ACCEPT"] != 1 ||
+		len(stats.StateCounts) != 2 || stats.WeightTon != 50 || stats.TransFee != 10000 {
+		t.Errorf("unexpected totals %+v", stats)
+	}
+	// on the road, a minute to arrive after two hours, a minute to register the signing key
+	// and a minute to sign
+	if stats.DeliveredCount != 1 || math.Abs(stats.AverageDeliveryHours-(2+3.0/60)) > 1e-9 {
+		t.Errorf("unexpected delivery duration %+v", stats)
+	}
+	if stats.SlaCount != 1 || stats.OnTimePercent != 100 {
+		t.Errorf("unexpected on-time share %+v", stats)
+	}
+	if stats := readStats(t, s, "goodsOwner", "owner"); stats.OrderCount != 3 || stats.WeightTon != 60 {
+		t.Errorf("unexpected goods owner totals %+v", stats)
+	}
+
+	stats = readStats(t, s, "broker", "broker", "2019-04-02", "2019-04-02")
+	if stats.OrderCount != 1 || stats.WeightTon != 30 || stats.DeliveredCount != 0 || stats.From != "2019-04-02" {
+		t.Errorf("unexpected window %+v", stats)
+	}
+	stats = readStats(t, s, "broker", "broker", "", "2019-04-01")
+	if stats.OrderCount != 1 || stats.StateCounts["SIGNED"] != 1 {
+		t.Errorf("unexpected open-ended window %+v", stats)
+	}
+
+	mustInvoke(t, s, "delete", "order2")
+	if stats := readStats(t, s, "broker", "broker", "2019-04-02", "2019-04-02"); stats.OrderCount != 0 || len(stats.StateCounts) != 0 {
+		t.Errorf("expected the deleted order to leave the statistics, got %+v", stats)
+	}
+}
+
+func TestQueryOrderStatsAccessAndErrors(t *testing.T) {
+	s := newFixture(t)
+	createOrder(t, s, "order1")
+	s.caller = "broker"
+	if stats := readStats(t, s, "broker", "broker"); stats.OrderCount != 1 {
+		t.Errorf("broker should read its own statistics, got %+v", stats)
+	}
+	expectError(t, s, "cannot read the statistics of driver", "queryOrderStats", "driver", "driver")
+	s.caller = "arbitrator"
+	readStats(t, s, "driver", "driver")
+
+	expectError(t, s, "Incorrect number of arguments", "queryOrderStats", "broker")
+	expectError(t, s, "partyRole must be", "queryOrderStats", "owner", "owner")
+	expectError(t, s, "partyRole must be", "queryOrderStats", "", "owner")
+	expectError(t, s, "2006-01-02", "queryOrderStats", "broker", "broker", "2019-04-01T00:00:00Z", "")
+}
+
+func TestStateEnteredAt(t *testing.T) {
+	s := newFixture(t)
+	createOrder(t, s, "order1")
+	advanceOrder(t, s, "order1", "DRIVER_ACCEPT_WAIT_ROAD")
+	order := readOrder(t, s, "order1")
+	if order.StateEnteredAt["WAIT_DRIVER_ACCEPT"] != "2019-04-01T08:06:00Z" ||
+		order.StateEnteredAt["DRIVER_ACCEPT_WAIT_ROAD"] != "2019-04-01T08:07:00Z" || order.UpdatedAt != "2019-04-01T08:07:00Z" {
+		t.Errorf("unexpected state times %v, updated %s", order.StateEnteredAt, order.UpdatedAt)
+	}
+}
+
+func TestCompactOrderStats(t *testing.T) {
+	s := newFixture(t)
+	createOrder(t, s, "order1")
+	mustInvoke(t, s, "initOrder", "order2", "上海", "北京", "钢材", "30", "6000", "WAIT_DRIVER_ACCEPT", "owner", "broker", "driver")
+	advanceOrder(t, s, "order1", "DRIVER_ACCEPT_WAIT_ROAD")
+
+	// order writes only add deltas, the day buckets are written by compaction
+	before := readStats(t, s, "broker", "broker")
+	if before.OrderCount != 2 || before.StateCounts["DRIVER_ACCEPT_WAIT_ROAD"] != 1 || before.PendingDeltas != 3 {
+		t.Errorf("unexpected statistics before compaction %+v", before)
+	}
+	s.caller = "broker"
+	expectError(t, s, "Only an admin can call compactOrderStats", "compactOrderStats", "broker", "broker")
+	s.caller = "admin"
+	mustInvoke(t, s, "compactOrderStats", "broker", "broker")
+	after := readStats(t, s, "broker", "broker")
+	if after.PendingDeltas != 0 || after.OrderCount != 2 || after.StateCounts["DRIVER_ACCEPT_WAIT_ROAD"] != 1 ||
+		after.StateCounts["WAIT_DRIVER_ACCEPT"] != 1 || after.WeightTon != 50 {
+		t.Errorf("compaction changed the statistics: %+v", after)
+	}
+	if driver := readStats(t, s, "driver", "driver"); driver.PendingDeltas != 3 {
+		t.Errorf("compacting broker should leave the deltas of driver, got %+v", driver)
+	}
+
+	mustInvoke(t, s, "delete", "order2")
+	mustInvoke(t, s, "compactOrderStats")
+	for _, party := range [][]string{{"goodsOwner", "owner"}, {"broker", "broker"}, {"driver", "driver"}} {
+		if stats := readStats(t, s, party...); stats.PendingDeltas != 0 || stats.OrderCount != 1 || stats.WeightTon != 20 {
+			t.Errorf("unexpected statistics of %s after compacting every party: %+v", party[1], stats)
+		}
+	}
+	expectError(t, s, "Incorrect number of arguments", "compactOrderStats", "broker", "broker", "driver")
+}
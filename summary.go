@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ====PARTY SUMMARIES (CLI) ===============================================================
+// 查询汇总 peer chaincode query -C myc1 -n orders -c '{"Args":["readPartySummary","brokerId0"]}'
+// 压缩汇总(仅管理员) peer chaincode invoke -C myc1 -n orders -c '{"Args":["compactPartySummaries","brokerId0"]}'
+// 压缩全部汇总(仅管理员) peer chaincode invoke -C myc1 -n orders -c '{"Args":["compactPartySummaries"]}'
+//
+// Each party has running counters: orders open, orders signed, tons moved and fees earned.
+// A busy broker is a party of many concurrent transactions, so the counters are never read
+// and rewritten on write, which would make those transactions fail MVCC validation. Instead
+// every order write and every payout adds a delta document under its own key
+// (partySummary~delta: partyId, txId, source). readPartySummary folds the deltas into the
+// compacted summary; compactPartySummaries, run periodically by an admin, does the same and
+// writes the result back, deleting the deltas it folded.
+// =========================================================================================
+
+const (
+	partySummaryIndex      = "partySummary"
+	partySummaryDeltaIndex = "partySummary~delta"
+)
+
+// orderSummary is what an order contributes to the counters of each of its parties
+func orderSummary(order *Order) PartySummary {
+	var summary PartySummary
+	if order.Open {
+		summary.OrdersOpen = 1
+	}
+	if order.OrderState == "SIGNED" {
+		summary.OrdersSigned = 1
+		summary.TonsMoved = order.WeightTon
+	}
+	return summary
+}
+
+func (summary *PartySummary) add(delta PartySummary, sign int) {
+	summary.OrdersOpen += sign * delta.OrdersOpen
+	summary.OrdersSigned += sign * delta.OrdersSigned
+	summary.TonsMoved += float64(sign) * delta.TonsMoved
+	summary.FeesEarned += float64(sign) * delta.FeesEarned
+}
+
+func (summary PartySummary) isZero() bool {
+	return summary.OrdersOpen == 0 && summary.OrdersSigned == 0 && summary.TonsMoved == 0 && summary.FeesEarned == 0
+}
+
+// addSummaryDelta records a change to the counters of a party. source tells the deltas of
+// one transaction apart, a later delta with the same source would replace the earlier one.
+func addSummaryDelta(stub shim.ChaincodeStubInterface, partyId string, source string, delta PartySummary) error {
+	if partyId == "" || delta.isZero() {
+		return nil
+	}
+	key, err := stub.CreateCompositeKey(partySummaryDeltaIndex, []string{partyId, stub.GetTxID(), source})
+	if err != nil {
+		return err
+	}
+	delta.ObjectType = "partySummaryDelta"
+	delta.PartyId = partyId
+	deltaAsBytes, err := json.Marshal(delta)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, deltaAsBytes)
+}
+
+// updatePartySummaries records how an order write changes the counters of its parties;
+// either version may be nil for a created or deleted order
+func updatePartySummaries(stub shim.ChaincodeStubInterface, previous *Order, current *Order) error {
+	deltas := map[orderParty]PartySummary{}
+	var parties []orderParty
+	for _, change := range []struct {
+		order *Order
+		sign  int
+	}{{previous, -1}, {current, 1}} {
+		if change.order == nil {
+			continue
+		}
+		contribution := orderSummary(change.order)
+		for _, party := range orderParties(change.order) {
+			delta, ok := deltas[party]
+			if !ok {
+				parties = append(parties, party)
+			}
+			delta.add(contribution, change.sign)
+			deltas[party] = delta
+		}
+	}
+	order := current
+	if order == nil {
+		order = previous
+	}
+	for _, party := range parties {
+		err := addSummaryDelta(stub, party.partyId, order.OrderId+"/"+party.role, deltas[party])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// foldPartySummary reads the compacted summary of a party and folds its pending deltas
+// into it, returning the keys of the deltas
+func foldPartySummary(stub shim.ChaincodeStubInterface, partyId string) (*PartySummary, []string, error) {
+	summary := &PartySummary{ObjectType: "partySummary", PartyId: partyId}
+	key, err := stub.CreateCompositeKey(partySummaryIndex, []string{partyId})
+	if err != nil {
+		return nil, nil, err
+	}
+	summaryAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, nil, err
+	} else if summaryAsBytes != nil {
+		err = json.Unmarshal(summaryAsBytes, summary)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(partySummaryDeltaIndex, []string{partyId})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resultsIterator.Close()
+	var deltaKeys []string
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		var delta PartySummary
+		err = json.Unmarshal(queryResponse.Value, &delta)
+		if err != nil {
+			return nil, nil, err
+		}
+		summary.add(delta, 1)
+		deltaKeys = append(deltaKeys, queryResponse.Key)
+	}
+	return summary, deltaKeys, nil
+}
+
+// compactPartySummary folds the deltas of a party into its summary and deletes them
+func compactPartySummary(stub shim.ChaincodeStubInterface, partyId string, compactedAt string) error {
+	summary, deltaKeys, err := foldPartySummary(stub, partyId)
+	if err != nil {
+		return err
+	}
+	if len(deltaKeys) == 0 {
+		return nil
+	}
+	summary.CompactedAt = compactedAt
+	summaryAsBytes, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	key, err := stub.CreateCompositeKey(partySummaryIndex, []string{partyId})
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(key, summaryAsBytes)
+	if err != nil {
+		return err
+	}
+	for _, deltaKey := range deltaKeys {
+		err = stub.DelState(deltaKey)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ============================================================
+// readPartySummary - read the running counters of a party
+// ============================================================
+func (t *SimpleChaincode) readPartySummary(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0
+	// "brokerId0"
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	partyId := args[0]
+	caller, err := getCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !isAdmin(caller) && caller.Role != "arbitrator" && caller.UserId != partyId {
+		return shim.Error("User " + caller.UserId + " cannot read the summary of " + partyId)
+	}
+	summary, deltaKeys, err := foldPartySummary(stub, partyId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	summary.PendingDeltas = len(deltaKeys)
+	summaryAsBytes, err := json.Marshal(summary)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(summaryAsBytes)
+}
+
+// ============================================================
+// compactPartySummaries - fold the pending deltas of one party, or of every party, into
+// their summaries
+// ============================================================
+func (t *SimpleChaincode) compactPartySummaries(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0
+	// ["brokerId0"]
+	if len(args) > 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 0 or 1")
+	}
+	caller, err := getCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !isAdmin(caller) {
+		return shim.Error(fmt.Sprintf("Only an admin can compact summaries, %s is %s", caller.UserId, caller.Role))
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	compactedAt := txTime.Format(time.RFC3339)
+
+	partyIds := args
+	if len(args) == 0 {
+		resultsIterator, err := stub.GetStateByPartialCompositeKey(partySummaryDeltaIndex, []string{})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		defer resultsIterator.Close()
+		seen := map[string]bool{}
+		for resultsIterator.HasNext() {
+			queryResponse, err := resultsIterator.Next()
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			_, keyParts, err := stub.SplitCompositeKey(queryResponse.Key)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			if !seen[keyParts[0]] {
+				seen[keyParts[0]] = true
+				partyIds = append(partyIds, keyParts[0])
+			}
+		}
+	}
+	for _, partyId := range partyIds {
+		err = compactPartySummary(stub, partyId, compactedAt)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	return shim.Success(nil)
+}
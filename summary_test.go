@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func readSummary(t *testing.T, s *testStub, partyId string) PartySummary {
+	t.Helper()
+	var summary PartySummary
+	err := json.Unmarshal(mustInvoke(t, s, "readPartySummary", partyId), &summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return summary
+}
+
+func TestPartySummaries(t *testing.T) {
+	s := newFixture(t)
+	createOrder(t, s, "order1")
+	mustInvoke(t, s, "initOrder", "order2", "上海", "北京", "钢材", "30", "6000", "WAIT_DRIVER_ACCEPT", "owner", "broker", "driver")
+	deliverOrder(t, s, "order1")
+	signOrder(t, s, "order1")
+
+	broker := readSummary(t, s, "broker")
+	if broker.OrdersOpen != 1 || broker.OrdersSigned != 1 || broker.TonsMoved != 20 || broker.FeesEarned != 400 {
+		t.Errorf("unexpected broker summary %+v", broker)
+	}
+	if driver := readSummary(t, s, "driver"); driver.FeesEarned != 3600 || driver.TonsMoved != 20 {
+		t.Errorf("unexpected driver summary %+v", driver)
+	}
+	if owner := readSummary(t, s, "owner"); owner.OrdersOpen != 1 || owner.OrdersSigned != 1 || owner.FeesEarned != 0 {
+		t.Errorf("unexpected goods owner summary %+v", owner)
+	}
+	if broker.PendingDeltas == 0 || broker.CompactedAt != "" {
+		t.Errorf("expected uncompacted deltas, got %+v", broker)
+	}
+
+	mustInvoke(t, s, "compactPartySummaries", "broker")
+	compacted := readSummary(t, s, "broker")
+	if compacted.PendingDeltas != 0 || compacted.CompactedAt == "" || compacted.OrdersOpen != 1 ||
+		compacted.OrdersSigned != 1 || compacted.TonsMoved != 20 || compacted.FeesEarned != 400 {
+		t.Errorf("compaction changed the summary: %+v", compacted)
+	}
+	if driver := readSummary(t, s, "driver"); driver.PendingDeltas == 0 {
+		t.Errorf("compacting broker should leave the deltas of driver, got %+v", driver)
+	}
+
+	mustInvoke(t, s, "cancelOrder", "order2", "owner", "no longer needed")
+	if broker := readSummary(t, s, "broker"); broker.OrdersOpen != 0 || broker.PendingDeltas != 1 {
+		t.Errorf("expected a delta closing order2, got %+v", broker)
+	}
+	mustInvoke(t, s, "compactPartySummaries")
+	for _, partyId := range []string{"owner", "broker", "driver"} {
+		if summary := readSummary(t, s, partyId); summary.PendingDeltas != 0 || summary.OrdersOpen != 0 || summary.OrdersSigned != 1 {
+			t.Errorf("unexpected summary of %s after compacting every party: %+v", partyId, summary)
+		}
+	}
+}
+
+func TestPartySummaryAccess(t *testing.T) {
+	s := newFixture(t)
+	createOrder(t, s, "order1")
+	s.caller = "broker"
+	if broker := readSummary(t, s, "broker"); broker.OrdersOpen != 1 {
+		t.Errorf("broker should read its own summary, got %+v", broker)
+	}
+	expectError(t, s, "cannot read the summary of driver", "readPartySummary", "driver")
+	expectError(t, s, "Only an admin can compact summaries", "compactPartySummaries", "broker")
+	expectError(t, s, "Incorrect number of arguments", "readPartySummary")
+	s.caller = "admin"
+	expectError(t, s, "Incorrect number of arguments", "compactPartySummaries", "broker", "driver")
+}
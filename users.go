@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ====USERS (CLI) =========================================================================
+// 停用用户(仅管理员) peer chaincode invoke -C myc1 -n orders -c '{"Args":["suspendUser","driverId","证件过期"]}'
+// 启用用户(仅管理员) peer chaincode invoke -C myc1 -n orders -c '{"Args":["activateUser","driverId","证件已更新"]}'
+// 注销用户(仅管理员) peer chaincode invoke -C myc1 -n orders -c '{"Args":["deactivateUser","driverId","离职"]}'
+//
+// A user is ACTIVE, SUSPENDED or DEACTIVATED. Suspended users can be activated again;
+// deactivation is final and replaces deleting a user, so that the record and its history stay
+// on the ledger for the orders that name it. Users with open orders cannot be deactivated.
+// Only ACTIVE users may submit transactions or be made a party of a new order.
+// =========================================================================================
+
+var userRoles = map[string]bool{
+	"goodsOwner": true,
+	"broker":     true,
+	"driver":     true,
+	"consignee":  true,
+	"admin":      true,
+	"arbitrator": true,
+}
+
+// userTransitions lists the statuses a user may be moved to from each status
+var userTransitions = map[string][]string{
+	"ACTIVE":    {"SUSPENDED", "DEACTIVATED"},
+	"SUSPENDED": {"ACTIVE", "DEACTIVATED"},
+}
+
+func checkUserRole(role string) error {
+	if !userRoles[role] {
+		return fmt.Errorf("Role must be goodsOwner, broker, driver, consignee, admin or arbitrator, got %s", role)
+	}
+	return nil
+}
+
+// userStatus returns the status of a user, deriving it from valid for users registered
+// before statuses existed
+func userStatus(user *User) string {
+	if user.Status != "" {
+		return user.Status
+	} else if user.Valid {
+		return "ACTIVE"
+	}
+	return "SUSPENDED"
+}
+
+func putUser(stub shim.ChaincodeStubInterface, user *User) error {
+	userAsBytes, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(user.UserId, userAsBytes)
+}
+
+// hasOpenOrders reports whether a user is a party of an open order, looked up through the
+// party index kept by putOrder
+func hasOpenOrders(stub shim.ChaincodeStubInterface, userId string) (bool, error) {
+	ids := map[string]bool{}
+	err := indexedOrderIds(stub, orderPartyIndex, [][]string{{userId}}, ids)
+	if err != nil {
+		return false, err
+	}
+	for orderId := range ids {
+		order, err := getOrder(stub, orderId)
+		if err != nil {
+			return false, err
+		}
+		if order.Open {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// checkPartiesActive refuses an order naming a registered user who is not ACTIVE. Party ids
+// that are not registered users are left alone.
+func checkPartiesActive(stub shim.ChaincodeStubInterface, order *Order) error {
+	for _, party := range orderParties(order) {
+		userAsBytes, err := stub.GetState(party.partyId)
+		if err != nil {
+			return err
+		} else if userAsBytes == nil {
+			continue
+		}
+		user := &User{}
+		err = json.Unmarshal(userAsBytes, user)
+		if err != nil {
+			return err
+		}
+		if user.ObjectType == "user" && userStatus(user) != "ACTIVE" {
+			return fmt.Errorf("User %s is %s and cannot be the %s of an order", user.UserId, userStatus(user), party.role)
+		}
+	}
+	return nil
+}
+
+// changeUserStatus moves a user to a new status on behalf of an admin
+func changeUserStatus(stub shim.ChaincodeStubInterface, userId string, status string, reason string) error {
+	if reason == "" {
+		return fmt.Errorf("A reason is required")
+	}
+	caller, err := getCaller(stub)
+	if err != nil {
+		return err
+	}
+	if !isAdmin(caller) {
+		return fmt.Errorf("Only an admin can change the status of users, %s is %s", caller.UserId, caller.Role)
+	}
+	user, err := getUser(stub, userId)
+	if err != nil {
+		return err
+	}
+	allowed := false
+	for _, next := range userTransitions[userStatus(user)] {
+		if next == status {
+			allowed = true
+		}
+	}
+	if !allowed {
+		return fmt.Errorf("User %s cannot change from %s to %s", userId, userStatus(user), status)
+	}
+	if status == "DEACTIVATED" {
+		open, err := hasOpenOrders(stub, userId)
+		if err != nil {
+			return err
+		} else if open {
+			return fmt.Errorf("User %s has open orders and cannot be deactivated", userId)
+		}
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return err
+	}
+	user.Status = status
+	user.Valid = status == "ACTIVE"
+	user.StatusReason = reason
+	user.StatusChangedBy = caller.UserId
+	user.StatusChangedAt = txTime.Format(time.RFC3339)
+	return putUser(stub, user)
+}
+
+// ============================================================
+// activateUser - let a suspended user transact again
+// ============================================================
+func (t *SimpleChaincode) activateUser(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0       		1
+	// "driverId", "reason"
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	err := changeUserStatus(stub, args[0], "ACTIVE", args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ============================================================
+// suspendUser - stop a user from transacting until activated again
+// ============================================================
+func (t *SimpleChaincode) suspendUser(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0       		1
+	// "driverId", "reason"
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	err := changeUserStatus(stub, args[0], "SUSPENDED", args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ============================================================
+// deactivateUser - retire a user for good, keeping the record
+// ============================================================
+func (t *SimpleChaincode) deactivateUser(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0       		1
+	// "driverId", "reason"
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	err := changeUserStatus(stub, args[0], "DEACTIVATED", args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
@@ -0,0 +1,59 @@
+package main
+
+import "testing"
+
+func readUser(t *testing.T, s *testStub, userId string) User {
+	t.Helper()
+	var userGenerated UserGenerated
+	unmarshalPayload(t, mustInvoke(t, s, "readUser", userId), &userGenerated)
+	return userGenerated.User
+}
+
+func TestUserStatusTransitions(t *testing.T) {
+	s := newFixture(t)
+	expectError(t, s, "A reason is required", "suspendUser", "driver", "")
+	mustInvoke(t, s, "suspendUser", "driver", "证件过期")
+
+	user := readUser(t, s, "driver")
+	if user.Status != "SUSPENDED" || user.Valid || user.StatusReason != "证件过期" || user.StatusChangedBy != "admin" ||
+		user.StatusChangedAt != "2019-04-01T08:07:00Z" {
+		t.Errorf("unexpected suspended user %+v", user)
+	}
+	expectError(t, s, "cannot change from SUSPENDED to SUSPENDED", "suspendUser", "driver", "again")
+	s.caller = "driver"
+	expectError(t, s, "User driver is not valid", "queryRecords", `{"docType":"order"}`)
+	s.caller = "admin"
+	expectError(t, s, "User driver is SUSPENDED and cannot be the driver of an order",
+		"initOrder", "order1", "上海", "北京", "煤炭", "20", "4000", "WAIT_DRIVER_ACCEPT", "owner", "broker", "driver")
+
+	mustInvoke(t, s, "activateUser", "driver", "证件已更新")
+	if user := readUser(t, s, "driver"); user.Status != "ACTIVE" || !user.Valid {
+		t.Errorf("unexpected activated user %+v", user)
+	}
+	createOrder(t, s, "order1")
+
+	mustInvoke(t, s, "deactivateUser", "arbitrator", "离职")
+	expectError(t, s, "cannot change from DEACTIVATED to ACTIVE", "activateUser", "arbitrator", "回来了")
+	expectError(t, s, "is DEACTIVATED", "updateUser", "arbitrator", "仲裁员", "arbitrator", "13800000004")
+	expectError(t, s, "Incorrect number of arguments", "activateUser", "driver")
+}
+
+func TestUsersWithOpenOrdersCannotBeDeactivated(t *testing.T) {
+	s := newFixture(t)
+	createOrder(t, s, "order1")
+	expectError(t, s, "driver has open orders", "deactivateUser", "driver", "离职")
+	expectError(t, s, "owner has open orders", "deleteUser", "owner")
+	mustInvoke(t, s, "suspendUser", "driver", "休假")
+
+	mustInvoke(t, s, "cancelOrder", "order1", "owner", "no longer needed")
+	mustInvoke(t, s, "deactivateUser", "driver", "离职")
+	if user := readUser(t, s, "driver"); user.Status != "DEACTIVATED" {
+		t.Errorf("unexpected deactivated user %+v", user)
+	}
+}
+
+func TestOnlyAdminsChangeUserStatus(t *testing.T) {
+	s := newFixture(t)
+	s.caller = "broker"
+	expectError(t, s, "Only an admin can change the status of users", "suspendUser", "driver", "投诉")
+}
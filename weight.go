@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ====WEIGHBRIDGE (CLI) ===================================================================
+// 装货过磅 peer chaincode invoke -C myc1 -n orders -c '{"Args":["recordWeighbridge","orderId0","LOADING","20.05","ticketSha256","driverId"]}'
+// 卸货过磅 peer chaincode invoke -C myc1 -n orders -c '{"Args":["recordWeighbridge","orderId0","UNLOADING","19.20","ticketSha256","goodsOwnerId"]}'
+// 处理磅差 peer chaincode invoke -C myc1 -n orders -c '{"Args":["resolveWeightFlag","orderId0","goodsOwnerId","接受磅差"]}'
+//
+// A reading is recorded by a party of the order, in their own name. Once both readings are
+// in, the variance is computed and the order is flagged when it is beyond the configured
+// weightTolerancePercent. A flagged order cannot be SIGNED until the goods owner or an
+// arbitrator resolves the flag.
+// =========================================================================================
+
+func weightKey(stub shim.ChaincodeStubInterface, orderId string) (string, error) {
+	return stub.CreateCompositeKey("weight", []string{orderId})
+}
+
+func getWeightReconciliation(stub shim.ChaincodeStubInterface, orderId string) (*WeightReconciliation, error) {
+	key, err := weightKey(stub, orderId)
+	if err != nil {
+		return nil, err
+	}
+	weightAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	} else if weightAsBytes == nil {
+		return nil, nil
+	}
+	weight := &WeightReconciliation{}
+	err = json.Unmarshal(weightAsBytes, weight)
+	return weight, err
+}
+
+func putWeightReconciliation(stub shim.ChaincodeStubInterface, weight *WeightReconciliation) error {
+	key, err := weightKey(stub, weight.OrderId)
+	if err != nil {
+		return err
+	}
+	weightAsBytes, err := json.Marshal(weight)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, weightAsBytes)
+}
+
+// checkWeightCleared refuses to let a flagged order be signed until the flag is resolved
+func checkWeightCleared(stub shim.ChaincodeStubInterface, orderId string) error {
+	weight, err := getWeightReconciliation(stub, orderId)
+	if err != nil {
+		return err
+	}
+	if weight != nil && weight.Flagged && !weight.Resolved {
+		return fmt.Errorf("Order %s has a weight variance of %.2f%% that must be resolved before signing", orderId, weight.VariancePercent)
+	}
+	return nil
+}
+
+// ============================================================
+// recordWeighbridge - record the loading or unloading weighbridge reading of an order
+// ============================================================
+func (t *SimpleChaincode) recordWeighbridge(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0       		1       	2     		3				4 (optional)
+	// "orderId0", "LOADING", "20.05", "ticketHash", "recordedBy"
+	if len(args) != 4 && len(args) != 5 {
+		return shim.Error("Incorrect number of arguments. Expecting 4 or 5")
+	}
+	if len(args[3]) <= 0 {
+		return shim.Error("4th argument must be a non-empty string")
+	}
+	claimedRecorder := ""
+	if len(args) == 5 {
+		claimedRecorder = args[4]
+	}
+	fmt.Println("- start recordWeighbridge")
+	orderId := args[0]
+	stage := strings.ToUpper(args[1])
+	weightTon, err := strconv.ParseFloat(args[2], 64)
+	if err != nil || weightTon <= 0 {
+		return shim.Error("3rd argument must be a positive number")
+	}
+
+	order, err := getOrder(stub, orderId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !order.Open {
+		return shim.Error("Order " + orderId + " is closed")
+	}
+	recordedBy, err := actingUserId(stub, claimedRecorder)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = requirePartyRole(order, recordedBy, "goodsOwner", "broker", "driver", "consignee")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	weight, err := getWeightReconciliation(stub, orderId)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if weight == nil {
+		weight = &WeightReconciliation{ObjectType: "weightReconciliation", OrderId: orderId}
+	}
+
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	reading := &WeighbridgeReading{weightTon, args[3], recordedBy, txTime.Format(time.RFC3339)}
+	switch stage {
+	case "LOADING":
+		if weight.Loading != nil {
+			return shim.Error("Loading weight of order " + orderId + " has already been recorded")
+		}
+		weight.Loading = reading
+	case "UNLOADING":
+		if weight.Loading == nil {
+			return shim.Error("Loading weight of order " + orderId + " must be recorded first")
+		}
+		if weight.Unloading != nil {
+			return shim.Error("Unloading weight of order " + orderId + " has already been recorded")
+		}
+		weight.Unloading = reading
+
+		config, err := getConfig(stub)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		weight.VarianceTon = weight.Unloading.WeightTon - weight.Loading.WeightTon
+		weight.VariancePercent = math.Abs(weight.VarianceTon) / weight.Loading.WeightTon * 100
+		weight.Flagged = weight.VariancePercent > config.WeightTolerancePercent
+	default:
+		return shim.Error("2nd argument must be LOADING or UNLOADING")
+	}
+
+	err = putWeightReconciliation(stub, weight)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Println("- end recordWeighbridge")
+	return shim.Success(nil)
+}
+
+// markWeightResolved clears the flag of an order, if it has one
+func markWeightResolved(stub shim.ChaincodeStubInterface, orderId string, resolverId string, resolution string) error {
+	weight, err := getWeightReconciliation(stub, orderId)
+	if err != nil {
+		return err
+	} else if weight == nil || !weight.Flagged || weight.Resolved {
+		return nil
+	}
+	weight.Resolved = true
+	weight.ResolvedBy = resolverId
+	weight.Resolution = resolution
+	return putWeightReconciliation(stub, weight)
+}
+
+// ============================================================
+// resolveWeightFlag - goods owner or arbitrator accepts a weight variance
+// ============================================================
+func (t *SimpleChaincode) resolveWeightFlag(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	//   0       		1       		2
+	// "orderId0", "goodsOwnerId", "resolution"
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	if len(args[2]) <= 0 {
+		return shim.Error("3rd argument must be a non-empty string")
+	}
+	fmt.Println("- start resolveWeightFlag")
+	orderId := args[0]
+	resolverId, err := actingUserId(stub, args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	order, err := getOrder(stub, orderId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if resolverId != order.GoodsOwnerId {
+		resolver, err := getUser(stub, resolverId)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if resolver.Role != "arbitrator" || !resolver.Valid {
+			return shim.Error("Only the goods owner or an arbitrator can resolve the weight of order " + orderId)
+		}
+	}
+	weight, err := getWeightReconciliation(stub, orderId)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if weight == nil || !weight.Flagged || weight.Resolved {
+		return shim.Error("Order " + orderId + " has no unresolved weight variance")
+	}
+
+	err = markWeightResolved(stub, orderId, resolverId, args[2])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	res := writeToRecordsLedger(stub, *order, "WEIGHT_RESOLVED")
+	if res.Status != shim.OK {
+		return res
+	}
+	fmt.Println("- end resolveWeightFlag")
+	return shim.Success(nil)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestWeightWithinTolerance(t *testing.T) {
+	s := newFixture(t)
+	createOrder(t, s, "order1")
+	s.caller = "driver"
+	mustInvoke(t, s, "recordWeighbridge", "order1", "LOADING", "20", "ticket1")
+	s.caller = "admin"
+	deliverOrder(t, s, "order1")
+	mustInvoke(t, s, "recordWeighbridge", "order1", "unloading", "19.95", "ticket2", "owner")
+
+	weight, err := getWeightReconciliation(s, "order1")
+	if err != nil || weight.Flagged || weight.Unloading.TicketHash != "ticket2" || weight.Loading.RecordedBy != "driver" ||
+		weight.Unloading.RecordedBy != "owner" {
+		t.Errorf("unexpected weight %+v, %v", weight, err)
+	}
+	signOrder(t, s, "order1")
+}
+
+func TestWeightBeyondToleranceBlocksSigning(t *testing.T) {
+	s := newFixture(t)
+	createOrder(t, s, "order1")
+	mustInvoke(t, s, "recordWeighbridge", "order1", "LOADING", "20", "ticket1", "driver")
+	deliverOrder(t, s, "order1")
+	mustInvoke(t, s, "recordWeighbridge", "order1", "UNLOADING", "19", "ticket2", "owner")
+
+	weight, _ := getWeightReconciliation(s, "order1")
+	if !weight.Flagged || weight.VarianceTon != -1 || weight.VariancePercent != 5 {
+		t.Errorf("unexpected weight %+v", weight)
+	}
+	expectError(t, s, "is signed by submitting a proof of delivery", "changeStateOrder", "order1", "SIGNED")
+	args := podArgs(t, s, "order1")
+	s.caller = "owner"
+	expectError(t, s, "must be resolved before signing", "signDelivery", args...)
+	s.caller = "admin"
+
+	expectError(t, s, "Only the goods owner or an arbitrator", "resolveWeightFlag", "order1", "driver", "ok")
+	mustInvoke(t, s, "resolveWeightFlag", "order1", "owner", "接受磅差")
+	expectError(t, s, "no unresolved weight variance", "resolveWeightFlag", "order1", "owner", "ok")
+	signOrder(t, s, "order1")
+}
+
+func TestRecordWeighbridgeArguments(t *testing.T) {
+	s := newFixture(t)
+	createOrder(t, s, "order1")
+	expectError(t, s, "must be recorded first", "recordWeighbridge", "order1", "UNLOADING", "19", "ticket2", "owner")
+	expectError(t, s, "LOADING or UNLOADING", "recordWeighbridge", "order1", "WEIGHING", "19", "ticket2", "owner")
+	expectError(t, s, "3rd argument must be a positive number", "recordWeighbridge", "order1", "LOADING", "0", "ticket1", "driver")
+	expectError(t, s, "4th argument must be a non-empty string", "recordWeighbridge", "order1", "LOADING", "20", "", "driver")
+	expectError(t, s, "Order does not exist", "recordWeighbridge", "missing", "LOADING", "20", "ticket1", "driver")
+	mustInvoke(t, s, "recordWeighbridge", "order1", "LOADING", "20", "ticket1", "driver")
+	expectError(t, s, "has already been recorded", "recordWeighbridge", "order1", "LOADING", "20", "ticket1", "driver")
+	expectError(t, s, "Incorrect number of arguments", "recordWeighbridge", "order1")
+	s.caller = "driver"
+	expectError(t, s, "User driver cannot act as owner", "recordWeighbridge", "order1", "UNLOADING", "19", "ticket2", "owner")
+	s.caller = "arbitrator"
+	expectError(t, s, "User arbitrator is not the goodsOwner, broker, driver or consignee of order order1",
+		"recordWeighbridge", "order1", "UNLOADING", "19", "ticket2")
+	s.caller = "admin"
+	// an admin records in the name of a party, and is not one
+	expectError(t, s, "User arbitrator is not the goodsOwner, broker, driver or consignee of order order1",
+		"recordWeighbridge", "order1", "UNLOADING", "19", "ticket2", "arbitrator")
+	expectError(t, s, "User admin is not the goodsOwner, broker, driver or consignee of order order1",
+		"recordWeighbridge", "order1", "UNLOADING", "19", "ticket2")
+	expectError(t, s, "Incorrect number of arguments", "resolveWeightFlag", "order1")
+}